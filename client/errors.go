@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/pkg/errors"
+)
+
+// transientError marks an error which is worth retrying although it is not a GitHub API error, e.g. a store failure.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// Transient marks the error as worth retrying.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err: err}
+}
+
+/*
+Classify errors which will fail again on retry.
+
+Rate limit errors, server errors, network errors, timeouts and errors marked with Transient
+are transient. Any other client error response (bad request, not found, validation...) is permanent,
+as are local errors such as template rendering or missing configuration.
+*/
+func IsPermanentError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var transientErr *transientError
+	if errors.As(err, &transientErr) {
+		return false
+	}
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return false
+	}
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseRateLimitErr) {
+		return false
+	}
+	var responseErr *github.ErrorResponse
+	if errors.As(err, &responseErr) && responseErr.Response != nil {
+		statusCode := responseErr.Response.StatusCode
+		if statusCode == http.StatusTooManyRequests || statusCode == http.StatusRequestTimeout {
+			return false
+		}
+		return statusCode >= 400 && statusCode < 500
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return true
+}
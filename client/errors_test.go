@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPermanentError(t *testing.T) {
+	responseError := func(statusCode int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: statusCode}}
+	}
+	type test struct {
+		name string
+		err  error
+		want bool
+	}
+	tests := []test{
+		{name: "No Error", err: nil, want: false},
+		{name: "Network Error", err: &url.Error{Op: "Post", URL: "https://api.github.com", Err: errors.New("connection reset by peer")}, want: false},
+		{name: "Timeout", err: errors.Wrap(context.DeadlineExceeded, "dispatch"), want: false},
+		{name: "Transient Error", err: errors.Wrap(Transient(errors.New("redis is unavailable")), "store"), want: false},
+		{name: "Local Error", err: errors.New("template: inputs:1: unexpected EOF"), want: true},
+		{name: "Server Error", err: responseError(http.StatusBadGateway), want: false},
+		{name: "Too Many Requests", err: responseError(http.StatusTooManyRequests), want: false},
+		{name: "Rate Limit", err: &github.RateLimitError{Response: &http.Response{StatusCode: http.StatusForbidden}}, want: false},
+		{name: "Secondary Rate Limit", err: &github.AbuseRateLimitError{Response: &http.Response{StatusCode: http.StatusForbidden}}, want: false},
+		{name: "Not Found", err: responseError(http.StatusNotFound), want: true},
+		{name: "Validation Failed", err: responseError(http.StatusUnprocessableEntity), want: true},
+		{name: "Wrapped Not Found", err: errors.Wrap(responseError(http.StatusNotFound), "dispatch"), want: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, IsPermanentError(tc.err))
+		})
+	}
+}
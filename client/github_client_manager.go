@@ -2,14 +2,19 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/go-redis/redis/v8"
 	"github.com/google/go-github/v45/github"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/store"
 	"github.com/mattermost/release-bot/version"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -17,28 +22,67 @@ import (
 
 const (
 	ClientCacheSize = 128
+	// Installations found for repositories are cached apart from clients, so they do not evict each other.
+	InstallationCacheSize = 1024
+	// Installations found for repositories are looked up again after this, apps can be installed again with another id.
+	installationCacheTTL = time.Hour
 )
 
+// GithubClientManager provides clients of the installations of the apps, the first app is used if the app name is empty.
 type GithubClientManager interface {
-	Get(installationID int64) (*github.Client, error)
-	CreateToken(repository string, runID int64, installationID int64) (AccessToken, error)
+	Get(app string, installationID int64) (*github.Client, error)
+	// GetForRepository returns the client of the installation of the app which covers the repository.
+	GetForRepository(app string, owner string, repository string) (*github.Client, error)
+	// CreateToken creates a token for the workflow run, narrowed by the options if they are given.
+	CreateToken(app string, repository string, runID int64, installationID int64, options *github.InstallationTokenOptions) (AccessToken, error)
 	RevokeToken(repository string, runID int64) error
+	// GetBotLogin returns the login of the bot user of the app, which is the actor of the workflow runs it dispatches.
+	GetBotLogin(app string) (string, error)
 }
 
 type AccessToken interface {
 	GetInstallationID() int64
 	IsExpired() bool
 	GetToken() string
+	GetExpiresAt() time.Time
+}
+
+// App is a GitHub App release bot authenticates as, its client is authenticated as the app itself.
+type App struct {
+	Name       string
+	ID         int64
+	PrivateKey *PrivateKey
+	Client     *github.Client
 }
 
 type clientCache struct {
-	appID              int64
-	privateKeyFile     string
+	apps               []App
 	userAgent          string
 	cache              *lru.Cache
+	installations      *lru.Cache
 	transport          http.RoundTripper
-	appClient          *github.Client
-	installationTokens map[string]AccessToken
+	installationTokens InstallationTokenStore
+	// botLogins keeps the bot login of each app, it does not change while the app exists.
+	botLogins sync.Map
+}
+
+// installationKey identifies installation clients, installation ids are only unique per app.
+type installationKey struct {
+	app            string
+	installationID int64
+	// Clients signing with a replaced private key are not used anymore, they are evicted from the cache in time.
+	keyVersion int
+}
+
+// repositoryKey identifies the installation found for a repository.
+type repositoryKey struct {
+	app        string
+	repository string
+}
+
+type foundInstallation struct {
+	installationID int64
+	foundAt        time.Time
 }
 
 type accessToken struct {
@@ -47,83 +91,240 @@ type accessToken struct {
 	expiresAt      time.Time
 }
 
-func BuildFromConfig(config *config.Config) (GithubClientManager, error) {
+// BuildFromConfig creates the client manager, installation tokens are kept in redis when the shared redis client is given.
+func BuildFromConfig(config *config.Config, redisClient *redis.Client) (GithubClientManager, error) {
 	cache, err := lru.New(ClientCacheSize)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create cache")
 	}
-	version := version.Full()
-	itr, err := ghinstallation.NewAppsTransportKeyFromFile(http.DefaultTransport, config.Github.IntegrationID, config.Github.PrivateKey)
+	installations, err := lru.New(InstallationCacheSize)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Can not initialize GitHub client! Check configuration values.")
+		return nil, errors.Wrapf(err, "failed to create installation cache")
+	}
+	version := version.Full()
+	var apps []App
+	for _, appConfig := range config.Github.GetApps() {
+		privateKey, err := LoadPrivateKey(appConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can not initialize GitHub client of app %s! Check configuration values.", appConfig.Name)
+		}
+		itr := &appTransport{tr: http.DefaultTransport, appID: appConfig.IntegrationID, key: privateKey}
+		appClient, err := NewGithubClient(&http.Client{Transport: itr}, config.Github.BaseURL, config.Github.UploadURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can not initialize GitHub client of app %s! Check configuration values.", appConfig.Name)
+		}
+		if err := ValidateTokenPermissions(appClient, config.Pipelines); err != nil {
+			return nil, errors.Wrapf(err, "app %s", appConfig.Name)
+		}
+		apps = append(apps, App{
+			Name:       appConfig.Name,
+			ID:         appConfig.IntegrationID,
+			PrivateKey: privateKey,
+			Client:     appClient,
+		})
+	}
+	tokenStore := NewMemoryInstallationTokenStore()
+	if redisClient != nil {
+		tokenStore = NewRedisInstallationTokenStore(redisClient, store.RedisKeyPrefix(config.Store.Redis))
 	}
 	return New(
-		config.Github.IntegrationID,
-		config.Github.PrivateKey,
+		apps,
 		cache,
+		installations,
 		http.DefaultTransport,
 		fmt.Sprintf("%s/%s", version.Name, version.Version),
-		github.NewClient(&http.Client{Transport: itr}),
+		tokenStore,
 	), nil
 }
 
+/*
+NewGithubClient creates a client of the GitHub Enterprise Server at the base url, or of github.com if the base url is not set.
+The upload url defaults to the base url of the server.
+*/
+func NewGithubClient(httpClient *http.Client, baseURL string, uploadURL string) (*github.Client, error) {
+	if baseURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+	if uploadURL == "" {
+		uploadURL = strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/api/v3")
+	}
+	return github.NewEnterpriseClient(baseURL, uploadURL, httpClient)
+}
+
+// apiBaseURL returns the base url of the client without its trailing slash, as installation transports expect it.
+func apiBaseURL(client *github.Client) string {
+	return strings.TrimSuffix(client.BaseURL.String(), "/")
+}
+
+/*
+ValidateTokenPermissions rejects pipelines requesting token permissions the app is not granted.
+Tokens are created by the app which received the event of the dispatch, so every app must grant them.
+*/
+func ValidateTokenPermissions(appClient *github.Client, pipelines []config.PipelineConfig) error {
+	var scoped []config.PipelineConfig
+	for _, pipeline := range pipelines {
+		if len(pipeline.Token.Permissions) > 0 {
+			scoped = append(scoped, pipeline)
+		}
+	}
+	if len(scoped) == 0 {
+		return nil
+	}
+	app, _, err := appClient.Apps.Get(context.Background(), "")
+	if err != nil {
+		return errors.Wrap(err, "Can not get GitHub App permissions!")
+	}
+	granted := make(map[string]string)
+	encoded, _ := json.Marshal(app.GetPermissions())
+	if err := json.Unmarshal(encoded, &granted); err != nil {
+		return errors.Wrap(err, "Can not read GitHub App permissions!")
+	}
+	for _, pipeline := range scoped {
+		if missing := pipeline.Token.MissingPermissions(granted); len(missing) > 0 {
+			return fmt.Errorf("pipeline %s requests token permissions the app does not have: %s", pipeline.Key(), strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
+
 func New(
-	appID int64,
-	privateKeyFile string,
+	apps []App,
 	cache *lru.Cache,
+	installations *lru.Cache,
 	transport http.RoundTripper,
 	userAgent string,
-	appClient *github.Client,
+	tokenStore InstallationTokenStore,
 ) GithubClientManager {
 	return &clientCache{
-		appID:              appID,
-		privateKeyFile:     privateKeyFile,
+		apps:               apps,
 		cache:              cache,
+		installations:      installations,
 		transport:          transport,
 		userAgent:          userAgent,
-		appClient:          appClient,
-		installationTokens: make(map[string]AccessToken),
+		installationTokens: tokenStore,
 	}
 }
 
-func (cc *clientCache) Get(installationID int64) (*github.Client, error) {
-	cli, ok := cc.cache.Get(installationID)
-	if ok {
-		return cli.(*github.Client), nil
+func (cc *clientCache) app(name string) (App, error) {
+	if name == "" {
+		return cc.apps[0], nil
+	}
+	for _, app := range cc.apps {
+		if app.Name == name {
+			return app, nil
+		}
 	}
-	itr, err := ghinstallation.NewKeyFromFile(
-		cc.transport,
-		cc.appID,
-		installationID,
-		cc.privateKeyFile,
-	)
+	return App{}, fmt.Errorf("app %s is not configured", name)
+}
+
+func (cc *clientCache) Get(appName string, installationID int64) (*github.Client, error) {
+	app, err := cc.app(appName)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Can not initialize GitHub client! Check configuration values.")
+		return nil, err
+	}
+	privateKey, keyVersion := app.PrivateKey.Get()
+	key := installationKey{app: app.Name, installationID: installationID, keyVersion: keyVersion}
+	cli, ok := cc.cache.Get(key)
+	if ok {
+		return cli.(*github.Client), nil
 	}
+	itr := ghinstallation.NewFromAppsTransport(ghinstallation.NewAppsTransportFromPrivateKey(cc.transport, app.ID, privateKey), installationID)
+	// Installation clients use the server of the app client.
+	itr.BaseURL = apiBaseURL(app.Client)
 	client := github.NewClient(&http.Client{Transport: itr})
+	baseURL, uploadURL := *app.Client.BaseURL, *app.Client.UploadURL
+	client.BaseURL, client.UploadURL = &baseURL, &uploadURL
 	client.UserAgent = cc.userAgent
-	cc.cache.Add(installationID, client)
+	cc.cache.Add(key, client)
 	return client, nil
 }
 
-func (cc *clientCache) CreateToken(repository string, runID int64, installationID int64) (AccessToken, error) {
+func (cc *clientCache) GetForRepository(appName string, owner string, repository string) (*github.Client, error) {
+	app, err := cc.app(appName)
+	if err != nil {
+		return nil, err
+	}
+	installationID, err := cc.findInstallation(app, owner, repository)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Get(app.Name, installationID)
+}
+
+/*
+findInstallation finds the installation of the app on the repository.
+Found installations are cached, so dispatches do not look them up each time.
+*/
+func (cc *clientCache) findInstallation(app App, owner string, repository string) (int64, error) {
+	key := repositoryKey{app: app.Name, repository: owner + "/" + repository}
+	if found, ok := cc.installations.Get(key); ok && time.Since(found.(foundInstallation).foundAt) < installationCacheTTL {
+		return found.(foundInstallation).installationID, nil
+	}
+	installation, res, err := app.Client.Apps.FindRepositoryInstallation(context.Background(), owner, repository)
+	if err != nil && res != nil && res.StatusCode == http.StatusNotFound {
+		return 0, errors.Wrapf(err, "App %s is not installed on %s!", app.Name, key.repository)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "Can not find installation of app %s for %s!", app.Name, key.repository)
+	}
 	log.WithFields(log.Fields{
+		"app":             app.Name,
+		"repository":      key.repository,
+		"installation_id": installation.GetID(),
+	}).Info("Installation is found for repository")
+	cc.installations.Add(key, foundInstallation{installationID: installation.GetID(), foundAt: time.Now()})
+	return installation.GetID(), nil
+}
+
+func (cc *clientCache) GetBotLogin(appName string) (string, error) {
+	app, err := cc.app(appName)
+	if err != nil {
+		return "", err
+	}
+	if login, ok := cc.botLogins.Load(app.Name); ok {
+		return login.(string), nil
+	}
+	ghApp, _, err := app.Client.Apps.Get(context.Background(), "")
+	if err != nil {
+		return "", errors.Wrapf(err, "Can not get GitHub App %s!", app.Name)
+	}
+	login := ghApp.GetSlug() + "[bot]"
+	cc.botLogins.Store(app.Name, login)
+	return login, nil
+}
+
+func (cc *clientCache) CreateToken(appName string, repository string, runID int64, installationID int64, options *github.InstallationTokenOptions) (AccessToken, error) {
+	app, err := cc.app(appName)
+	if err != nil {
+		return nil, err
+	}
+	if options == nil {
+		options = &github.InstallationTokenOptions{}
+	}
+	log.WithFields(log.Fields{
+		"app":             app.Name,
 		"repository":      repository,
 		"run_id":          runID,
 		"installation_id": installationID,
+		"scoped":          len(options.Repositories) > 0 || options.Permissions != nil,
 	}).Info("Github Installation Token requested")
 	mapKey := fmt.Sprintf("%s-%v", repository, runID)
-	token, found := cc.installationTokens[mapKey]
+	token, found, err := cc.installationTokens.Get(mapKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can not read stored access token!")
+	}
 	if found && !token.IsExpired() && token.GetInstallationID() == installationID {
 		log.Info("Using non-expired repository github token")
 		return token, nil
 	}
-	ghToken, _, err := cc.appClient.Apps.CreateInstallationToken(context.Background(), installationID, &github.InstallationTokenOptions{})
+	ghToken, _, err := app.Client.Apps.CreateInstallationToken(context.Background(), installationID, options)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Can not create access token!")
 	}
 	token = newAccessToken(installationID, ghToken.GetToken(), ghToken.GetExpiresAt())
-	cc.installationTokens[mapKey] = token
+	if err := cc.installationTokens.Set(mapKey, token); err != nil {
+		return nil, errors.Wrapf(err, "Can not store access token!")
+	}
 	return token, nil
 }
 
@@ -135,7 +336,10 @@ func (cc *clientCache) RevokeToken(repository string, runID int64) error {
 		}).
 		Info("Will revoke token for workflow run.")
 	mapKey := fmt.Sprintf("%s-%v", repository, runID)
-	token, found := cc.installationTokens[mapKey]
+	token, found, err := cc.installationTokens.Get(mapKey)
+	if err != nil {
+		return errors.Wrap(err, "Can not read stored access token!")
+	}
 	if !found {
 		log.WithFields(log.Fields{
 			"repository": repository,
@@ -143,8 +347,8 @@ func (cc *clientCache) RevokeToken(repository string, runID int64) error {
 		}).Info("No token is created.")
 		return nil
 	}
-	client := &http.Client{}
-	req, err := http.NewRequest("DELETE", "https://api.github.com/installation/token", nil)
+	client := &http.Client{Transport: cc.transport}
+	req, err := http.NewRequest("DELETE", apiBaseURL(cc.apps[0].Client)+"/installation/token", nil)
 	if err != nil {
 		log.
 			WithFields(log.Fields{
@@ -179,6 +383,15 @@ func (cc *clientCache) RevokeToken(repository string, runID int64) error {
 			Error("Github Token invalidation error")
 		return errors.New("Token invalidation error!")
 	}
+	if err := cc.installationTokens.Delete(mapKey); err != nil {
+		log.
+			WithFields(log.Fields{
+				"repository": repository,
+				"run_id":     runID,
+			}).
+			WithError(err).
+			Warn("Can not remove invalidated token from store")
+	}
 	log.
 		WithFields(log.Fields{
 			"repository": repository,
@@ -205,3 +418,6 @@ func (t *accessToken) GetToken() string {
 func (t *accessToken) GetInstallationID() int64 {
 	return t.installationID
 }
+func (t *accessToken) GetExpiresAt() time.Time {
+	return t.expiresAt
+}
@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-github/v45/github"
+	"github.com/mattermost/release-bot/config"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTokenPermissions(t *testing.T) {
+	requests := 0
+	appClient := github.NewClient(mock.NewMockedHTTPClient(
+		mock.WithRequestMatchHandler(
+			mock.GetApp,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Write(mock.MustMarshal(github.App{
+					Permissions: &github.InstallationPermissions{
+						Contents: github.String("write"),
+						Statuses: github.String("read"),
+					},
+				}))
+			}),
+		),
+	))
+	pipeline := func(permissions map[string]string) config.PipelineConfig {
+		return config.PipelineConfig{
+			Organization: "mattermost",
+			Repository:   "delivery",
+			Workflow:     "build.yml",
+			Token:        config.TokenConfig{Permissions: permissions},
+		}
+	}
+
+	t.Run("Unscoped Pipelines", func(t *testing.T) {
+		assert.Nil(t, ValidateTokenPermissions(appClient, []config.PipelineConfig{pipeline(nil)}))
+		assert.Equal(t, 0, requests)
+	})
+	t.Run("Granted Permissions", func(t *testing.T) {
+		assert.Nil(t, ValidateTokenPermissions(appClient, []config.PipelineConfig{pipeline(map[string]string{"contents": "read", "statuses": "read"})}))
+	})
+	t.Run("Missing Permissions", func(t *testing.T) {
+		err := ValidateTokenPermissions(appClient, []config.PipelineConfig{pipeline(map[string]string{"statuses": "write", "issues": "read"})})
+		assert.EqualError(t, err, "pipeline mattermost/delivery/build.yml requests token permissions the app does not have: issues: read, statuses: write")
+	})
+}
+
+func encodePrivateKey(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func writePrivateKey(t *testing.T) string {
+	keyFile := filepath.Join(t.TempDir(), "private_key.pem")
+	assert.Nil(t, os.WriteFile(keyFile, encodePrivateKey(t), 0600))
+	return keyFile
+}
+
+func TestEnterpriseServer(t *testing.T) {
+	keyFile := writePrivateKey(t)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v3/app/installations/100/access_tokens":
+			w.WriteHeader(http.StatusCreated)
+			expiresAt := time.Now().Add(time.Hour)
+			w.Write(mock.MustMarshal(github.InstallationToken{Token: github.String("ghs_enterprise"), ExpiresAt: &expiresAt}))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v3/repos/mattermost/delivery":
+			assert.Equal(t, "token ghs_enterprise", r.Header.Get("Authorization"))
+			w.Write(mock.MustMarshal(github.Repository{FullName: github.String("mattermost/delivery")}))
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v3/installation/token":
+			assert.Equal(t, "Bearer ghs_enterprise", r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cc, err := BuildFromConfig(&config.Config{Github: config.GithubConfig{IntegrationID: 12345, PrivateKey: keyFile, BaseURL: server.URL}}, nil)
+	assert.Nil(t, err)
+
+	t.Run("Installation Client", func(t *testing.T) {
+		client, err := cc.Get("", 100)
+		assert.Nil(t, err)
+		assert.Equal(t, server.URL+"/api/v3/", client.BaseURL.String())
+		assert.Equal(t, server.URL+"/api/uploads/", client.UploadURL.String())
+		repository, _, err := client.Repositories.Get(context.Background(), "mattermost", "delivery")
+		assert.Nil(t, err)
+		assert.Equal(t, "mattermost/delivery", repository.GetFullName())
+	})
+	t.Run("Create And Revoke Token", func(t *testing.T) {
+		token, err := cc.CreateToken("", "mattermost/delivery", 1, 100, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "ghs_enterprise", token.GetToken())
+		assert.Nil(t, cc.RevokeToken("mattermost/delivery", 1))
+		assert.Equal(t, "DELETE /api/v3/installation/token", requests[len(requests)-1])
+	})
+}
+
+func TestApps(t *testing.T) {
+	keyFile := writePrivateKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/repos/private/delivery/installation" {
+			w.Write(mock.MustMarshal(github.Installation{ID: github.Int64(100)}))
+			return
+		}
+		// Tokens are created by the app the JWT is issued by.
+		claims := jwt.RegisteredClaims{}
+		_, _, err := jwt.NewParser().ParseUnverified(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), &claims)
+		assert.Nil(t, err)
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v3/app" {
+			w.Write(mock.MustMarshal(github.App{Slug: github.String("app-" + claims.Issuer)}))
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v3/app/installations/100/access_tokens" {
+			http.NotFound(w, r)
+			return
+		}
+		expiresAt := time.Now().Add(time.Hour)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(mock.MustMarshal(github.InstallationToken{Token: github.String("ghs_" + claims.Issuer), ExpiresAt: &expiresAt}))
+	}))
+	defer server.Close()
+
+	cc, err := BuildFromConfig(&config.Config{Github: config.GithubConfig{
+		IntegrationID: 12345,
+		PrivateKey:    keyFile,
+		BaseURL:       server.URL,
+		Apps:          []config.AppConfig{{Name: "private", IntegrationID: 67890, PrivateKey: keyFile}},
+	}}, nil)
+	assert.Nil(t, err)
+
+	t.Run("Installation Clients", func(t *testing.T) {
+		defaultClient, err := cc.Get("", 100)
+		assert.Nil(t, err)
+		privateClient, err := cc.Get("private", 100)
+		assert.Nil(t, err)
+		assert.NotSame(t, defaultClient, privateClient)
+		cached, _ := cc.Get(config.DefaultAppName, 100)
+		assert.Same(t, defaultClient, cached)
+		_, err = cc.Get("unknown", 100)
+		assert.Error(t, err)
+	})
+	t.Run("Installation On Repository", func(t *testing.T) {
+		client, err := cc.GetForRepository("private", "private", "delivery")
+		assert.Nil(t, err)
+		cached, _ := cc.Get("private", 100)
+		assert.Same(t, cached, client)
+		_, err = cc.GetForRepository("private", "private", "unknown")
+		assert.True(t, IsPermanentError(err))
+	})
+	t.Run("Create Token", func(t *testing.T) {
+		token, err := cc.CreateToken("private", "mattermost/delivery", 1, 100, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "ghs_67890", token.GetToken())
+		token, err = cc.CreateToken("", "mattermost/delivery", 2, 100, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "ghs_12345", token.GetToken())
+	})
+	t.Run("Bot Login", func(t *testing.T) {
+		login, err := cc.GetBotLogin("private")
+		assert.Nil(t, err)
+		assert.Equal(t, "app-67890[bot]", login)
+		login, err = cc.GetBotLogin("")
+		assert.Nil(t, err)
+		assert.Equal(t, "app-12345[bot]", login)
+		_, err = cc.GetBotLogin("unknown")
+		assert.Error(t, err)
+	})
+	t.Run("Key Rotation", func(t *testing.T) {
+		client, _ := cc.Get("private", 100)
+		privateKey := cc.(*clientCache).apps[1].PrivateKey
+		privateKey.checkInterval = 0
+		assert.Nil(t, os.WriteFile(keyFile, encodePrivateKey(t), 0600))
+
+		// Clients signing with the replaced key are created again.
+		rotated, err := cc.Get("private", 100)
+		assert.Nil(t, err)
+		assert.NotSame(t, client, rotated)
+		token, err := cc.CreateToken("private", "mattermost/delivery", 3, 100, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "ghs_67890", token.GetToken())
+	})
+}
+
+func TestGetForRepository(t *testing.T) {
+	keyFile := writePrivateKey(t)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v3/repos/private/delivery/installation":
+			w.Write(mock.MustMarshal(github.Installation{ID: github.Int64(200)}))
+		case "/api/v3/app/installations/200/access_tokens":
+			expiresAt := time.Now().Add(time.Hour)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(mock.MustMarshal(github.InstallationToken{Token: github.String("ghs_private"), ExpiresAt: &expiresAt}))
+		case "/api/v3/repos/private/delivery":
+			w.Write(mock.MustMarshal(github.Repository{}))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cc, err := BuildFromConfig(&config.Config{Github: config.GithubConfig{IntegrationID: 12345, PrivateKey: keyFile, BaseURL: server.URL}}, nil)
+	assert.Nil(t, err)
+
+	t.Run("Repository Installation", func(t *testing.T) {
+		client, err := cc.GetForRepository("", "private", "delivery")
+		assert.Nil(t, err)
+		_, _, err = client.Repositories.Get(context.Background(), "private", "delivery")
+		assert.Nil(t, err)
+		assert.Contains(t, requests, "POST /api/v3/app/installations/200/access_tokens")
+
+		// The installation is cached for the repository.
+		requests = nil
+		cached, err := cc.GetForRepository("", "private", "delivery")
+		assert.Nil(t, err)
+		assert.Same(t, client, cached)
+		assert.Empty(t, requests)
+	})
+	t.Run("Not Installed On Repository", func(t *testing.T) {
+		// The installation of the organization is not used, the app must be installed on the repository.
+		requests = nil
+		_, err := cc.GetForRepository("", "private", "other")
+		assert.True(t, IsPermanentError(err))
+		assert.Contains(t, err.Error(), "App default is not installed on private/other!")
+		assert.Equal(t, []string{"GET /api/v3/repos/private/other/installation"}, requests)
+	})
+	t.Run("Not Installed", func(t *testing.T) {
+		_, err := cc.GetForRepository("", "unknown", "delivery")
+		assert.True(t, IsPermanentError(err))
+	})
+	t.Run("Installations Are Cached Apart From Clients", func(t *testing.T) {
+		cc.(*clientCache).cache.Purge()
+		requests = nil
+		_, err := cc.GetForRepository("", "private", "delivery")
+		assert.Nil(t, err)
+		assert.Empty(t, requests)
+	})
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+/*
+InstallationTokenStore keeps installation tokens created for workflow runs, so they can be reused and revoked later.
+Get reports a missing token as not found, and any failure to read the store as an error.
+*/
+type InstallationTokenStore interface {
+	Get(key string) (AccessToken, bool, error)
+	Set(key string, token AccessToken) error
+	Delete(key string) error
+}
+
+type memoryInstallationTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]AccessToken
+}
+
+func NewMemoryInstallationTokenStore() InstallationTokenStore {
+	return &memoryInstallationTokenStore{
+		tokens: make(map[string]AccessToken),
+	}
+}
+
+func (s *memoryInstallationTokenStore) Get(key string) (AccessToken, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	token, found := s.tokens[key]
+	return token, found, nil
+}
+
+func (s *memoryInstallationTokenStore) Set(key string, token AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = token
+	return nil
+}
+
+func (s *memoryInstallationTokenStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, key)
+	return nil
+}
+
+type redisInstallationTokenStore struct {
+	client *redis.Client
+	prefix string
+}
+
+type redisInstallationToken struct {
+	InstallationID int64     `json:"installation_id"`
+	Token          string    `json:"token"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+func NewRedisInstallationTokenStore(client *redis.Client, prefix string) InstallationTokenStore {
+	return &redisInstallationTokenStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (s *redisInstallationTokenStore) key(key string) string {
+	return fmt.Sprintf("%s:installation-token:%s", s.prefix, key)
+}
+
+func (s *redisInstallationTokenStore) Get(key string) (AccessToken, bool, error) {
+	data, err := s.client.Get(context.Background(), s.key(key)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "can not read installation token")
+	}
+	var token redisInstallationToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, false, errors.Wrap(err, "can not deserialize installation token")
+	}
+	return newAccessToken(token.InstallationID, token.Token, token.ExpiresAt), true, nil
+}
+
+func (s *redisInstallationTokenStore) Set(key string, token AccessToken) error {
+	data, err := json.Marshal(redisInstallationToken{
+		InstallationID: token.GetInstallationID(),
+		Token:          token.GetToken(),
+		ExpiresAt:      token.GetExpiresAt(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "can not serialize installation token")
+	}
+	// Keep the entry slightly longer than the token itself, so it can still be revoked after expiry.
+	ttl := time.Until(token.GetExpiresAt()) + time.Hour
+	if err := s.client.Set(context.Background(), s.key(key), data, ttl).Err(); err != nil {
+		return errors.Wrap(err, "can not store installation token")
+	}
+	return nil
+}
+
+func (s *redisInstallationTokenStore) Delete(key string) error {
+	return s.client.Del(context.Background(), s.key(key)).Err()
+}
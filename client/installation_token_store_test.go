@@ -0,0 +1,59 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallationTokenStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient, err := store.NewRedisClient(config.RedisConfig{Address: server.Addr()})
+	assert.Nil(t, err)
+	defer redisClient.Close()
+
+	stores := map[string]InstallationTokenStore{
+		"memory": NewMemoryInstallationTokenStore(),
+		"redis":  NewRedisInstallationTokenStore(redisClient, "release-bot"),
+	}
+	for name, tokenStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			expiresAt := time.Now().Add(time.Hour).Round(time.Second)
+			token, found, err := tokenStore.Get("mattermost/release-bot-1")
+			assert.Nil(t, err)
+			assert.False(t, found)
+			assert.Nil(t, token)
+
+			assert.Nil(t, tokenStore.Set("mattermost/release-bot-1", newAccessToken(100, "gh-12345678", expiresAt)))
+			token, found, err = tokenStore.Get("mattermost/release-bot-1")
+			assert.Nil(t, err)
+			assert.True(t, found)
+			assert.Equal(t, int64(100), token.GetInstallationID())
+			assert.Equal(t, "gh-12345678", token.GetToken())
+			assert.True(t, expiresAt.Equal(token.GetExpiresAt()))
+			assert.False(t, token.IsExpired())
+
+			assert.Nil(t, tokenStore.Delete("mattermost/release-bot-1"))
+			_, found, err = tokenStore.Get("mattermost/release-bot-1")
+			assert.Nil(t, err)
+			assert.False(t, found)
+		})
+	}
+	t.Run("Redis Unavailable", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		redisClient, err := store.NewRedisClient(config.RedisConfig{Address: server.Addr()})
+		assert.Nil(t, err)
+		defer redisClient.Close()
+		tokenStore := NewRedisInstallationTokenStore(redisClient, "release-bot")
+		server.Close()
+
+		token, found, err := tokenStore.Get("mattermost/release-bot-1")
+		assert.Error(t, err)
+		assert.False(t, found)
+		assert.Nil(t, token)
+	})
+}
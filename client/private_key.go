@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Key files are checked for changes at most this often, a rotated key is used once it is mounted.
+const privateKeyCheckInterval = 30 * time.Second
+
+/*
+PrivateKey is the parsed private key of an app. Keys read from a file are reloaded when the file changes,
+like a mounted Kubernetes secret is updated, so keys can be rotated without a restart.
+*/
+type PrivateKey struct {
+	mu            sync.Mutex
+	key           *rsa.PrivateKey
+	version       int
+	file          string
+	data          []byte
+	checkedAt     time.Time
+	checkInterval time.Duration
+}
+
+// LoadPrivateKey loads the private key of the app from its file, environment variable or inline PEM.
+func LoadPrivateKey(app config.AppConfig) (*PrivateKey, error) {
+	privateKey := &PrivateKey{file: app.PrivateKey, checkInterval: privateKeyCheckInterval}
+	var data []byte
+	switch {
+	case app.PrivateKeyPEM != "":
+		data = []byte(app.PrivateKeyPEM)
+	case app.PrivateKeyEnv != "":
+		value, found := os.LookupEnv(app.PrivateKeyEnv)
+		if !found {
+			return nil, fmt.Errorf("environment variable %s of the private key is not set", app.PrivateKeyEnv)
+		}
+		data = []byte(value)
+	default:
+		var err error
+		if data, err = os.ReadFile(app.PrivateKey); err != nil {
+			return nil, errors.Wrap(err, "Can not read private key!")
+		}
+		privateKey.checkedAt = time.Now()
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can not parse private key!")
+	}
+	privateKey.key, privateKey.data = key, data
+	return privateKey, nil
+}
+
+// Get returns the key and its version, which changes each time the key is reloaded.
+func (k *PrivateKey) Get() (*rsa.PrivateKey, int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.file != "" && time.Since(k.checkedAt) >= k.checkInterval {
+		k.checkedAt = time.Now()
+		if err := k.reload(); err != nil {
+			log.WithError(err).WithField("file", k.file).Error("Can not reload private key, previous key is used")
+		}
+	}
+	return k.key, k.version
+}
+
+func (k *PrivateKey) reload() error {
+	data, err := os.ReadFile(k.file)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, k.data) {
+		return nil
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return err
+	}
+	k.key, k.data = key, data
+	k.version++
+	log.WithFields(log.Fields{
+		"file":    k.file,
+		"version": k.version,
+	}).Info("Private key is reloaded")
+	return nil
+}
+
+// appTransport authenticates requests as the app, signed with the current private key of the app.
+type appTransport struct {
+	tr    http.RoundTripper
+	appID int64
+	key   *PrivateKey
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, _ := t.key.Get()
+	return ghinstallation.NewAppsTransportFromPrivateKey(t.tr, t.appID, key).RoundTrip(req)
+}
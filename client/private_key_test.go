@@ -0,0 +1,62 @@
+package client
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPrivateKey(t *testing.T) {
+	t.Run("Inline PEM", func(t *testing.T) {
+		privateKey, err := LoadPrivateKey(config.AppConfig{PrivateKeyPEM: string(encodePrivateKey(t))})
+		assert.Nil(t, err)
+		key, version := privateKey.Get()
+		assert.NotNil(t, key)
+		assert.Equal(t, 0, version)
+	})
+	t.Run("Environment Variable", func(t *testing.T) {
+		t.Setenv("RELEASE_BOT_PRIVATE_KEY", string(encodePrivateKey(t)))
+		privateKey, err := LoadPrivateKey(config.AppConfig{PrivateKeyEnv: "RELEASE_BOT_PRIVATE_KEY"})
+		assert.Nil(t, err)
+		key, _ := privateKey.Get()
+		assert.NotNil(t, key)
+
+		_, err = LoadPrivateKey(config.AppConfig{PrivateKeyEnv: "RELEASE_BOT_UNKNOWN_KEY"})
+		assert.Error(t, err)
+	})
+	t.Run("Invalid Key", func(t *testing.T) {
+		_, err := LoadPrivateKey(config.AppConfig{PrivateKeyPEM: "not a key"})
+		assert.Error(t, err)
+		_, err = LoadPrivateKey(config.AppConfig{PrivateKey: "testdata/unknown.pem"})
+		assert.Error(t, err)
+	})
+	t.Run("Reload Changed File", func(t *testing.T) {
+		keyFile := writePrivateKey(t)
+		privateKey, err := LoadPrivateKey(config.AppConfig{PrivateKey: keyFile})
+		assert.Nil(t, err)
+		loaded, version := privateKey.Get()
+		assert.Equal(t, 0, version)
+
+		// The file is not read again until the check interval passes.
+		assert.Nil(t, os.WriteFile(keyFile, encodePrivateKey(t), 0600))
+		key, version := privateKey.Get()
+		assert.Same(t, loaded, key)
+		assert.Equal(t, 0, version)
+
+		privateKey.checkInterval = 0
+		rotated, version := privateKey.Get()
+		assert.NotSame(t, loaded, rotated)
+		assert.Equal(t, 1, version)
+		key, version = privateKey.Get()
+		assert.Same(t, rotated, key)
+		assert.Equal(t, 1, version)
+
+		// A broken key file does not replace the loaded key.
+		assert.Nil(t, os.WriteFile(keyFile, []byte("not a key"), 0600))
+		key, version = privateKey.Get()
+		assert.Same(t, rotated, key)
+		assert.Equal(t, 1, version)
+	})
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"time"
+
+	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -9,42 +12,173 @@ import (
 type Config struct {
 	Server    HTTPConfig       `mapstructure:"server"`
 	Queue     QueueConfig      `mapstructure:"queue"`
+	Store     StoreConfig      `mapstructure:"store"`
 	Github    GithubConfig     `mapstructure:"github"`
+	Approval  ApprovalConfig   `mapstructure:"approval"`
+	OIDC      OIDCConfig       `mapstructure:"oidc"`
 	Pipelines []PipelineConfig `mapstructure:"pipelines"`
 }
 
 type HTTPConfig struct {
-	BaseURL string `mapstructure:"base_url"`
-	Address string `mapstructure:"address"`
-	Port    int    `mapstructure:"port"`
+	BaseURL    string `mapstructure:"base_url"`
+	Address    string `mapstructure:"address"`
+	Port       int    `mapstructure:"port"`
+	AdminToken string `mapstructure:"admin_token"`
 }
 
 type QueueConfig struct {
-	Limit   int `mapstructure:"limit"`
-	Workers int `mapstructure:"workers"`
+	Limit          int           `mapstructure:"limit"`
+	Workers        int           `mapstructure:"workers"`
+	EnqueueTimeout time.Duration `mapstructure:"enqueue_timeout"`
+	// ShutdownTimeout bounds how long in-flight dispatches are awaited on shutdown.
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// JournalPath enables persisting accepted deliveries to the given file until they are processed.
+	JournalPath string      `mapstructure:"journal_path"`
+	Retry       RetryConfig `mapstructure:"retry"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
+
+type StoreConfig struct {
+	Type        string        `mapstructure:"type"`
+	Path        string        `mapstructure:"path"`
+	DeliveryTTL time.Duration `mapstructure:"delivery_ttl"`
+	// DeliveryLimit bounds the deliveries kept in memory, deliveries in bolt and redis are only bounded by DeliveryTTL.
+	DeliveryLimit int         `mapstructure:"delivery_limit"`
+	Redis         RedisConfig `mapstructure:"redis"`
+}
+
+type RedisConfig struct {
+	Address  string `mapstructure:"address"`
+	Password string `mapstructure:"password"`
+	DB       int    `mapstructure:"db"`
+	Prefix   string `mapstructure:"prefix"`
+}
+
+// EventContextTTL is how long event contexts are kept, events can not wait for approval any longer.
+const EventContextTTL = 6 * time.Hour
+
+// ApprovalConfig controls fork events which wait for a maintainer approval before they are dispatched.
+type ApprovalConfig struct {
+	// TTL is how long an event waits for approval, at most EventContextTTL.
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+/*
+OIDCConfig enables verifying the GitHub Actions ID token sent by runs requesting an access token,
+so bot tokens are only exchanged by the private run dispatched for them.
+*/
+type OIDCConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Issuer defaults to the GitHub Actions issuer, its signing keys are discovered from its OpenID configuration.
+	Issuer   string `mapstructure:"issuer"`
+	Audience string `mapstructure:"audience"`
+	// CacheTTL is how long the signing keys are cached.
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
 }
+
+/*
+GithubConfig configures the GitHub Apps of release bot. The app configured by integration_id, private_key and webhook_secret
+is named "default", further apps are configured as a named list of apps. The first app is used if no app is named.
+*/
 type GithubConfig struct {
 	IntegrationID int64  `mapstructure:"integration_id"`
 	WebhookSecret string `mapstructure:"webhook_secret"`
-	PrivateKey    string `mapstructure:"private_key"`
+	// WebhookSecrets are the previous webhook secrets, which are accepted while the webhook secret is rotated.
+	WebhookSecrets []WebhookSecretConfig `mapstructure:"webhook_secrets"`
+	PrivateKey     string                `mapstructure:"private_key"`
+	PrivateKeyEnv  string                `mapstructure:"private_key_env"`
+	PrivateKeyPEM  string                `mapstructure:"private_key_pem"`
+	// BaseURL and UploadURL point to a GitHub Enterprise Server, github.com is used if they are not set.
+	BaseURL   string      `mapstructure:"base_url"`
+	UploadURL string      `mapstructure:"upload_url"`
+	Apps      []AppConfig `mapstructure:"apps"`
+}
+
+/*
+AppConfig configures a GitHub App. Its private key is read from the private_key file, which is reloaded when it changes,
+from the environment variable named by private_key_env, or given inline as private_key_pem.
+*/
+type AppConfig struct {
+	Name           string                `mapstructure:"name"`
+	IntegrationID  int64                 `mapstructure:"integration_id"`
+	WebhookSecret  string                `mapstructure:"webhook_secret"`
+	WebhookSecrets []WebhookSecretConfig `mapstructure:"webhook_secrets"`
+	PrivateKey     string                `mapstructure:"private_key"`
+	PrivateKeyEnv  string                `mapstructure:"private_key_env"`
+	PrivateKeyPEM  string                `mapstructure:"private_key_pem"`
+}
+
+// WebhookSecretConfig is a previous webhook secret of an app, it is not accepted anymore once it expires.
+type WebhookSecretConfig struct {
+	Secret    string    `mapstructure:"secret"`
+	ExpiresAt time.Time `mapstructure:"expires_at"`
 }
 
 type PipelineConfig struct {
-	Organization string              `mapstructure:"organization"`
-	Repository   string              `mapstructure:"repository"`
-	Workflow     string              `mapstructure:"workflow"`
-	Conditions   []PipelineCondition `mapstructure:"conditions"`
+	// Name lets maintainers run the pipeline with the run command on pull request comments.
+	Name string `mapstructure:"name"`
+	// App is the name of the GitHub App dispatching the pipeline into its organization.
+	App          string            `mapstructure:"app"`
+	Organization string            `mapstructure:"organization"`
+	Repository   string            `mapstructure:"repository"`
+	Workflow     string            `mapstructure:"workflow"`
+	Ref          string            `mapstructure:"ref"`
+	Inputs       map[string]string `mapstructure:"inputs"`
+	Priority     int               `mapstructure:"priority"`
+	// StopOnMatch is true unless it is disabled, so an event only triggers the first pipeline it matches by default.
+	StopOnMatch   *bool                `mapstructure:"stop_on_match"`
+	Status        StatusConfig         `mapstructure:"status"`
+	Token         TokenConfig          `mapstructure:"token"`
+	Notifications []NotificationConfig `mapstructure:"notifications"`
+	Conditions    []PipelineCondition  `mapstructure:"conditions"`
+}
+
+// StatusConfig enables reporting the pipeline outcome as a commit status on the source repository.
+type StatusConfig struct {
+	Context   string `mapstructure:"context"`
+	TargetURL string `mapstructure:"target_url"`
+}
+
+/*
+TokenConfig narrows the installation token handed to the pipeline runs.
+Repositories are names of repositories of the installation owner, permissions map permission names to read, write or admin.
+A token with all the permissions of the app on all repositories of the installation is created if they are empty.
+*/
+type TokenConfig struct {
+	Repositories []string          `mapstructure:"repositories"`
+	Permissions  map[string]string `mapstructure:"permissions"`
+}
+
+// NotificationConfig posts a message to a Mattermost incoming webhook on the given outcomes of the pipeline.
+type NotificationConfig struct {
+	WebhookURL string `mapstructure:"webhook_url"`
+	// Channel and Username override the defaults of the incoming webhook if they are set.
+	Channel  string `mapstructure:"channel"`
+	Username string `mapstructure:"username"`
+	Message  string `mapstructure:"message"`
+	// On lists the outcomes to notify, all outcomes are notified if it is empty.
+	On []string `mapstructure:"on"`
 }
 
 type PipelineCondition struct {
 	Repository string   `mapstructure:"repository"`
 	Webhook    []string `mapstructure:"webhook"`
+	Action     string   `mapstructure:"action"`
 	Workflow   string   `mapstructure:"workflow"`
 	Type       string   `mapstructure:"type"`
 	Fork       bool     `mapstructure:"fork"`
-	Status     string   `mapstructure:"status"`
-	Conclusion string   `mapstructure:"conclusion"`
-	Name       string   `mapstructure:"name"`
+	// ForkApproval lets fork events match the condition, but they are only dispatched once a maintainer approves them.
+	ForkApproval bool   `mapstructure:"fork_approval"`
+	Status       string `mapstructure:"status"`
+	Conclusion   string `mapstructure:"conclusion"`
+	Name         string `mapstructure:"name"`
+	Prerelease   *bool  `mapstructure:"prerelease"`
+	Draft        *bool  `mapstructure:"draft"`
 }
 
 func ReadConfig(filename string, paths ...string) (*Config, error) {
@@ -66,8 +200,54 @@ func ReadConfig(filename string, paths ...string) (*Config, error) {
 
 	var c Config
 
-	if err := viper.Unmarshal(&c); err != nil {
+	// Timestamps are decoded from quoted strings as well.
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+	))
+	if err := viper.Unmarshal(&c, decodeHook); err != nil {
 		return nil, errors.Wrap(err, "failed parsing configuration file")
 	}
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid configuration")
+	}
 	return &c, nil
 }
+
+func (c *Config) Validate() error {
+	if err := c.Github.validate(); err != nil {
+		return err
+	}
+	if c.OIDC.Enabled && c.OIDC.Audience == "" {
+		return errors.New("oidc audience is required when oidc is enabled")
+	}
+	if c.Store.DeliveryLimit > 0 && c.Store.Type != "" && c.Store.Type != "memory" {
+		return errors.Errorf("delivery limit is only supported by the memory store, %s store is bounded by delivery ttl", c.Store.Type)
+	}
+	if c.Approval.TTL > EventContextTTL {
+		return errors.Errorf("approval ttl %s is longer than event contexts are kept (%s)", c.Approval.TTL, EventContextTTL)
+	}
+	names := make(map[string]bool)
+	// Retries, tokens and approvals find pipelines by key, so each workflow can be targeted by one pipeline only.
+	keys := make(map[string]bool)
+	for i := range c.Pipelines {
+		if err := c.Pipelines[i].validate(); err != nil {
+			return errors.Wrapf(err, "pipeline %s", c.Pipelines[i].Key())
+		}
+		if keys[c.Pipelines[i].Key()] {
+			return errors.Errorf("pipeline %s is configured more than once", c.Pipelines[i].Key())
+		}
+		keys[c.Pipelines[i].Key()] = true
+		if _, found := c.Github.GetApp(c.Pipelines[i].App); !found {
+			return errors.Errorf("pipeline %s: app %s is not configured", c.Pipelines[i].Key(), c.Pipelines[i].App)
+		}
+		if name := c.Pipelines[i].Name; name != "" {
+			if names[name] {
+				return errors.Errorf("pipeline %s: name %s is used by another pipeline", c.Pipelines[i].Key(), name)
+			}
+			names[name] = true
+		}
+	}
+	return nil
+}
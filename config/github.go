@@ -0,0 +1,116 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultAppName = "default"
+
+// GetApps returns the configured apps, the default app comes first if it is configured.
+func (g *GithubConfig) GetApps() []AppConfig {
+	var apps []AppConfig
+	defaultApp := AppConfig{
+		Name:           DefaultAppName,
+		IntegrationID:  g.IntegrationID,
+		WebhookSecret:  g.WebhookSecret,
+		WebhookSecrets: g.WebhookSecrets,
+		PrivateKey:     g.PrivateKey,
+		PrivateKeyEnv:  g.PrivateKeyEnv,
+		PrivateKeyPEM:  g.PrivateKeyPEM,
+	}
+	if g.IntegrationID != 0 || defaultApp.privateKeySources() > 0 || len(g.Apps) == 0 {
+		apps = append(apps, defaultApp)
+	}
+	return append(apps, g.Apps...)
+}
+
+// GetApp returns the app with the given name, or the first app if the name is empty.
+func (g *GithubConfig) GetApp(name string) (AppConfig, bool) {
+	apps := g.GetApps()
+	if name == "" {
+		return apps[0], true
+	}
+	for _, app := range apps {
+		if app.Name == name {
+			return app, true
+		}
+	}
+	return AppConfig{}, false
+}
+
+// GetAppByIntegrationID returns the app with the given id, which GitHub sends with the webhooks of the app.
+func (g *GithubConfig) GetAppByIntegrationID(integrationID int64) (AppConfig, bool) {
+	for _, app := range g.GetApps() {
+		if app.IntegrationID == integrationID {
+			return app, true
+		}
+	}
+	return AppConfig{}, false
+}
+
+func (g *GithubConfig) validate() error {
+	if g.UploadURL != "" && g.BaseURL == "" {
+		return errors.New("github upload_url is configured without a base_url")
+	}
+	for _, value := range []string{g.BaseURL, g.UploadURL} {
+		if value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(value); err != nil || parsed.Host == "" {
+			return errors.Errorf("github url %q is invalid", value)
+		}
+	}
+	for _, app := range g.Apps {
+		if app.IntegrationID == 0 || app.privateKeySources() == 0 {
+			return errors.Errorf("github app %s requires an integration_id and a private key", app.Name)
+		}
+	}
+	names := make(map[string]bool)
+	for _, app := range g.GetApps() {
+		for _, secret := range app.WebhookSecrets {
+			if secret.Secret == "" {
+				return errors.Errorf("github app %s has an empty previous webhook secret", app.Name)
+			}
+		}
+		if app.privateKeySources() > 1 {
+			return errors.Errorf("github app %s has more than one of private_key, private_key_env and private_key_pem", app.Name)
+		}
+		// Webhooks of an app can be routed by its name as a path suffix.
+		if app.Name == "" || strings.ContainsAny(app.Name, "/ \t\n") {
+			return errors.Errorf("github app name %q is invalid", app.Name)
+		}
+		if names[app.Name] {
+			return errors.Errorf("github app name %s is used by another app", app.Name)
+		}
+		names[app.Name] = true
+	}
+	return nil
+}
+
+/*
+GetWebhookSecrets returns the secrets webhooks of the app can be signed with at the given time,
+the current webhook secret comes first and the previous ones follow until they expire.
+*/
+func (a *AppConfig) GetWebhookSecrets(now time.Time) []string {
+	secrets := []string{a.WebhookSecret}
+	for _, secret := range a.WebhookSecrets {
+		if secret.ExpiresAt.IsZero() || now.Before(secret.ExpiresAt) {
+			secrets = append(secrets, secret.Secret)
+		}
+	}
+	return secrets
+}
+
+func (a *AppConfig) privateKeySources() int {
+	sources := 0
+	for _, source := range []string{a.PrivateKey, a.PrivateKeyEnv, a.PrivateKeyPEM} {
+		if source != "" {
+			sources++
+		}
+	}
+	return sources
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Outcomes of a pipeline which can be notified.
+const (
+	OutcomeTriggered     = "triggered"
+	OutcomeTriggerFailed = "trigger_failed"
+	OutcomeRunFailed     = "run_failed"
+)
+
+var outcomes = []string{OutcomeTriggered, OutcomeTriggerFailed, OutcomeRunFailed}
+
+const defaultNotificationMessage = "{{ if eq .Outcome \"triggered\" }}Pipeline `{{ .Pipeline }}` is triggered" +
+	"{{ else if eq .Outcome \"trigger_failed\" }}Pipeline `{{ .Pipeline }}` could not be triggered" +
+	"{{ else }}Pipeline `{{ .Pipeline }}` completed with {{ .Conclusion }}{{ end }}" +
+	" for {{ .Repository }}@{{ .CommitHash | short }}{{ if .Error }}: {{ .Error }}{{ end }}"
+
+// Notifies tells if the outcome is notified.
+func (n *NotificationConfig) Notifies(outcome string) bool {
+	return len(n.On) == 0 || contains(n.On, outcome)
+}
+
+// ParseMessage parses the message template, which can refer to event fields like inputs do and to the outcome of the pipeline.
+func (n *NotificationConfig) ParseMessage() (*template.Template, error) {
+	message := n.Message
+	if message == "" {
+		message = defaultNotificationMessage
+	}
+	tmpl, err := template.New("message").Funcs(InputTemplateFuncs).Option("missingkey=error").Parse(message)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid template for notification message")
+	}
+	return tmpl, nil
+}
+
+func (n *NotificationConfig) validate() error {
+	webhookURL, err := url.Parse(n.WebhookURL)
+	if err != nil || webhookURL.Host == "" {
+		return fmt.Errorf("notification webhook url %q is invalid", n.WebhookURL)
+	}
+	for _, outcome := range n.On {
+		if !contains(outcomes, outcome) {
+			return fmt.Errorf("notification outcome %s is unknown", outcome)
+		}
+	}
+	_, err = n.ParseMessage()
+	return err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
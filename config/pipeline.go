@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// GitHub rejects workflow dispatch requests with more than 10 inputs.
+	MaxDispatchInputs  = 10
+	DefaultDispatchRef = "main"
+
+	BotTokenInput   = "botToken"
+	BotBaseURLInput = "botBaseUrl"
+	// ApprovedByInput is set to the approver of fork events, if the pipeline declares it in its inputs.
+	ApprovedByInput = "approvedBy"
+)
+
+// Inputs which are always injected by release bot and can not be overridden from configuration.
+var reservedInputs = []string{BotTokenInput, BotBaseURLInput}
+
+var InputTemplateFuncs = template.FuncMap{
+	"short": func(s string) string {
+		if len(s) > 7 {
+			return s[:7]
+		}
+		return s
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// EventTemplateData is the value pipeline input and status templates are evaluated against, it is filled from the event.
+type EventTemplateData struct {
+	Event             string
+	Action            string
+	Type              string
+	Repository        string
+	Name              string
+	CommitHash        string
+	Workflow          string
+	WorkflowRunID     int64
+	Status            string
+	Conclusion        string
+	PullRequestNumber int
+	// BaseRef and Author are only set for pull request events.
+	BaseRef        string
+	Author         string
+	ReleaseName    string
+	Fork           bool
+	Prerelease     bool
+	Draft          bool
+	InstallationID int64
+}
+
+// Key identifies the pipeline by its target organization, repository and workflow.
+func (p *PipelineConfig) Key() string {
+	return fmt.Sprintf("%s/%s/%s", p.Organization, p.Repository, p.Workflow)
+}
+
+func (p *PipelineConfig) GetRef() string {
+	if p.Ref == "" {
+		return DefaultDispatchRef
+	}
+	return p.Ref
+}
+
+func (p *PipelineConfig) ParseInputs() (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(p.Inputs))
+	for name, value := range p.Inputs {
+		tmpl, err := template.New(name).Funcs(InputTemplateFuncs).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid template for input %s", name)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// StopsOnMatch tells if pipelines after this one are skipped when it matches an event, which is the default.
+func (p *PipelineConfig) StopsOnMatch() bool {
+	return p.StopOnMatch == nil || *p.StopOnMatch
+}
+
+// AllowsForks tells if fork events can run the pipeline, directly or after an approval.
+func (p *PipelineConfig) AllowsForks() bool {
+	for _, condition := range p.Conditions {
+		if condition.Fork || condition.ForkApproval {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+SetApprover sets the approver of the event in the dispatch inputs. Workflows reject inputs they do not declare,
+so it is only set if the pipeline declares the approvedBy input.
+*/
+func (p *PipelineConfig) SetApprover(inputs map[string]interface{}, approver string) {
+	if _, declared := p.Inputs[ApprovedByInput]; declared && approver != "" {
+		inputs[ApprovedByInput] = approver
+	}
+}
+
+// RequiresForkApproval tells if any condition of the pipeline parks fork events until they are approved.
+func (p *PipelineConfig) RequiresForkApproval() bool {
+	for _, condition := range p.Conditions {
+		if condition.ForkApproval {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportsStatus tells if a commit status should be reported for the pipeline.
+func (p *PipelineConfig) ReportsStatus() bool {
+	return p.Status.Context != ""
+}
+
+// ParseStatusTargetURL parses the target url of the commit status, which can refer to event fields like inputs do.
+func (p *PipelineConfig) ParseStatusTargetURL() (*template.Template, error) {
+	tmpl, err := template.New("target_url").Funcs(InputTemplateFuncs).Option("missingkey=error").Parse(p.Status.TargetURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid template for status target url")
+	}
+	return tmpl, nil
+}
+
+func (p *PipelineConfig) validate() error {
+	for _, name := range reservedInputs {
+		if _, ok := p.Inputs[name]; ok {
+			return fmt.Errorf("input %s is reserved", name)
+		}
+	}
+	if len(p.Inputs)+len(reservedInputs) > MaxDispatchInputs {
+		return fmt.Errorf("%d inputs are configured, at most %d are allowed", len(p.Inputs), MaxDispatchInputs-len(reservedInputs))
+	}
+	if strings.ContainsAny(p.Name, " \t\n") {
+		return fmt.Errorf("name %q can not contain whitespace", p.Name)
+	}
+	for _, condition := range p.Conditions {
+		if condition.Fork && condition.ForkApproval {
+			return fmt.Errorf("fork and fork_approval can not be enabled together")
+		}
+	}
+	inputs, err := p.ParseInputs()
+	if err != nil {
+		return err
+	}
+	// Templates referring to unknown fields are parsed, they only fail once they are executed.
+	for name, tmpl := range inputs {
+		if err := tmpl.Execute(io.Discard, EventTemplateData{}); err != nil {
+			return errors.Wrapf(err, "invalid template for input %s", name)
+		}
+	}
+	if p.Status.TargetURL != "" && !p.ReportsStatus() {
+		return fmt.Errorf("status target url is configured without a status context")
+	}
+	targetURL, err := p.ParseStatusTargetURL()
+	if err != nil {
+		return err
+	}
+	if err := targetURL.Execute(io.Discard, EventTemplateData{}); err != nil {
+		return errors.Wrap(err, "invalid template for status target url")
+	}
+	if err := p.Token.validate(); err != nil {
+		return err
+	}
+	for i := range p.Notifications {
+		if err := p.Notifications[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
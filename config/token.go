@@ -0,0 +1,79 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/pkg/errors"
+)
+
+// Permission levels ordered by the access they grant.
+var permissionLevels = []string{"read", "write", "admin"}
+
+// IsScoped tells if the token is narrowed to some repositories or permissions.
+func (t *TokenConfig) IsScoped() bool {
+	return len(t.Repositories) > 0 || len(t.Permissions) > 0
+}
+
+// InstallationTokenOptions converts the scope to the options of the installation token request.
+func (t *TokenConfig) InstallationTokenOptions() (*github.InstallationTokenOptions, error) {
+	options := &github.InstallationTokenOptions{}
+	if len(t.Repositories) > 0 {
+		options.Repositories = append([]string(nil), t.Repositories...)
+	}
+	if len(t.Permissions) == 0 {
+		return options, nil
+	}
+	encoded, err := json.Marshal(t.Permissions)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not encode token permissions")
+	}
+	// Permission names are the fields of the installation permissions of the GitHub API.
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	options.Permissions = &github.InstallationPermissions{}
+	if err := decoder.Decode(options.Permissions); err != nil {
+		return nil, errors.Wrap(err, "unknown token permission")
+	}
+	return options, nil
+}
+
+// MissingPermissions lists the requested permissions which are not granted at the requested level.
+func (t *TokenConfig) MissingPermissions(granted map[string]string) []string {
+	var missing []string
+	for name, level := range t.Permissions {
+		if permissionRank(granted[name]) < permissionRank(level) {
+			missing = append(missing, fmt.Sprintf("%s: %s", name, level))
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func permissionRank(level string) int {
+	for i, l := range permissionLevels {
+		if l == level {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (t *TokenConfig) validate() error {
+	for _, repository := range t.Repositories {
+		if repository == "" || strings.Contains(repository, "/") {
+			return fmt.Errorf("token repository %q must be a repository name without its owner", repository)
+		}
+	}
+	for name, level := range t.Permissions {
+		if permissionRank(level) == 0 {
+			return fmt.Errorf("token permission %s has invalid level %s, it must be one of %s", name, level, strings.Join(permissionLevels, ", "))
+		}
+	}
+	_, err := t.InstallationTokenOptions()
+	return err
+}
@@ -26,8 +26,11 @@ func main() {
 	defer cancel()
 
 	srv := server.New()
+	stopped := make(chan struct{})
+	exitCode := 0
 
 	go func() {
+		defer close(stopped)
 		select {
 		case <-signalChanel:
 			log.Info("Received an interrupt, stopping...")
@@ -35,7 +38,8 @@ func main() {
 			log.Info("Context done, stopping...")
 		}
 		if err := srv.Stop(); err != nil {
-			panic(err)
+			log.WithError(err).Error("Server did not stop cleanly")
+			exitCode = 1
 		}
 	}()
 
@@ -43,5 +47,10 @@ func main() {
 	if err != nil && err != http.ErrServerClosed {
 		panic(err)
 	}
+	// Start returns as soon as the http server is closed, wait for the scheduler to drain.
+	<-stopped
 	log.Info("Stopped!")
+	// os.Exit does not run deferred functions.
+	cancel()
+	os.Exit(exitCode)
 }
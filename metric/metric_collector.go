@@ -14,8 +14,15 @@ const (
 	HealthRequestCount
 	TokenRequestCount
 	GithubHookCount
+	AdminRequestCount
 	TagRequestCount
 	BranchRequestCount
+	DuplicateDeliveryCount
+	RejectedDeliveryCount
+	RetriedDispatchCount
+	DeadLetterCount
+	CurrentWebhookSecretCount
+	PreviousWebhookSecretCount
 )
 
 const (
@@ -71,6 +78,12 @@ func RegisterMetrics() {
 		Name:      "hook",
 		Help:      "The total number of github hook requests",
 	})
+	collector.counters[AdminRequestCount] = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "release_bot",
+		Subsystem: "request",
+		Name:      "admin",
+		Help:      "The total number of admin api requests",
+	})
 	collector.counters[TagRequestCount] = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "release_bot",
 		Subsystem: "request",
@@ -83,6 +96,42 @@ func RegisterMetrics() {
 		Name:      "branch",
 		Help:      "The total number of branch requests",
 	})
+	collector.counters[DuplicateDeliveryCount] = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "release_bot",
+		Subsystem: "request",
+		Name:      "duplicate",
+		Help:      "The total number of suppressed duplicate github hook deliveries",
+	})
+	collector.counters[RejectedDeliveryCount] = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "release_bot",
+		Subsystem: "request",
+		Name:      "rejected",
+		Help:      "The total number of github hook deliveries rejected because the queue is full",
+	})
+	collector.counters[RetriedDispatchCount] = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "release_bot",
+		Subsystem: "dispatch",
+		Name:      "retried",
+		Help:      "The total number of retried pipeline dispatches",
+	})
+	collector.counters[DeadLetterCount] = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "release_bot",
+		Subsystem: "dispatch",
+		Name:      "dead_letter",
+		Help:      "The total number of pipeline dispatches moved to dead letters",
+	})
+	collector.counters[CurrentWebhookSecretCount] = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "release_bot",
+		Subsystem: "webhook_secret",
+		Name:      "current",
+		Help:      "The total number of github hook deliveries signed with the current webhook secret",
+	})
+	collector.counters[PreviousWebhookSecretCount] = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "release_bot",
+		Subsystem: "webhook_secret",
+		Name:      "previous",
+		Help:      "The total number of github hook deliveries signed with a previous webhook secret",
+	})
 	collector.gauges[QueuedRequests] = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "release_bot",
 		Subsystem: "queue",
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 
 	"github.com/google/go-github/v45/github"
 	"github.com/mattermost/release-bot/config"
@@ -20,16 +21,27 @@ type EventContext interface {
 	GetWorkflow() string
 	GetWorkflowRunID() int64
 	GetCommitHash() string
+	GetPullRequestNumber() int
+	GetReleaseName() string
 	GetType() string
 	IsFork() bool
+	IsPrerelease() bool
+	IsDraft() bool
 	Log()
 }
 
 type payloadToEventContextConverter func(payload []byte) (EventContext, error)
 
 var eventContextConverters map[string]payloadToEventContextConverter = map[string]payloadToEventContextConverter{
-	"push":         pushEventMapper,
-	"workflow_run": workflowRunEventMapper,
+	"push":            pushEventMapper,
+	"pull_request":    pullRequestEventMapper,
+	"workflow_run":    workflowRunEventMapper,
+	"release":         releaseEventMapper,
+	"create":          createEventMapper,
+	"delete":          deleteEventMapper,
+	IssueCommentEvent: issueCommentEventMapper,
+	// Manual triggers are not sent by github, they are only restored from stores.
+	ManualEvent: manualEventMapper,
 }
 
 func pushEventMapper(payload []byte) (EventContext, error) {
@@ -40,6 +52,14 @@ func pushEventMapper(payload []byte) (EventContext, error) {
 	return newPushEventContext(&event), nil
 }
 
+func pullRequestEventMapper(payload []byte) (EventContext, error) {
+	var event github.PullRequestEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return newPullRequestEventContext(&event), nil
+}
+
 func workflowRunEventMapper(payload []byte) (EventContext, error) {
 	var event github.WorkflowRunEvent
 	if err := json.Unmarshal(payload, &event); err != nil {
@@ -48,6 +68,48 @@ func workflowRunEventMapper(payload []byte) (EventContext, error) {
 	return newWorkflowRunEventContext(event), nil
 }
 
+func releaseEventMapper(payload []byte) (EventContext, error) {
+	var event releasePayload
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	context := newReleaseEventContext(&event.ReleaseEvent).(*ReleaseEventContext)
+	context.commitHash = event.CommitHash
+	return context, nil
+}
+
+func createEventMapper(payload []byte) (EventContext, error) {
+	var event github.CreateEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return newCreateEventContext(&event), nil
+}
+
+func deleteEventMapper(payload []byte) (EventContext, error) {
+	var event github.DeleteEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return newDeleteEventContext(&event), nil
+}
+
+func issueCommentEventMapper(payload []byte) (EventContext, error) {
+	var event issueCommentPayload
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return newIssueCommentEventContext(event), nil
+}
+
+func manualEventMapper(payload []byte) (EventContext, error) {
+	var trigger ManualTrigger
+	if err := json.Unmarshal(payload, &trigger); err != nil {
+		return nil, err
+	}
+	return NewManualEventContext(trigger), nil
+}
+
 func ConvertPayloadToEventContext(githubEventType string, payload []byte) (EventContext, error) {
 	if converter, ok := eventContextConverters[githubEventType]; ok {
 		return converter(payload)
@@ -55,13 +117,27 @@ func ConvertPayloadToEventContext(githubEventType string, payload []byte) (Event
 	return nil, fmt.Errorf("converter not found for %s event", githubEventType)
 }
 
+/*
+Return the first pipeline whose conditions are matched for the github event.
+See GetTargetPipelines for ordering and matching rules.
+*/
+func GetTargetPipeline(context EventContext, pipelines []config.PipelineConfig) *config.PipelineConfig {
+	targets := GetTargetPipelines(context, pipelines)
+	if len(targets) == 0 {
+		return nil
+	}
+	return &targets[0]
+}
+
 /*
 Traverse all pipeline conditions from configuration for the github event.
-If all conditions are matched then return pipeline
+Pipelines are evaluated by descending priority, pipelines with equal priority keep configuration order.
+The first pipeline with a matching condition is returned. Matching goes on to the remaining pipelines only
+if stop_on_match is disabled for the matched pipeline, so one event can trigger several pipelines.
 
 Rules:
 1. Github event must be defined at condition allowed event list.
-2. If event belongs to fork, fork option must be true at condition. For non-forks, condition is not important.
+2. If event belongs to fork, fork or fork_approval option must be true at condition. For non-forks, condition is not important.
 3. Condition type must be equal to event type (pr/branch or tag)
 3. If event belongs to PR, pr option  must be true. Otherwise both must be false.
 4. If event belongs to branch, branch option must be true. Otherwise both must be false.
@@ -71,38 +147,90 @@ Rules:
 8. If event belongs to workflow, status must be equal to event workflow status. If status field is empty, rule is skipped.
 9. [Regex]Repository must be matched to events repository name. If repository field is empty, rule is skipped.
 10.[Regex]Name must be matched to events reference. If name field is empty, rule is skipped.
+11.Action must be equal to event action (opened, synchronize, completed...). If action field is empty, rule is skipped.
+12.If event belongs to release, prerelease and draft flags must be equal to the release flags. If flag is not set, rule is skipped.
 */
-func GetTargetPipeline(context EventContext, pipelines []config.PipelineConfig) *config.PipelineConfig {
-	for _, pipeline := range pipelines {
-		for _, condition := range pipeline.Conditions {
-			if !contains(condition.Webhook, context.GetEvent()) {
-				continue
-			}
-			if context.IsFork() && !condition.Fork {
-				continue
-			}
-			if condition.Type != context.GetType() {
-				continue
-			}
-			if condition.Workflow != "" && condition.Workflow != context.GetWorkflow() {
-				continue
-			}
-			if condition.Conclusion != "" && condition.Conclusion != context.GetConclusion() {
-				continue
-			}
-			if condition.Status != "" && condition.Status != context.GetStatus() {
-				continue
-			}
-			if condition.Repository != "" && !isRegexpMatch(condition.Repository, context.GetRepository()) {
-				continue
-			}
-			if condition.Name != "" && !isRegexpMatch(condition.Name, context.GetName()) {
-				continue
-			}
-			return &pipeline
+func GetTargetPipelines(context EventContext, pipelines []config.PipelineConfig) []config.PipelineConfig {
+	ordered := make([]config.PipelineConfig, len(pipelines))
+	copy(ordered, pipelines)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority > ordered[j].Priority
+	})
+
+	var targets []config.PipelineConfig
+	for _, pipeline := range ordered {
+		if !isPipelineMatch(context, pipeline) {
+			continue
+		}
+		targets = append(targets, pipeline)
+		if pipeline.StopsOnMatch() {
+			break
+		}
+	}
+	return targets
+}
+
+func isPipelineMatch(context EventContext, pipeline config.PipelineConfig) bool {
+	for _, condition := range pipeline.Conditions {
+		if context.IsFork() && !condition.Fork && !condition.ForkApproval {
+			continue
+		}
+		if isConditionMatch(context, condition) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+RequiresApproval tells if the event must be approved by a maintainer before the matching pipeline is dispatched.
+It is the case for fork events which only match conditions with fork_approval option.
+*/
+func RequiresApproval(context EventContext, pipeline config.PipelineConfig) bool {
+	if !context.IsFork() {
+		return false
+	}
+	for _, condition := range pipeline.Conditions {
+		if condition.Fork && isConditionMatch(context, condition) {
+			return false
 		}
 	}
-	return nil
+	return true
+}
+
+// isConditionMatch applies all rules of the condition except the fork rule.
+func isConditionMatch(context EventContext, condition config.PipelineCondition) bool {
+	if !contains(condition.Webhook, context.GetEvent()) {
+		return false
+	}
+	if condition.Type != context.GetType() {
+		return false
+	}
+	if condition.Workflow != "" && condition.Workflow != context.GetWorkflow() {
+		return false
+	}
+	if condition.Conclusion != "" && condition.Conclusion != context.GetConclusion() {
+		return false
+	}
+	if condition.Status != "" && condition.Status != context.GetStatus() {
+		return false
+	}
+	if condition.Action != "" && condition.Action != context.GetAction() {
+		return false
+	}
+	if condition.Prerelease != nil && *condition.Prerelease != context.IsPrerelease() {
+		return false
+	}
+	if condition.Draft != nil && *condition.Draft != context.IsDraft() {
+		return false
+	}
+	if condition.Repository != "" && !isRegexpMatch(condition.Repository, context.GetRepository()) {
+		return false
+	}
+	if condition.Name != "" && !isRegexpMatch(condition.Name, context.GetName()) {
+		return false
+	}
+	return true
 }
 
 func isRegexpMatch(rule string, check string) bool {
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// payloadProvider is implemented by event contexts which can rebuild the github event they are created from.
+type payloadProvider interface {
+	getPayload() interface{}
+}
+
+type serializedEventContext struct {
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// MarshalEventContext serializes event context with its github event payload, so it can be restored with UnmarshalEventContext.
+func MarshalEventContext(context EventContext) ([]byte, error) {
+	provider, ok := context.(payloadProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s event context can not be serialized", context.GetEvent())
+	}
+	payload, err := json.Marshal(provider.getPayload())
+	if err != nil {
+		return nil, errors.Wrap(err, "can not serialize event payload")
+	}
+	return json.Marshal(serializedEventContext{
+		Event:   context.GetEvent(),
+		Payload: payload,
+	})
+}
+
+func UnmarshalEventContext(data []byte) (EventContext, error) {
+	var serialized serializedEventContext
+	if err := json.Unmarshal(data, &serialized); err != nil {
+		return nil, errors.Wrap(err, "can not deserialize event context")
+	}
+	return ConvertPayloadToEventContext(serialized.Event, serialized.Payload)
+}
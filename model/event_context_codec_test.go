@@ -0,0 +1,61 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventContextCodec(t *testing.T) {
+	type test struct {
+		eventType string
+		testFile  string
+	}
+	tests := []test{
+		{eventType: "push", testFile: "push_event_branch.json"},
+		{eventType: "pull_request", testFile: "pull_request_event_fork.json"},
+		{eventType: "workflow_run", testFile: "workflow_run_event_pr.json"},
+		{eventType: "release", testFile: "release_event_prereleased.json"},
+		{eventType: "create", testFile: "create_event_tag.json"},
+		{eventType: "delete", testFile: "delete_event_branch.json"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.eventType, func(t *testing.T) {
+			source, err := os.ReadFile("testdata/" + tc.testFile)
+			assert.Nil(t, err)
+			context, err := ConvertPayloadToEventContext(tc.eventType, source)
+			assert.Nil(t, err)
+
+			data, err := MarshalEventContext(context)
+			assert.Nil(t, err)
+			restored, err := UnmarshalEventContext(data)
+			assert.Nil(t, err)
+			assert.Equal(t, NewEventTemplateData(context), NewEventTemplateData(restored))
+			if workflowRun, ok := context.(*WorkflowRunEventContext); ok {
+				assert.Equal(t, "build.yaml", workflowRun.GetWorkflowFile())
+				assert.Equal(t, workflowRun.GetWorkflowFile(), restored.(*WorkflowRunEventContext).GetWorkflowFile())
+				assert.Equal(t, workflowRun.GetTriggerEvent(), restored.(*WorkflowRunEventContext).GetTriggerEvent())
+			}
+		})
+	}
+	t.Run("Manual Trigger", func(t *testing.T) {
+		context := NewManualEventContext(ManualTrigger{Repository: "mattermost/test", Ref: "master", CommitHash: "abc", InstallationID: 100})
+		data, err := MarshalEventContext(context)
+		assert.Nil(t, err)
+		restored, err := UnmarshalEventContext(data)
+		assert.Nil(t, err)
+		assert.Equal(t, NewEventTemplateData(context), NewEventTemplateData(restored))
+		assert.Equal(t, "branch", restored.GetType())
+	})
+	t.Run("Unsupported Context", func(t *testing.T) {
+		data, err := MarshalEventContext(&eventContextFixture{event: "push"})
+		assert.Nil(t, data)
+		assert.Error(t, err)
+	})
+	t.Run("Invalid Data", func(t *testing.T) {
+		context, err := UnmarshalEventContext([]byte("invalid"))
+		assert.Nil(t, context)
+		assert.Error(t, err)
+	})
+}
@@ -18,6 +18,7 @@ type eventContextFixture struct {
 	commitHash string
 	_type      string
 	fork       bool
+	prerelease bool
 }
 
 func (f *eventContextFixture) GetAction() string {
@@ -56,10 +57,23 @@ func (f *eventContextFixture) GetInstallationID() int64 {
 func (f *eventContextFixture) GetCommitHash() string {
 	return f.commitHash
 }
+func (f *eventContextFixture) GetPullRequestNumber() int {
+	return -1
+}
+func (f *eventContextFixture) GetReleaseName() string {
+	return ""
+}
+func (f *eventContextFixture) IsPrerelease() bool {
+	return f.prerelease
+}
+func (f *eventContextFixture) IsDraft() bool {
+	return false
+}
 func (f *eventContextFixture) Log() {
 }
 
 func createPipelineConfiguration() []config.PipelineConfig {
+	disabled := false
 	return []config.PipelineConfig{
 		{
 			Organization: "a",
@@ -155,6 +169,33 @@ func createPipelineConfiguration() []config.PipelineConfig {
 				},
 			},
 		},
+		{
+			Organization: "a",
+			Repository:   "b",
+			Workflow:     "pull-request-opened",
+			Conditions: []config.PipelineCondition{
+				{
+					Webhook:    []string{"pull_request"},
+					Repository: "^mattermost/pr$",
+					Type:       "pr",
+					Action:     "opened",
+				},
+			},
+		},
+		{
+			Organization: "a",
+			Repository:   "b",
+			Workflow:     "release-published",
+			Conditions: []config.PipelineCondition{
+				{
+					Webhook:    []string{"release"},
+					Repository: "^mattermost/release$",
+					Type:       "tag",
+					Action:     "published",
+					Prerelease: &disabled,
+				},
+			},
+		},
 	}
 }
 
@@ -369,4 +410,144 @@ func TestEventContext(t *testing.T) {
 		pipeline := GetTargetPipeline(eventContext, pipelineConfiguration)
 		assert.Nil(t, pipeline)
 	})
+	t.Run("Action pipeline check", func(t *testing.T) {
+		eventContext := &eventContextFixture{
+			event:      "pull_request",
+			action:     "opened",
+			repository: "mattermost/pr",
+			_type:      "pr",
+		}
+		pipeline := GetTargetPipeline(eventContext, pipelineConfiguration)
+		assert.NotNil(t, pipeline)
+		assert.Equal(t, "pull-request-opened", pipeline.Workflow)
+	})
+	t.Run("Action pipeline negative check", func(t *testing.T) {
+		eventContext := &eventContextFixture{
+			event:      "pull_request",
+			action:     "closed",
+			repository: "mattermost/pr",
+			_type:      "pr",
+		}
+		pipeline := GetTargetPipeline(eventContext, pipelineConfiguration)
+		assert.Nil(t, pipeline)
+	})
+	t.Run("Release pipeline check", func(t *testing.T) {
+		eventContext := &eventContextFixture{
+			event:      "release",
+			action:     "published",
+			repository: "mattermost/release",
+			_type:      "tag",
+		}
+		pipeline := GetTargetPipeline(eventContext, pipelineConfiguration)
+		assert.NotNil(t, pipeline)
+		assert.Equal(t, "release-published", pipeline.Workflow)
+	})
+	t.Run("Prerelease pipeline negative check", func(t *testing.T) {
+		eventContext := &eventContextFixture{
+			event:      "release",
+			action:     "published",
+			repository: "mattermost/release",
+			_type:      "tag",
+			prerelease: true,
+		}
+		pipeline := GetTargetPipeline(eventContext, pipelineConfiguration)
+		assert.Nil(t, pipeline)
+	})
+}
+
+func TestTargetPipelines(t *testing.T) {
+	condition := config.PipelineCondition{
+		Webhook:    []string{"push"},
+		Repository: "^mattermost/release$",
+		Type:       "branch",
+	}
+	eventContext := &eventContextFixture{
+		event:      "push",
+		repository: "mattermost/release",
+		_type:      "branch",
+	}
+	fanOut := false
+	t.Run("First matching pipeline by default", func(t *testing.T) {
+		pipelines := GetTargetPipelines(eventContext, []config.PipelineConfig{
+			{Workflow: "build", Conditions: []config.PipelineCondition{condition}},
+			{Workflow: "security-scan", Conditions: []config.PipelineCondition{condition}},
+		})
+		assert.Len(t, pipelines, 1)
+		assert.Equal(t, "build", pipelines[0].Workflow)
+	})
+	t.Run("All matching pipelines", func(t *testing.T) {
+		pipelines := GetTargetPipelines(eventContext, []config.PipelineConfig{
+			{Workflow: "build", StopOnMatch: &fanOut, Conditions: []config.PipelineCondition{condition}},
+			{Workflow: "other", Conditions: []config.PipelineCondition{{Webhook: []string{"release"}, Type: "tag"}}},
+			{Workflow: "security-scan", Conditions: []config.PipelineCondition{condition}},
+		})
+		assert.Len(t, pipelines, 2)
+		assert.Equal(t, "build", pipelines[0].Workflow)
+		assert.Equal(t, "security-scan", pipelines[1].Workflow)
+	})
+	t.Run("Priority ordering", func(t *testing.T) {
+		pipelines := GetTargetPipelines(eventContext, []config.PipelineConfig{
+			{Workflow: "build", StopOnMatch: &fanOut, Conditions: []config.PipelineCondition{condition}},
+			{Workflow: "security-scan", Priority: 10, StopOnMatch: &fanOut, Conditions: []config.PipelineCondition{condition}},
+			{Workflow: "notify", Conditions: []config.PipelineCondition{condition}},
+		})
+		assert.Len(t, pipelines, 3)
+		assert.Equal(t, "security-scan", pipelines[0].Workflow)
+		assert.Equal(t, "build", pipelines[1].Workflow)
+		assert.Equal(t, "notify", pipelines[2].Workflow)
+	})
+	t.Run("Stop on match", func(t *testing.T) {
+		pipelines := GetTargetPipelines(eventContext, []config.PipelineConfig{
+			{Workflow: "build", StopOnMatch: &fanOut, Conditions: []config.PipelineCondition{condition}},
+			{Workflow: "security-scan", Priority: 10, Conditions: []config.PipelineCondition{condition}},
+		})
+		assert.Len(t, pipelines, 1)
+		assert.Equal(t, "security-scan", pipelines[0].Workflow)
+		assert.Equal(t, "security-scan", GetTargetPipeline(eventContext, pipelines).Workflow)
+	})
+	t.Run("No matching pipeline", func(t *testing.T) {
+		assert.Empty(t, GetTargetPipelines(&eventContextFixture{event: "push"}, []config.PipelineConfig{
+			{Workflow: "build", Conditions: []config.PipelineCondition{condition}},
+		}))
+	})
+}
+
+func TestRequiresApproval(t *testing.T) {
+	pipelines := []config.PipelineConfig{
+		{
+			Organization: "a",
+			Repository:   "b",
+			Workflow:     "approval",
+			Conditions: []config.PipelineCondition{
+				{
+					Webhook:      []string{"pull_request"},
+					Repository:   "^mattermost/approval$",
+					Type:         "pr",
+					ForkApproval: true,
+				},
+				{
+					Webhook:    []string{"pull_request"},
+					Repository: "^mattermost/approval$",
+					Type:       "pr",
+					Name:       "^trusted-",
+					Fork:       true,
+				},
+			},
+		},
+	}
+	t.Run("Fork Event", func(t *testing.T) {
+		eventContext := &eventContextFixture{event: "pull_request", repository: "mattermost/approval", fork: true, _type: "pr", name: "feature"}
+		targets := GetTargetPipelines(eventContext, pipelines)
+		assert.Len(t, targets, 1)
+		assert.True(t, RequiresApproval(eventContext, targets[0]))
+	})
+	t.Run("Fork Event Matching Fork Condition", func(t *testing.T) {
+		eventContext := &eventContextFixture{event: "pull_request", repository: "mattermost/approval", fork: true, _type: "pr", name: "trusted-feature"}
+		assert.False(t, RequiresApproval(eventContext, pipelines[0]))
+	})
+	t.Run("Non Fork Event", func(t *testing.T) {
+		eventContext := &eventContextFixture{event: "pull_request", repository: "mattermost/approval", _type: "pr", name: "feature"}
+		assert.Len(t, GetTargetPipelines(eventContext, pipelines), 1)
+		assert.False(t, RequiresApproval(eventContext, pipelines[0]))
+	})
 }
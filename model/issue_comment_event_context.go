@@ -0,0 +1,137 @@
+package model
+
+import (
+	"github.com/google/go-github/v45/github"
+	log "github.com/sirupsen/logrus"
+)
+
+const IssueCommentEvent = "issue_comment"
+
+// issueCommentPayload is the github issue_comment event with the pull request it is commented on, once it is resolved.
+type issueCommentPayload struct {
+	github.IssueCommentEvent
+	PullRequest *github.PullRequest `json:"resolved_pull_request,omitempty"`
+}
+
+/*
+IssueCommentEventContext is created for comments on issues and pull requests.
+The event does not carry the head of the pull request, so the ref and commit of the event
+are only known after the pull request is resolved with SetPullRequest.
+*/
+type IssueCommentEventContext struct {
+	event   string
+	payload issueCommentPayload
+}
+
+func newIssueCommentEventContext(payload issueCommentPayload) EventContext {
+	return &IssueCommentEventContext{
+		event:   IssueCommentEvent,
+		payload: payload,
+	}
+}
+
+func (icec *IssueCommentEventContext) Log() {
+	log.WithFields(log.Fields{
+		"event":           icec.GetEvent(),
+		"action":          icec.GetAction(),
+		"type":            icec.GetType(),
+		"repo":            icec.GetRepository(),
+		"number":          icec.GetIssueNumber(),
+		"commenter":       icec.GetCommenter(),
+		"installation_id": icec.GetInstallationID(),
+	}).Info("Issue Comment Event!")
+}
+
+// SetPullRequest resolves the pull request the comment is made on.
+func (icec *IssueCommentEventContext) SetPullRequest(pullRequest *github.PullRequest) {
+	icec.payload.PullRequest = pullRequest
+}
+func (icec *IssueCommentEventContext) IsPullRequest() bool {
+	return icec.payload.GetIssue().IsPullRequest()
+}
+func (icec *IssueCommentEventContext) GetIssueNumber() int {
+	return icec.payload.GetIssue().GetNumber()
+}
+func (icec *IssueCommentEventContext) GetCommentID() int64 {
+	return icec.payload.GetComment().GetID()
+}
+func (icec *IssueCommentEventContext) GetCommentBody() string {
+	return icec.payload.GetComment().GetBody()
+}
+func (icec *IssueCommentEventContext) GetCommenter() string {
+	return icec.payload.GetComment().GetUser().GetLogin()
+}
+func (icec *IssueCommentEventContext) GetOwner() string {
+	return icec.payload.GetRepo().GetOwner().GetLogin()
+}
+func (icec *IssueCommentEventContext) GetRepositoryName() string {
+	return icec.payload.GetRepo().GetName()
+}
+
+func (icec *IssueCommentEventContext) GetEvent() string {
+	return icec.event
+}
+func (icec *IssueCommentEventContext) GetAction() string {
+	return icec.payload.GetAction()
+}
+func (icec *IssueCommentEventContext) IsFork() bool {
+	pullRequest := icec.payload.PullRequest
+	if pullRequest == nil {
+		return false
+	}
+	return pullRequest.GetBase().GetRepo().GetFullName() != pullRequest.GetHead().GetRepo().GetFullName()
+}
+func (icec *IssueCommentEventContext) GetType() string {
+	if icec.IsPullRequest() {
+		return "pr"
+	}
+	return "issue"
+}
+func (icec *IssueCommentEventContext) GetWorkflow() string {
+	return ""
+}
+func (icec *IssueCommentEventContext) GetWorkflowRunID() int64 {
+	return int64(-1)
+}
+func (icec *IssueCommentEventContext) GetConclusion() string {
+	return ""
+}
+func (icec *IssueCommentEventContext) GetStatus() string {
+	return ""
+}
+func (icec *IssueCommentEventContext) GetRepository() string {
+	return icec.payload.GetRepo().GetFullName()
+}
+func (icec *IssueCommentEventContext) GetName() string {
+	return icec.payload.PullRequest.GetHead().GetRef()
+}
+func (icec *IssueCommentEventContext) GetInstallationID() int64 {
+	return icec.payload.GetInstallation().GetID()
+}
+func (icec *IssueCommentEventContext) GetCommitHash() string {
+	return icec.payload.PullRequest.GetHead().GetSHA()
+}
+func (icec *IssueCommentEventContext) GetPullRequestNumber() int {
+	if !icec.IsPullRequest() {
+		return -1
+	}
+	return icec.GetIssueNumber()
+}
+func (icec *IssueCommentEventContext) GetBaseRef() string {
+	return icec.payload.PullRequest.GetBase().GetRef()
+}
+func (icec *IssueCommentEventContext) GetAuthor() string {
+	return icec.payload.PullRequest.GetUser().GetLogin()
+}
+func (icec *IssueCommentEventContext) GetReleaseName() string {
+	return ""
+}
+func (icec *IssueCommentEventContext) IsPrerelease() bool {
+	return false
+}
+func (icec *IssueCommentEventContext) IsDraft() bool {
+	return false
+}
+func (icec *IssueCommentEventContext) getPayload() interface{} {
+	return &icec.payload
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssueCommentEventContext(t *testing.T) {
+	source, err := os.ReadFile("testdata/issue_comment_event_created.json")
+	assert.Nil(t, err)
+	context, err := ConvertPayloadToEventContext(IssueCommentEvent, source)
+	assert.Nil(t, err)
+	commentContext := context.(*IssueCommentEventContext)
+
+	t.Run("Unresolved Pull Request", func(t *testing.T) {
+		assert.Equal(t, "issue_comment", commentContext.GetEvent())
+		assert.Equal(t, "created", commentContext.GetAction())
+		assert.Equal(t, "pr", commentContext.GetType())
+		assert.Equal(t, "mattermost/release-bot", commentContext.GetRepository())
+		assert.Equal(t, "mattermost", commentContext.GetOwner())
+		assert.Equal(t, "release-bot", commentContext.GetRepositoryName())
+		assert.Equal(t, int64(1854), commentContext.GetInstallationID())
+		assert.Equal(t, 1, commentContext.GetPullRequestNumber())
+		assert.Equal(t, int64(1234567), commentContext.GetCommentID())
+		assert.Equal(t, "/release-bot run e2e ab7a32c", commentContext.GetCommentBody())
+		assert.Equal(t, "maintainer", commentContext.GetCommenter())
+		assert.Equal(t, "", commentContext.GetCommitHash())
+		assert.Equal(t, "", commentContext.GetName())
+		assert.False(t, commentContext.IsFork())
+	})
+	t.Run("Resolved Pull Request", func(t *testing.T) {
+		commentContext.SetPullRequest(&github.PullRequest{
+			Head: &github.PullRequestBranch{Ref: github.String("feature"), SHA: github.String("abc"), Repo: &github.Repository{FullName: github.String("contributor/release-bot")}},
+			Base: &github.PullRequestBranch{Ref: github.String("main"), Repo: &github.Repository{FullName: github.String("mattermost/release-bot")}},
+		})
+		assert.Equal(t, "abc", commentContext.GetCommitHash())
+		assert.Equal(t, "feature", commentContext.GetName())
+		assert.True(t, commentContext.IsFork())
+
+		data, err := MarshalEventContext(commentContext)
+		assert.Nil(t, err)
+		restored, err := UnmarshalEventContext(data)
+		assert.Nil(t, err)
+		assert.Equal(t, NewEventTemplateData(commentContext), NewEventTemplateData(restored))
+		assert.Equal(t, "/release-bot run e2e ab7a32c", restored.(*IssueCommentEventContext).GetCommentBody())
+	})
+}
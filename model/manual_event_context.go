@@ -0,0 +1,95 @@
+package model
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+const ManualEvent = "manual"
+
+// ManualTrigger is a pipeline trigger requested by an operator instead of a github event.
+type ManualTrigger struct {
+	Repository     string `json:"repository"`
+	Ref            string `json:"ref"`
+	Type           string `json:"type"`
+	CommitHash     string `json:"sha"`
+	InstallationID int64  `json:"installation_id"`
+}
+
+type ManualEventContext struct {
+	event   string
+	trigger ManualTrigger
+}
+
+func NewManualEventContext(trigger ManualTrigger) EventContext {
+	if trigger.Type == "" {
+		trigger.Type = "branch"
+	}
+	return &ManualEventContext{
+		event:   ManualEvent,
+		trigger: trigger,
+	}
+}
+
+func (mec *ManualEventContext) Log() {
+	log.WithFields(log.Fields{
+		"event":           mec.GetEvent(),
+		"action":          mec.GetAction(),
+		"type":            mec.GetType(),
+		"repo":            mec.GetRepository(),
+		"name":            mec.GetName(),
+		"installation_id": mec.GetInstallationID(),
+		"sha":             mec.GetCommitHash(),
+	}).Info("Manual Trigger!")
+}
+
+func (mec *ManualEventContext) GetEvent() string {
+	return mec.event
+}
+func (mec *ManualEventContext) GetAction() string {
+	return "trigger"
+}
+func (mec *ManualEventContext) IsFork() bool {
+	return false
+}
+func (mec *ManualEventContext) GetType() string {
+	return mec.trigger.Type
+}
+func (mec *ManualEventContext) GetWorkflow() string {
+	return ""
+}
+func (mec *ManualEventContext) GetWorkflowRunID() int64 {
+	return int64(-1)
+}
+func (mec *ManualEventContext) GetConclusion() string {
+	return ""
+}
+func (mec *ManualEventContext) GetStatus() string {
+	return ""
+}
+func (mec *ManualEventContext) GetRepository() string {
+	return mec.trigger.Repository
+}
+func (mec *ManualEventContext) GetName() string {
+	return mec.trigger.Ref
+}
+func (mec *ManualEventContext) GetInstallationID() int64 {
+	return mec.trigger.InstallationID
+}
+func (mec *ManualEventContext) GetCommitHash() string {
+	return mec.trigger.CommitHash
+}
+func (mec *ManualEventContext) GetPullRequestNumber() int {
+	return -1
+}
+func (mec *ManualEventContext) GetReleaseName() string {
+	return ""
+}
+func (mec *ManualEventContext) IsPrerelease() bool {
+	return false
+}
+func (mec *ManualEventContext) IsDraft() bool {
+	return false
+}
+func (mec *ManualEventContext) getPayload() interface{} {
+	return &mec.trigger
+}
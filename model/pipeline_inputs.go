@@ -0,0 +1,89 @@
+package model
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+)
+
+/*
+EventTemplateData is the value pipeline input templates are evaluated against.
+It is declared by config, which checks the templates against it when configuration is loaded.
+*/
+type EventTemplateData = config.EventTemplateData
+
+// pullRequestDetails is implemented by event contexts which know the pull request they belong to.
+type pullRequestDetails interface {
+	GetBaseRef() string
+	GetAuthor() string
+}
+
+// NewEventTemplateData collects the fields of the event context which are exposed to templates.
+func NewEventTemplateData(context EventContext) EventTemplateData {
+	data := EventTemplateData{
+		Event:             context.GetEvent(),
+		Action:            context.GetAction(),
+		Type:              context.GetType(),
+		Repository:        context.GetRepository(),
+		Name:              context.GetName(),
+		CommitHash:        context.GetCommitHash(),
+		Workflow:          context.GetWorkflow(),
+		WorkflowRunID:     context.GetWorkflowRunID(),
+		Status:            context.GetStatus(),
+		Conclusion:        context.GetConclusion(),
+		PullRequestNumber: context.GetPullRequestNumber(),
+		ReleaseName:       context.GetReleaseName(),
+		Fork:              context.IsFork(),
+		Prerelease:        context.IsPrerelease(),
+		Draft:             context.IsDraft(),
+		InstallationID:    context.GetInstallationID(),
+	}
+	if pullRequest, ok := context.(pullRequestDetails); ok {
+		data.BaseRef = pullRequest.GetBaseRef()
+		data.Author = pullRequest.GetAuthor()
+	}
+	return data
+}
+
+/*
+Build workflow dispatch inputs of the pipeline for the github event.
+If pipeline does not declare any input, default inputs are used. Workflows reject inputs they do not declare,
+so fields like the pull request number are only passed by pipelines which declare them as templated inputs.
+Bot token and bot base url are always appended to the inputs.
+*/
+func RenderPipelineInputs(context EventContext, pipeline config.PipelineConfig, botToken string, botBaseURL string) (map[string]interface{}, error) {
+	var inputs map[string]interface{}
+	if len(pipeline.Inputs) == 0 {
+		inputs = defaultPipelineInputs(context)
+	} else {
+		inputs = make(map[string]interface{}, len(pipeline.Inputs))
+		templates, err := pipeline.ParseInputs()
+		if err != nil {
+			return nil, err
+		}
+		data := NewEventTemplateData(context)
+		for name, tmpl := range templates {
+			var value bytes.Buffer
+			if err := tmpl.Execute(&value, data); err != nil {
+				return nil, errors.Wrapf(err, "can not render input %s", name)
+			}
+			inputs[name] = value.String()
+		}
+	}
+	inputs[config.BotTokenInput] = botToken
+	inputs[config.BotBaseURLInput] = botBaseURL
+	return inputs, nil
+}
+
+func defaultPipelineInputs(context EventContext) map[string]interface{} {
+	return map[string]interface{}{
+		"repository":    context.GetRepository(),
+		"name":          context.GetName(),
+		"workflowRunId": strconv.FormatInt(context.GetWorkflowRunID(), 10),
+		"commmitHash":   context.GetCommitHash(),
+		"fork":          strconv.FormatBool(context.IsFork()),
+		"type":          context.GetType(),
+	}
+}
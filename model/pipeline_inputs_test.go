@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderPipelineInputs(t *testing.T) {
+	eventContext := &eventContextFixture{
+		event:      "push",
+		repository: "mattermost/release-bot",
+		name:       "feat/abc",
+		_type:      "branch",
+		commitHash: "f3c4bfb6bf87b9aa2a52a36ed213eec10ae0196c",
+	}
+	t.Run("Default inputs", func(t *testing.T) {
+		inputs, err := RenderPipelineInputs(eventContext, config.PipelineConfig{}, "token", "http://abc.com")
+		assert.Nil(t, err)
+		assert.Equal(t, "mattermost/release-bot", inputs["repository"])
+		assert.Equal(t, "feat/abc", inputs["name"])
+		assert.Equal(t, "f3c4bfb6bf87b9aa2a52a36ed213eec10ae0196c", inputs["commmitHash"])
+		assert.Len(t, inputs, 8)
+		assert.NotContains(t, inputs, "pullRequest")
+		assert.Equal(t, "token", inputs["botToken"])
+		assert.Equal(t, "http://abc.com", inputs["botBaseUrl"])
+	})
+	t.Run("Templated inputs", func(t *testing.T) {
+		pipeline := config.PipelineConfig{
+			Inputs: map[string]string{
+				"branch":      "{{ .Name }}",
+				"sha":         "{{ .CommitHash | short }}",
+				"pullRequest": "{{ .PullRequestNumber }}",
+				"target":      "{{ .Repository | upper }}-{{ .Type }}",
+			},
+		}
+		inputs, err := RenderPipelineInputs(eventContext, pipeline, "token", "http://abc.com")
+		assert.Nil(t, err)
+		assert.Len(t, inputs, 6)
+		assert.Equal(t, "feat/abc", inputs["branch"])
+		assert.Equal(t, "f3c4bfb", inputs["sha"])
+		assert.Equal(t, "-1", inputs["pullRequest"])
+		assert.Equal(t, "MATTERMOST/RELEASE-BOT-branch", inputs["target"])
+		assert.Equal(t, "token", inputs["botToken"])
+		assert.Equal(t, "http://abc.com", inputs["botBaseUrl"])
+	})
+	t.Run("Pull request inputs", func(t *testing.T) {
+		pipeline := config.PipelineConfig{
+			Inputs: map[string]string{
+				"pullRequest": "{{ .PullRequestNumber }}",
+				"base":        "{{ .BaseRef }}",
+				"author":      "{{ .Author }}",
+			},
+		}
+		context := newPullRequestEventContext(createPullRequestEvent(t, "pull_request_event_opened.json"))
+		inputs, err := RenderPipelineInputs(context, pipeline, "token", "http://abc.com")
+		assert.Nil(t, err)
+		assert.Equal(t, "1", inputs["pullRequest"])
+		assert.Equal(t, "main", inputs["base"])
+		assert.Equal(t, "phoinixgrr", inputs["author"])
+
+		// Events without a pull request render them empty.
+		inputs, err = RenderPipelineInputs(eventContext, pipeline, "token", "http://abc.com")
+		assert.Nil(t, err)
+		assert.Equal(t, "", inputs["base"])
+		assert.Equal(t, "", inputs["author"])
+	})
+	t.Run("Unknown field", func(t *testing.T) {
+		pipeline := config.PipelineConfig{
+			Inputs: map[string]string{
+				"branch": "{{ .Branch }}",
+			},
+		}
+		inputs, err := RenderPipelineInputs(eventContext, pipeline, "token", "http://abc.com")
+		assert.Error(t, err)
+		assert.Nil(t, inputs)
+	})
+}
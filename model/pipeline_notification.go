@@ -0,0 +1,44 @@
+package model
+
+import (
+	"bytes"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+)
+
+// NotificationTemplateData exposes the outcome of a pipeline to notification messages, next to the fields of its event.
+type NotificationTemplateData struct {
+	EventTemplateData
+	Pipeline      string
+	Outcome       string
+	Conclusion    string
+	RunRepository string
+	RunID         int64
+	Error         string
+}
+
+// NewNotificationTemplateData collects the fields of the event, if the event is still known, and the outcome of the pipeline.
+func NewNotificationTemplateData(context EventContext, pipeline config.PipelineConfig, outcome string) NotificationTemplateData {
+	data := NotificationTemplateData{
+		Pipeline: pipeline.Key(),
+		Outcome:  outcome,
+	}
+	if context != nil {
+		data.EventTemplateData = NewEventTemplateData(context)
+	}
+	return data
+}
+
+// RenderNotificationMessage builds the message posted for the outcome of the pipeline.
+func RenderNotificationMessage(notification config.NotificationConfig, data NotificationTemplateData) (string, error) {
+	tmpl, err := notification.ParseMessage()
+	if err != nil {
+		return "", err
+	}
+	var value bytes.Buffer
+	if err := tmpl.Execute(&value, data); err != nil {
+		return "", errors.Wrap(err, "can not render notification message")
+	}
+	return value.String(), nil
+}
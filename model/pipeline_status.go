@@ -0,0 +1,24 @@
+package model
+
+import (
+	"bytes"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+)
+
+// RenderStatusTargetURL builds the link of the pipeline commit status for the github event.
+func RenderStatusTargetURL(context EventContext, pipeline config.PipelineConfig) (string, error) {
+	if pipeline.Status.TargetURL == "" {
+		return "", nil
+	}
+	tmpl, err := pipeline.ParseStatusTargetURL()
+	if err != nil {
+		return "", err
+	}
+	var value bytes.Buffer
+	if err := tmpl.Execute(&value, NewEventTemplateData(context)); err != nil {
+		return "", errors.Wrap(err, "can not render status target url")
+	}
+	return value.String(), nil
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"github.com/google/go-github/v45/github"
+	log "github.com/sirupsen/logrus"
+)
+
+type PullRequestEventContext struct {
+	event            string
+	action           string
+	pullRequestEvent *github.PullRequestEvent
+}
+
+func newPullRequestEventContext(event *github.PullRequestEvent) EventContext {
+	return &PullRequestEventContext{
+		event:            "pull_request",
+		action:           event.GetAction(),
+		pullRequestEvent: event,
+	}
+}
+
+func (prec *PullRequestEventContext) Log() {
+	log.WithFields(log.Fields{
+		"event":           prec.GetEvent(),
+		"action":          prec.GetAction(),
+		"fork":            prec.IsFork(),
+		"type":            prec.GetType(),
+		"pr":              prec.GetPullRequestNumber(),
+		"author":          prec.GetAuthor(),
+		"base":            prec.GetBaseRef(),
+		"head":            prec.GetHeadRef(),
+		"repo":            prec.GetRepository(),
+		"name":            prec.GetName(),
+		"installation_id": prec.GetInstallationID(),
+		"sha":             prec.GetCommitHash(),
+	}).Info("Pull Request Event!")
+}
+
+func (prec *PullRequestEventContext) GetEvent() string {
+	return prec.event
+}
+func (prec *PullRequestEventContext) GetAction() string {
+	return prec.action
+}
+func (prec *PullRequestEventContext) IsFork() bool {
+	pullRequest := prec.pullRequestEvent.GetPullRequest()
+	return pullRequest.GetBase().GetRepo().GetFullName() != pullRequest.GetHead().GetRepo().GetFullName()
+}
+func (prec *PullRequestEventContext) GetType() string {
+	return "pr"
+}
+func (prec *PullRequestEventContext) GetWorkflow() string {
+	return ""
+}
+func (prec *PullRequestEventContext) GetWorkflowRunID() int64 {
+	return int64(-1)
+}
+func (prec *PullRequestEventContext) GetConclusion() string {
+	return ""
+}
+func (prec *PullRequestEventContext) GetStatus() string {
+	return prec.pullRequestEvent.GetPullRequest().GetState()
+}
+func (prec *PullRequestEventContext) GetRepository() string {
+	return prec.pullRequestEvent.GetRepo().GetFullName()
+}
+func (prec *PullRequestEventContext) GetName() string {
+	return prec.GetHeadRef()
+}
+func (prec *PullRequestEventContext) GetInstallationID() int64 {
+	return prec.pullRequestEvent.GetInstallation().GetID()
+}
+func (prec *PullRequestEventContext) GetCommitHash() string {
+	return prec.pullRequestEvent.GetPullRequest().GetHead().GetSHA()
+}
+func (prec *PullRequestEventContext) GetPullRequestNumber() int {
+	return prec.pullRequestEvent.GetNumber()
+}
+func (prec *PullRequestEventContext) GetBaseRef() string {
+	return prec.pullRequestEvent.GetPullRequest().GetBase().GetRef()
+}
+func (prec *PullRequestEventContext) GetHeadRef() string {
+	return prec.pullRequestEvent.GetPullRequest().GetHead().GetRef()
+}
+func (prec *PullRequestEventContext) GetAuthor() string {
+	return prec.pullRequestEvent.GetPullRequest().GetUser().GetLogin()
+}
+func (prec *PullRequestEventContext) GetReleaseName() string {
+	return ""
+}
+func (prec *PullRequestEventContext) IsPrerelease() bool {
+	return false
+}
+func (prec *PullRequestEventContext) IsDraft() bool {
+	return false
+}
+func (prec *PullRequestEventContext) getPayload() interface{} {
+	return prec.pullRequestEvent
+}
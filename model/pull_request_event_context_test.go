@@ -0,0 +1,67 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullRequestEventContext(t *testing.T) {
+	t.Run("Test Opened", func(t *testing.T) {
+		context := newPullRequestEventContext(createPullRequestEvent(t, "pull_request_event_opened.json"))
+		assert.Equal(t, "ab7a32c308ac42df77385bbb5e97f0e3aac5c42f", context.GetCommitHash())
+		assert.Equal(t, "", context.GetConclusion())
+		assert.Equal(t, "pull_request", context.GetEvent())
+		assert.Equal(t, "opened", context.GetAction())
+		assert.Equal(t, int64(28579677), context.GetInstallationID())
+		assert.Equal(t, "feat/cld-3876-create-github-release-bot-for-unified-ci", context.GetName())
+		assert.Equal(t, "mattermost/release-bot", context.GetRepository())
+		assert.Equal(t, "open", context.GetStatus())
+		assert.Equal(t, "pr", context.GetType())
+		assert.Equal(t, "", context.GetWorkflow())
+		assert.Equal(t, int64(-1), context.GetWorkflowRunID())
+		assert.Equal(t, 1, context.GetPullRequestNumber())
+		assert.Equal(t, false, context.IsFork())
+
+		prContext := context.(*PullRequestEventContext)
+		assert.Equal(t, "main", prContext.GetBaseRef())
+		assert.Equal(t, "feat/cld-3876-create-github-release-bot-for-unified-ci", prContext.GetHeadRef())
+		assert.Equal(t, "phoinixgrr", prContext.GetAuthor())
+	})
+	t.Run("Test Fork", func(t *testing.T) {
+		context := newPullRequestEventContext(createPullRequestEvent(t, "pull_request_event_fork.json"))
+		assert.Equal(t, "synchronize", context.GetAction())
+		assert.Equal(t, "fix/typo", context.GetName())
+		assert.Equal(t, "mattermost/release-bot", context.GetRepository())
+		assert.Equal(t, 2, context.GetPullRequestNumber())
+		assert.Equal(t, true, context.IsFork())
+
+		prContext := context.(*PullRequestEventContext)
+		assert.Equal(t, "contributor", prContext.GetAuthor())
+	})
+	t.Run("Test Converter", func(t *testing.T) {
+		source, err := os.ReadFile("testdata/pull_request_event_opened.json")
+		assert.Nil(t, err)
+		context, err := ConvertPayloadToEventContext("pull_request", source)
+		assert.Nil(t, err)
+		assert.Equal(t, "pull_request", context.GetEvent())
+		assert.Equal(t, 1, context.GetPullRequestNumber())
+	})
+}
+
+func createPullRequestEvent(t *testing.T, filename string) *github.PullRequestEvent {
+	source, err := os.ReadFile(fmt.Sprintf("testdata/%s", filename))
+	if err != nil {
+		t.Fatal("error reading source file:", err)
+	}
+	var event github.PullRequestEvent
+
+	if err = json.Unmarshal(source, &event); err != nil {
+		t.Fatal("error reading source file:", err)
+	}
+	return &event
+}
@@ -80,3 +80,18 @@ func (pec *PushEventContext) GetInstallationID() int64 {
 func (pec *PushEventContext) GetCommitHash() string {
 	return pec.pushEvent.GetAfter()
 }
+func (pec *PushEventContext) GetPullRequestNumber() int {
+	return -1
+}
+func (pec *PushEventContext) GetReleaseName() string {
+	return ""
+}
+func (pec *PushEventContext) IsPrerelease() bool {
+	return false
+}
+func (pec *PushEventContext) IsDraft() bool {
+	return false
+}
+func (pec *PushEventContext) getPayload() interface{} {
+	return pec.pushEvent
+}
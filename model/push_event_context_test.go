@@ -23,6 +23,7 @@ func TestPushEventContext(t *testing.T) {
 		assert.Equal(t, "tag", context.GetType())
 		assert.Equal(t, "", context.GetWorkflow())
 		assert.Equal(t, int64(-1), context.GetWorkflowRunID())
+		assert.Equal(t, -1, context.GetPullRequestNumber())
 		assert.Equal(t, false, context.IsFork())
 	})
 	t.Run("Test Branch", func(t *testing.T) {
@@ -37,6 +38,7 @@ func TestPushEventContext(t *testing.T) {
 		assert.Equal(t, "branch", context.GetType())
 		assert.Equal(t, "", context.GetWorkflow())
 		assert.Equal(t, int64(-1), context.GetWorkflowRunID())
+		assert.Equal(t, -1, context.GetPullRequestNumber())
 		assert.Equal(t, false, context.IsFork())
 	})
 }
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"github.com/google/go-github/v45/github"
+	log "github.com/sirupsen/logrus"
+)
+
+// RefEventContext covers both create and delete events, which share the same payload shape.
+type RefEventContext struct {
+	event          string
+	action         string
+	ref            string
+	refType        string
+	repository     *github.Repository
+	installationID int64
+}
+
+func newCreateEventContext(event *github.CreateEvent) EventContext {
+	return &RefEventContext{
+		event:          "create",
+		action:         "create",
+		ref:            event.GetRef(),
+		refType:        event.GetRefType(),
+		repository:     event.GetRepo(),
+		installationID: event.GetInstallation().GetID(),
+	}
+}
+
+func newDeleteEventContext(event *github.DeleteEvent) EventContext {
+	return &RefEventContext{
+		event:          "delete",
+		action:         "delete",
+		ref:            event.GetRef(),
+		refType:        event.GetRefType(),
+		repository:     event.GetRepo(),
+		installationID: event.GetInstallation().GetID(),
+	}
+}
+
+func (rec *RefEventContext) Log() {
+	log.WithFields(log.Fields{
+		"event":           rec.GetEvent(),
+		"action":          rec.GetAction(),
+		"fork":            rec.IsFork(),
+		"type":            rec.GetType(),
+		"repo":            rec.GetRepository(),
+		"name":            rec.GetName(),
+		"installation_id": rec.GetInstallationID(),
+	}).Info("Ref Event!")
+}
+
+func (rec *RefEventContext) GetEvent() string {
+	return rec.event
+}
+func (rec *RefEventContext) GetAction() string {
+	return rec.action
+}
+func (rec *RefEventContext) IsFork() bool {
+	return rec.repository.GetFork()
+}
+func (rec *RefEventContext) GetType() string {
+	if rec.refType == "tag" {
+		return "tag"
+	}
+	return "branch"
+}
+func (rec *RefEventContext) GetWorkflow() string {
+	return ""
+}
+func (rec *RefEventContext) GetWorkflowRunID() int64 {
+	return int64(-1)
+}
+func (rec *RefEventContext) GetConclusion() string {
+	return ""
+}
+func (rec *RefEventContext) GetStatus() string {
+	return ""
+}
+func (rec *RefEventContext) GetRepository() string {
+	return rec.repository.GetFullName()
+}
+func (rec *RefEventContext) GetName() string {
+	return rec.ref
+}
+func (rec *RefEventContext) GetInstallationID() int64 {
+	return rec.installationID
+}
+func (rec *RefEventContext) GetCommitHash() string {
+	return ""
+}
+func (rec *RefEventContext) GetPullRequestNumber() int {
+	return -1
+}
+func (rec *RefEventContext) GetReleaseName() string {
+	return ""
+}
+func (rec *RefEventContext) IsPrerelease() bool {
+	return false
+}
+func (rec *RefEventContext) IsDraft() bool {
+	return false
+}
+func (rec *RefEventContext) getPayload() interface{} {
+	installation := &github.Installation{ID: &rec.installationID}
+	if rec.event == "delete" {
+		return &github.DeleteEvent{Ref: &rec.ref, RefType: &rec.refType, Repo: rec.repository, Installation: installation}
+	}
+	return &github.CreateEvent{Ref: &rec.ref, RefType: &rec.refType, Repo: rec.repository, Installation: installation}
+}
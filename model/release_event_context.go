@@ -0,0 +1,163 @@
+package model
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var commitHashPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+/*
+CommitResolver is implemented by event contexts which do not carry the hash of their commit,
+it is resolved through GitHub before pipelines are dispatched for them.
+*/
+type CommitResolver interface {
+	ResolveCommitHash(ctx context.Context, client *github.Client) error
+}
+
+// releasePayload is the github release event with the commit of its tag, once it is resolved.
+type releasePayload struct {
+	github.ReleaseEvent
+	CommitHash string `json:"resolved_commit_hash,omitempty"`
+}
+
+type ReleaseEventContext struct {
+	event        string
+	action       string
+	releaseEvent *github.ReleaseEvent
+	// commitHash is the commit the tag of the release points to, once it is resolved.
+	commitHash string
+}
+
+func newReleaseEventContext(event *github.ReleaseEvent) EventContext {
+	return &ReleaseEventContext{
+		event:        "release",
+		action:       event.GetAction(),
+		releaseEvent: event,
+	}
+}
+
+func (rec *ReleaseEventContext) Log() {
+	log.WithFields(log.Fields{
+		"event":           rec.GetEvent(),
+		"action":          rec.GetAction(),
+		"fork":            rec.IsFork(),
+		"type":            rec.GetType(),
+		"release":         rec.GetReleaseName(),
+		"prerelease":      rec.IsPrerelease(),
+		"draft":           rec.IsDraft(),
+		"repo":            rec.GetRepository(),
+		"name":            rec.GetName(),
+		"installation_id": rec.GetInstallationID(),
+		"sha":             rec.GetCommitHash(),
+	}).Info("Release Event!")
+}
+
+func (rec *ReleaseEventContext) GetEvent() string {
+	return rec.event
+}
+func (rec *ReleaseEventContext) GetAction() string {
+	return rec.action
+}
+func (rec *ReleaseEventContext) IsFork() bool {
+	return rec.releaseEvent.GetRepo().GetFork()
+}
+func (rec *ReleaseEventContext) GetType() string {
+	return "tag"
+}
+func (rec *ReleaseEventContext) GetWorkflow() string {
+	return ""
+}
+func (rec *ReleaseEventContext) GetWorkflowRunID() int64 {
+	return int64(-1)
+}
+func (rec *ReleaseEventContext) GetConclusion() string {
+	return ""
+}
+func (rec *ReleaseEventContext) GetStatus() string {
+	return ""
+}
+func (rec *ReleaseEventContext) GetRepository() string {
+	return rec.releaseEvent.GetRepo().GetFullName()
+}
+func (rec *ReleaseEventContext) GetName() string {
+	return rec.releaseEvent.GetRelease().GetTagName()
+}
+func (rec *ReleaseEventContext) GetInstallationID() int64 {
+	return rec.releaseEvent.GetInstallation().GetID()
+}
+
+/*
+GetCommitHash returns the commit of the release tag. The target of a release is usually a branch, so the
+commit is only known if the target is a commit hash or the tag is resolved by ResolveCommitHash.
+*/
+func (rec *ReleaseEventContext) GetCommitHash() string {
+	if rec.commitHash != "" {
+		return rec.commitHash
+	}
+	if target := rec.GetTargetCommitish(); commitHashPattern.MatchString(target) {
+		return target
+	}
+	return ""
+}
+
+// GetTargetCommitish returns the branch or commit the release tag is created from.
+func (rec *ReleaseEventContext) GetTargetCommitish() string {
+	return rec.releaseEvent.GetRelease().GetTargetCommitish()
+}
+
+/*
+ResolveCommitHash resolves the release tag to the commit it points to, through the tag object of annotated tags.
+The tag of a draft release is not created yet, so the target of the draft is resolved instead.
+*/
+func (rec *ReleaseEventContext) ResolveCommitHash(ctx context.Context, client *github.Client) error {
+	if rec.GetCommitHash() != "" {
+		return nil
+	}
+	owner, repository, _ := strings.Cut(rec.GetRepository(), "/")
+	if rec.IsDraft() {
+		sha, _, err := client.Repositories.GetCommitSHA1(ctx, owner, repository, rec.GetTargetCommitish(), "")
+		if err != nil {
+			return errors.Wrapf(err, "Can not resolve target %s of draft release %s!", rec.GetTargetCommitish(), rec.GetRepository())
+		}
+		rec.commitHash = sha
+		return nil
+	}
+	ref, _, err := client.Git.GetRef(ctx, owner, repository, "tags/"+rec.GetName())
+	if err != nil {
+		return errors.Wrapf(err, "Can not resolve tag %s of %s!", rec.GetName(), rec.GetRepository())
+	}
+	object := ref.GetObject()
+	if object.GetType() == "tag" {
+		tag, _, err := client.Git.GetTag(ctx, owner, repository, object.GetSHA())
+		if err != nil {
+			return errors.Wrapf(err, "Can not resolve tag %s of %s!", rec.GetName(), rec.GetRepository())
+		}
+		object = tag.GetObject()
+	}
+	rec.commitHash = object.GetSHA()
+	return nil
+}
+func (rec *ReleaseEventContext) GetPullRequestNumber() int {
+	return -1
+}
+func (rec *ReleaseEventContext) GetReleaseName() string {
+	return rec.releaseEvent.GetRelease().GetName()
+}
+func (rec *ReleaseEventContext) IsPrerelease() bool {
+	return rec.releaseEvent.GetRelease().GetPrerelease()
+}
+func (rec *ReleaseEventContext) IsDraft() bool {
+	return rec.releaseEvent.GetRelease().GetDraft()
+}
+func (rec *ReleaseEventContext) getPayload() interface{} {
+	return &releasePayload{
+		ReleaseEvent: *rec.releaseEvent,
+		CommitHash:   rec.commitHash,
+	}
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	ctx "context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseEventContext(t *testing.T) {
+	t.Run("Test Published", func(t *testing.T) {
+		context := newReleaseEventContext(createReleaseEvent(t, "release_event_published.json"))
+		assert.Equal(t, "f3c4bfb6bf87b9aa2a52a36ed213eec10ae0196c", context.GetCommitHash())
+		assert.Equal(t, "release", context.GetEvent())
+		assert.Equal(t, "published", context.GetAction())
+		assert.Equal(t, int64(28579677), context.GetInstallationID())
+		assert.Equal(t, "v1.0.0", context.GetName())
+		assert.Equal(t, "Release Bot v1.0.0", context.GetReleaseName())
+		assert.Equal(t, "mattermost/release-bot", context.GetRepository())
+		assert.Equal(t, "tag", context.GetType())
+		assert.Equal(t, int64(-1), context.GetWorkflowRunID())
+		assert.Equal(t, -1, context.GetPullRequestNumber())
+		assert.Equal(t, false, context.IsPrerelease())
+		assert.Equal(t, false, context.IsDraft())
+		assert.Equal(t, false, context.IsFork())
+	})
+	t.Run("Test Prereleased", func(t *testing.T) {
+		context := newReleaseEventContext(createReleaseEvent(t, "release_event_prereleased.json"))
+		assert.Equal(t, "prereleased", context.GetAction())
+		assert.Equal(t, "v1.1.0-rc1", context.GetName())
+		assert.Equal(t, true, context.IsPrerelease())
+		assert.Equal(t, false, context.IsDraft())
+	})
+	t.Run("Test Branch Target", func(t *testing.T) {
+		context := newReleaseEventContext(createReleaseEvent(t, "release_event_published_branch.json")).(*ReleaseEventContext)
+		assert.Equal(t, "main", context.GetTargetCommitish())
+		assert.Equal(t, "", context.GetCommitHash())
+
+		client := github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(
+				mock.GetReposGitRefByOwnerByRepoByRef,
+				github.Reference{Object: &github.GitObject{Type: github.String("commit"), SHA: github.String("a8b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9")}},
+			),
+		))
+		assert.Nil(t, context.ResolveCommitHash(ctx.Background(), client))
+		assert.Equal(t, "a8b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9", context.GetCommitHash())
+	})
+	t.Run("Test Annotated Tag", func(t *testing.T) {
+		context := newReleaseEventContext(createReleaseEvent(t, "release_event_published_branch.json")).(*ReleaseEventContext)
+		client := github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(
+				mock.GetReposGitRefByOwnerByRepoByRef,
+				github.Reference{Object: &github.GitObject{Type: github.String("tag"), SHA: github.String("0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c")}},
+			),
+			mock.WithRequestMatch(
+				mock.GetReposGitTagsByOwnerByRepoByTagSha,
+				github.Tag{Object: &github.GitObject{Type: github.String("commit"), SHA: github.String("a8b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9")}},
+			),
+		))
+		assert.Nil(t, context.ResolveCommitHash(ctx.Background(), client))
+		assert.Equal(t, "a8b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9", context.GetCommitHash())
+	})
+	t.Run("Test Draft", func(t *testing.T) {
+		context := newReleaseEventContext(createReleaseEvent(t, "release_event_created_draft.json")).(*ReleaseEventContext)
+		assert.Equal(t, true, context.IsDraft())
+		// The tag of a draft does not exist yet, the target branch is resolved instead.
+		client := github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.GetReposGitRefByOwnerByRepoByRef,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mock.WriteError(w, http.StatusNotFound, "Not Found")
+				}),
+			),
+			mock.WithRequestMatchHandler(
+				mock.GetReposCommitsByOwnerByRepoByRef,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, "/repos/mattermost/release-bot/commits/main", r.URL.Path)
+					w.Write([]byte("a8b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9"))
+				}),
+			),
+		))
+		assert.Nil(t, context.ResolveCommitHash(ctx.Background(), client))
+		assert.Equal(t, "a8b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9", context.GetCommitHash())
+	})
+	t.Run("Test Resolved Commit Is Serialized", func(t *testing.T) {
+		context := newReleaseEventContext(createReleaseEvent(t, "release_event_published_branch.json")).(*ReleaseEventContext)
+		context.commitHash = "a8b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9"
+		data, err := MarshalEventContext(context)
+		assert.Nil(t, err)
+		restored, err := UnmarshalEventContext(data)
+		assert.Nil(t, err)
+		assert.Equal(t, "a8b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9", restored.GetCommitHash())
+		assert.Equal(t, "main", restored.(*ReleaseEventContext).GetTargetCommitish())
+	})
+	t.Run("Test Missing Tag", func(t *testing.T) {
+		context := newReleaseEventContext(createReleaseEvent(t, "release_event_published_branch.json")).(*ReleaseEventContext)
+		client := github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.GetReposGitRefByOwnerByRepoByRef,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mock.WriteError(w, http.StatusNotFound, "Not Found")
+				}),
+			),
+		))
+		assert.Error(t, context.ResolveCommitHash(ctx.Background(), client))
+		assert.Equal(t, "", context.GetCommitHash())
+	})
+}
+
+func TestRefEventContext(t *testing.T) {
+	t.Run("Test Create Tag", func(t *testing.T) {
+		source, err := os.ReadFile("testdata/create_event_tag.json")
+		assert.Nil(t, err)
+		context, err := ConvertPayloadToEventContext("create", source)
+		assert.Nil(t, err)
+		assert.Equal(t, "create", context.GetEvent())
+		assert.Equal(t, "create", context.GetAction())
+		assert.Equal(t, "tag", context.GetType())
+		assert.Equal(t, "v1.0.0", context.GetName())
+		assert.Equal(t, "mattermost/release-bot", context.GetRepository())
+		assert.Equal(t, int64(28579677), context.GetInstallationID())
+		assert.Equal(t, "", context.GetCommitHash())
+		assert.Equal(t, false, context.IsFork())
+	})
+	t.Run("Test Delete Branch", func(t *testing.T) {
+		source, err := os.ReadFile("testdata/delete_event_branch.json")
+		assert.Nil(t, err)
+		context, err := ConvertPayloadToEventContext("delete", source)
+		assert.Nil(t, err)
+		assert.Equal(t, "delete", context.GetEvent())
+		assert.Equal(t, "delete", context.GetAction())
+		assert.Equal(t, "branch", context.GetType())
+		assert.Equal(t, "feat/preview-environment", context.GetName())
+		assert.Equal(t, "mattermost/release-bot", context.GetRepository())
+	})
+}
+
+func createReleaseEvent(t *testing.T, filename string) *github.ReleaseEvent {
+	source, err := os.ReadFile(fmt.Sprintf("testdata/%s", filename))
+	if err != nil {
+		t.Fatal("error reading source file:", err)
+	}
+	var event github.ReleaseEvent
+
+	if err = json.Unmarshal(source, &event); err != nil {
+		t.Fatal("error reading source file:", err)
+	}
+	return &event
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"path"
+
 	"github.com/google/go-github/v45/github"
 	log "github.com/sirupsen/logrus"
 )
@@ -10,7 +12,9 @@ type WorkflowRunEventContext struct {
 	action         string
 	repository     string
 	installationID int64
+	sender         *github.User
 	workflowRun    *github.WorkflowRun
+	workflow       *github.Workflow
 }
 
 func newWorkflowRunEventContext(event github.WorkflowRunEvent) EventContext {
@@ -20,7 +24,9 @@ func newWorkflowRunEventContext(event github.WorkflowRunEvent) EventContext {
 		action:         event.GetAction(),
 		repository:     event.GetRepo().GetFullName(),
 		installationID: event.GetInstallation().GetID(),
+		sender:         event.GetSender(),
 		workflowRun:    workflowRun,
+		workflow:       event.GetWorkflow(),
 	}
 }
 
@@ -38,6 +44,7 @@ func (wrec *WorkflowRunEventContext) Log() {
 		"name":            wrec.GetName(),
 		"installation_id": wrec.GetInstallationID(),
 		"sha":             wrec.GetCommitHash(),
+		"pr":              wrec.GetPullRequestNumber(),
 	}).Info("Workflow Run Event!")
 }
 func (wrec *WorkflowRunEventContext) GetEvent() string {
@@ -63,6 +70,26 @@ func (wrec *WorkflowRunEventContext) GetType() string {
 func (wrec *WorkflowRunEventContext) GetWorkflow() string {
 	return wrec.workflowRun.GetName()
 }
+
+// GetWorkflowFile returns the file name of the workflow definition, as it is configured for pipelines.
+func (wrec *WorkflowRunEventContext) GetWorkflowFile() string {
+	return path.Base(wrec.workflow.GetPath())
+}
+
+// GetTriggerEvent returns the event which triggered the workflow run, e.g. workflow_dispatch for pipelines.
+func (wrec *WorkflowRunEventContext) GetTriggerEvent() string {
+	return wrec.workflowRun.GetEvent()
+}
+
+// GetActor returns the login of the user the workflow run is created by, e.g. the bot of the app dispatching it.
+func (wrec *WorkflowRunEventContext) GetActor() string {
+	return wrec.workflowRun.GetActor().GetLogin()
+}
+
+// GetSender returns the login of the user triggering the event, who differs from the actor when a run is re-run.
+func (wrec *WorkflowRunEventContext) GetSender() string {
+	return wrec.sender.GetLogin()
+}
 func (wrec *WorkflowRunEventContext) GetWorkflowRunID() int64 {
 	return wrec.workflowRun.GetID()
 }
@@ -84,3 +111,28 @@ func (wrec *WorkflowRunEventContext) GetInstallationID() int64 {
 func (wrec *WorkflowRunEventContext) GetCommitHash() string {
 	return wrec.workflowRun.GetHeadSHA()
 }
+func (wrec *WorkflowRunEventContext) GetPullRequestNumber() int {
+	if len(wrec.workflowRun.PullRequests) == 0 {
+		return -1
+	}
+	return wrec.workflowRun.PullRequests[0].GetNumber()
+}
+func (wrec *WorkflowRunEventContext) GetReleaseName() string {
+	return ""
+}
+func (wrec *WorkflowRunEventContext) IsPrerelease() bool {
+	return false
+}
+func (wrec *WorkflowRunEventContext) IsDraft() bool {
+	return false
+}
+func (wrec *WorkflowRunEventContext) getPayload() interface{} {
+	return &github.WorkflowRunEvent{
+		Action:       &wrec.action,
+		WorkflowRun:  wrec.workflowRun,
+		Workflow:     wrec.workflow,
+		Repo:         &github.Repository{FullName: &wrec.repository},
+		Installation: &github.Installation{ID: &wrec.installationID},
+		Sender:       wrec.sender,
+	}
+}
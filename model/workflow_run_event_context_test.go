@@ -23,7 +23,10 @@ func TestWorkflowRunEventContext(t *testing.T) {
 		assert.Equal(t, "pr", context.GetType())
 		assert.Equal(t, "Build", context.GetWorkflow())
 		assert.Equal(t, int64(2926155304), context.GetWorkflowRunID())
+		assert.Equal(t, 1, context.GetPullRequestNumber())
 		assert.Equal(t, false, context.IsFork())
+		assert.Equal(t, "pfltdv", context.(*WorkflowRunEventContext).GetActor())
+		assert.Equal(t, "pfltdv", context.(*WorkflowRunEventContext).GetSender())
 	})
 	t.Run("Test Branch", func(t *testing.T) {
 		context := newWorkflowRunEventContext(createWorkflowRunEvent(t, "workflow_run_event_branch.json"))
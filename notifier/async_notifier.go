@@ -0,0 +1,92 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrQueueFull is returned when the notification does not fit into the queue, it is dropped.
+	ErrQueueFull = errors.New("notification queue is full")
+	// ErrClosed is returned for notifications sent after the notifier is closed.
+	ErrClosed = errors.New("notifier is closed")
+)
+
+type notification struct {
+	webhookURL string
+	message    Message
+}
+
+/*
+AsyncNotifier posts notifications in the background through a bounded queue, so dispatches are not held up
+by slow webhooks. Notify only reports whether the notification is queued, posting failures are logged.
+*/
+type AsyncNotifier struct {
+	notifier Notifier
+	mu       sync.RWMutex
+	closed   bool
+	queue    chan notification
+	workers  sync.WaitGroup
+}
+
+func NewAsyncNotifier(notifier Notifier, queueSize int, workers int) *AsyncNotifier {
+	n := &AsyncNotifier{
+		notifier: notifier,
+		queue:    make(chan notification, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		n.workers.Add(1)
+		go n.post()
+	}
+	return n
+}
+
+func (n *AsyncNotifier) Notify(webhookURL string, message Message) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return ErrClosed
+	}
+	select {
+	case n.queue <- notification{webhookURL: webhookURL, message: message}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (n *AsyncNotifier) post() {
+	defer n.workers.Done()
+	for notification := range n.queue {
+		logger := log.WithField("channel", notification.message.Channel)
+		if err := n.notifier.Notify(notification.webhookURL, notification.message); err != nil {
+			logger.WithError(err).Error("Can not post notification!")
+			continue
+		}
+		logger.Info("Notification is posted")
+	}
+}
+
+// Close stops accepting notifications and waits until the queued ones are posted or ctx is done.
+func (n *AsyncNotifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "Queued notifications are not posted in time")
+	}
+}
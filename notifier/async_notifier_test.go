@@ -0,0 +1,71 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingNotifier records the messages it posts, each post waits until it is released.
+type blockingNotifier struct {
+	release  chan struct{}
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (n *blockingNotifier) Notify(webhookURL string, message Message) error {
+	<-n.release
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, message)
+	if webhookURL == "" {
+		return errors.New("webhook url is missing")
+	}
+	return nil
+}
+
+func (n *blockingNotifier) Messages() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Message(nil), n.messages...)
+}
+
+func TestAsyncNotifier(t *testing.T) {
+	t.Run("Notify Does Not Wait For Webhook", func(t *testing.T) {
+		blocking := &blockingNotifier{release: make(chan struct{})}
+		notifier := NewAsyncNotifier(blocking, 1, 1)
+		assert.Nil(t, notifier.Notify("http://webhook", Message{Text: "first"}))
+		// The worker holds the first notification, the second one waits in the queue.
+		assert.Eventually(t, func() bool {
+			return notifier.Notify("http://webhook", Message{Text: "second"}) == nil
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, ErrQueueFull, notifier.Notify("http://webhook", Message{Text: "third"}))
+		assert.Empty(t, blocking.Messages())
+
+		close(blocking.release)
+		assert.Nil(t, notifier.Close(context.Background()))
+		assert.Equal(t, []Message{{Text: "first"}, {Text: "second"}}, blocking.Messages())
+		assert.Equal(t, ErrClosed, notifier.Notify("http://webhook", Message{Text: "fourth"}))
+	})
+	t.Run("Failed Post", func(t *testing.T) {
+		blocking := &blockingNotifier{release: make(chan struct{})}
+		close(blocking.release)
+		notifier := NewAsyncNotifier(blocking, 1, 1)
+		assert.Nil(t, notifier.Notify("", Message{Text: "first"}))
+		assert.Nil(t, notifier.Close(context.Background()))
+		assert.Len(t, blocking.Messages(), 1)
+	})
+	t.Run("Close Timeout", func(t *testing.T) {
+		blocking := &blockingNotifier{release: make(chan struct{})}
+		defer close(blocking.release)
+		notifier := NewAsyncNotifier(blocking, 1, 1)
+		assert.Nil(t, notifier.Notify("http://webhook", Message{Text: "first"}))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Error(t, notifier.Close(ctx))
+	})
+}
@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/release-bot/version"
+	"github.com/pkg/errors"
+)
+
+// Message is the payload of a Mattermost incoming webhook. Empty fields keep the defaults of the webhook.
+type Message struct {
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+	Text     string `json:"text"`
+}
+
+type Notifier interface {
+	Notify(webhookURL string, message Message) error
+}
+
+type mattermostNotifier struct {
+	client    *http.Client
+	userAgent string
+}
+
+func NewMattermostNotifier(timeout time.Duration) Notifier {
+	version := version.Full()
+	return &mattermostNotifier{
+		client:    &http.Client{Timeout: timeout},
+		userAgent: fmt.Sprintf("%s/%s", version.Name, version.Version),
+	}
+}
+
+func (n *mattermostNotifier) Notify(webhookURL string, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "can not serialize notification")
+	}
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "can not create notification request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", n.userAgent)
+	res, err := n.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "can not post notification")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		response, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("notification is rejected with status %d: %s", res.StatusCode, response)
+	}
+	return nil
+}
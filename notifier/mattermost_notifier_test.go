@@ -0,0 +1,41 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMattermostNotifier(t *testing.T) {
+	var received []Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/hooks/valid" {
+			http.Error(w, "Unable to find the webhook", http.StatusBadRequest)
+			return
+		}
+		var message Message
+		json.NewDecoder(r.Body).Decode(&message)
+		received = append(received, message)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	notifier := NewMattermostNotifier(time.Second)
+
+	t.Run("Notify", func(t *testing.T) {
+		message := Message{Channel: "release", Username: "release-bot", Text: "Pipeline is triggered"}
+		assert.Nil(t, notifier.Notify(server.URL+"/hooks/valid", message))
+		assert.Equal(t, []Message{message}, received)
+	})
+	t.Run("Rejected", func(t *testing.T) {
+		err := notifier.Notify(server.URL+"/hooks/unknown", Message{Text: "Pipeline is triggered"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "Unable to find the webhook")
+	})
+	t.Run("Unreachable", func(t *testing.T) {
+		assert.Error(t, notifier.Notify("http://127.0.0.1:0/hooks/valid", Message{Text: "Pipeline is triggered"}))
+	})
+}
@@ -0,0 +1,195 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	GithubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+	defaultCacheTTL = time.Hour
+	// Tokens signed with unknown keys refresh the keys at most this often, so they can not flood the issuer.
+	minRefreshInterval = time.Minute
+	discoveryPath      = "/.well-known/openid-configuration"
+	httpTimeout        = 10 * time.Second
+)
+
+// Claims of a GitHub Actions ID token which identify the workflow run requesting it.
+type Claims struct {
+	jwt.RegisteredClaims
+	Repository  string `json:"repository"`
+	RunID       string `json:"run_id"`
+	WorkflowRef string `json:"workflow_ref"`
+	Ref         string `json:"ref"`
+	SHA         string `json:"sha"`
+}
+
+type Verifier interface {
+	Verify(rawToken string) (*Claims, error)
+}
+
+type verifier struct {
+	issuer    string
+	audience  string
+	cacheTTL  time.Duration
+	client    *http.Client
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	// refreshedAt is when the keys were last refreshed, whether the refresh succeeded or not.
+	refreshedAt time.Time
+}
+
+type discoveryDocument struct {
+	JWKSURI string `json:"jwks_uri"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// BuildFromConfig returns the verifier of ID tokens, or nil if verification is not enabled.
+func BuildFromConfig(config *config.Config) Verifier {
+	if !config.OIDC.Enabled {
+		return nil
+	}
+	issuer := config.OIDC.Issuer
+	if issuer == "" {
+		issuer = GithubActionsIssuer
+	}
+	return NewVerifier(issuer, config.OIDC.Audience, config.OIDC.CacheTTL, &http.Client{Timeout: httpTimeout})
+}
+
+func NewVerifier(issuer string, audience string, cacheTTL time.Duration, client *http.Client) Verifier {
+	if cacheTTL <= 0 {
+		cacheTTL = defaultCacheTTL
+	}
+	return &verifier{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		cacheTTL: cacheTTL,
+		client:   client,
+	}
+}
+
+// Verify checks the signature, issuer, audience and expiry of the ID token and returns its claims.
+func (v *verifier) Verify(rawToken string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(rawToken, claims, v.key, jwt.WithValidMethods([]string{"RS256"})); err != nil {
+		return nil, errors.Wrap(err, "invalid id token")
+	}
+	if !claims.VerifyIssuer(v.issuer, true) {
+		return nil, fmt.Errorf("id token is issued by %s", claims.Issuer)
+	}
+	if !claims.VerifyAudience(v.audience, true) {
+		return nil, fmt.Errorf("id token is issued for %s", strings.Join(claims.Audience, ", "))
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("id token has no expiry")
+	}
+	return claims, nil
+}
+
+func (v *verifier) key(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, found := v.keys[keyID]
+	if found && time.Since(v.fetchedAt) < v.cacheTTL {
+		return key, nil
+	}
+	if time.Since(v.refreshedAt) >= minRefreshInterval {
+		v.refreshedAt = time.Now()
+		if err := v.refresh(); err != nil {
+			if !found {
+				return nil, err
+			}
+			// Keys are not revoked by the issuer being unavailable, runs are verified with the cached key meanwhile.
+			log.WithError(err).WithField("kid", keyID).Warn("Can not refresh signing keys, the cached key is used")
+			return key, nil
+		}
+		key, found = v.keys[keyID]
+	}
+	if !found {
+		return nil, fmt.Errorf("signing key %s is unknown", keyID)
+	}
+	return key, nil
+}
+
+// refresh fetches the signing keys of the issuer, from the key set its OpenID configuration points to.
+func (v *verifier) refresh() error {
+	var discovery discoveryDocument
+	if err := v.getJSON(v.issuer+discoveryPath, &discovery); err != nil {
+		return errors.Wrap(err, "Can not get OpenID configuration!")
+	}
+	var keySet jsonWebKeySet
+	if err := v.getJSON(discovery.JWKSURI, &keySet); err != nil {
+		return errors.Wrap(err, "Can not get signing keys!")
+	}
+	keys := make(map[string]*rsa.PublicKey, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		key, err := jwk.rsaPublicKey()
+		if err != nil {
+			log.WithError(err).WithField("kid", jwk.KeyID).Warn("Can not parse signing key")
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	log.WithFields(log.Fields{
+		"issuer": v.issuer,
+		"keys":   len(keys),
+	}).Info("OIDC signing keys are refreshed")
+	return nil
+}
+
+func (v *verifier) getJSON(url string, value interface{}) error {
+	res, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(value)
+}
+
+func (k *jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
@@ -0,0 +1,126 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// testIssuer stands in for the GitHub Actions issuer, it serves its OpenID configuration and signing key.
+type testIssuer struct {
+	*httptest.Server
+	key        *rsa.PrivateKey
+	keyID      string
+	keyFetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	issuer := &testIssuer{key: key, keyID: "key-1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discoveryDocument{JWKSURI: issuer.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.keyFetches++
+		json.NewEncoder(w).Encode(jsonWebKeySet{Keys: []jsonWebKey{{
+			KeyType: "RSA",
+			KeyID:   issuer.keyID,
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (i *testIssuer) sign(t *testing.T, keyID string, claims Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	signed, err := token.SignedString(i.key)
+	assert.Nil(t, err)
+	return signed
+}
+
+// allowRefresh lets the verifier refresh its keys right away, as if the last refresh was long ago.
+func allowRefresh(v Verifier) {
+	v.(*verifier).refreshedAt = time.Time{}
+}
+
+func TestVerifier(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	verifier := NewVerifier(issuer.URL, "release-bot", time.Hour, http.DefaultClient)
+	claims := func(issuerURL string, audience string, expiresAt time.Time) Claims {
+		return Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuerURL,
+				Audience:  jwt.ClaimStrings{audience},
+				ExpiresAt: jwt.NewNumericDate(expiresAt),
+			},
+			Repository:  "mattermost/delivery",
+			RunID:       "3000000001",
+			WorkflowRef: "mattermost/delivery/.github/workflows/build.yml@refs/heads/main",
+		}
+	}
+	expiresAt := time.Now().Add(5 * time.Minute)
+
+	t.Run("Valid Token", func(t *testing.T) {
+		verified, err := verifier.Verify(issuer.sign(t, "key-1", claims(issuer.URL, "release-bot", expiresAt)))
+		assert.Nil(t, err)
+		assert.Equal(t, "mattermost/delivery", verified.Repository)
+		assert.Equal(t, "3000000001", verified.RunID)
+		assert.Equal(t, "mattermost/delivery/.github/workflows/build.yml@refs/heads/main", verified.WorkflowRef)
+
+		_, err = verifier.Verify(issuer.sign(t, "key-1", claims(issuer.URL, "release-bot", expiresAt)))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, issuer.keyFetches)
+	})
+	t.Run("Invalid Claims", func(t *testing.T) {
+		_, err := verifier.Verify(issuer.sign(t, "key-1", claims("https://token.example.com", "release-bot", expiresAt)))
+		assert.Error(t, err)
+		_, err = verifier.Verify(issuer.sign(t, "key-1", claims(issuer.URL, "other", expiresAt)))
+		assert.Error(t, err)
+		_, err = verifier.Verify(issuer.sign(t, "key-1", claims(issuer.URL, "release-bot", time.Now().Add(-time.Minute))))
+		assert.Error(t, err)
+	})
+	t.Run("Invalid Signature", func(t *testing.T) {
+		other := newTestIssuer(t)
+		defer other.Close()
+		_, err := verifier.Verify(other.sign(t, "key-1", claims(issuer.URL, "release-bot", expiresAt)))
+		assert.Error(t, err)
+		_, err = verifier.Verify("not-a-token")
+		assert.Error(t, err)
+	})
+	t.Run("Unknown Key", func(t *testing.T) {
+		// Keys were fetched recently, an unknown key does not fetch them again.
+		_, err := verifier.Verify(issuer.sign(t, "key-2", claims(issuer.URL, "release-bot", expiresAt)))
+		assert.Error(t, err)
+		assert.Equal(t, 1, issuer.keyFetches)
+	})
+	t.Run("Issuer Unavailable", func(t *testing.T) {
+		unavailable := newTestIssuer(t)
+		cached := NewVerifier(unavailable.URL, "release-bot", time.Nanosecond, http.DefaultClient)
+		_, err := cached.Verify(unavailable.sign(t, "key-1", claims(unavailable.URL, "release-bot", expiresAt)))
+		assert.Nil(t, err)
+		unavailable.Close()
+
+		// Cached keys are used after their ttl, while they can not be refreshed.
+		allowRefresh(cached)
+		_, err = cached.Verify(unavailable.sign(t, "key-1", claims(unavailable.URL, "release-bot", expiresAt)))
+		assert.Nil(t, err)
+		allowRefresh(cached)
+		_, err = cached.Verify(unavailable.sign(t, "key-2", claims(unavailable.URL, "release-bot", expiresAt)))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/release-bot/client"
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/metric"
+	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/store"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDispatchListLimit = 100
+	// Approver recorded for approvals given through the admin api without naming the approver.
+	defaultAdminApprover = "admin"
+)
+
+type pipelineDispatcher interface {
+	Trigger(app string, eventContext model.EventContext, pipeline config.PipelineConfig) (store.DispatchRecord, error)
+	Approve(token string, approver string) (store.DispatchRecord, error)
+	Redrive(letter store.DeadLetter) error
+}
+
+type adminAPIHandler struct {
+	AdminToken        string
+	Pipelines         []config.PipelineConfig
+	ClientManager     client.GithubClientManager
+	EventContextStore store.EventContextStore
+	Dispatches        store.DispatchStore
+	DeadLetters       store.DeadLetterStore
+	Dispatcher        pipelineDispatcher
+}
+
+type adminApproveRequest struct {
+	Approver string `json:"approver"`
+}
+
+type adminTriggerRequest struct {
+	Pipeline       string `json:"pipeline"`
+	Repository     string `json:"repository"`
+	Ref            string `json:"ref"`
+	CommitHash     string `json:"sha"`
+	Type           string `json:"type"`
+	InstallationID int64  `json:"installation_id"`
+	App            string `json:"app"`
+}
+
+func isAdminRequest(r *http.Request, adminToken string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+/*
+GET dispatches lists the most recent dispatches, filtered by state and repository query parameters.
+GET dispatches/{token} returns the dispatch of the bot token.
+POST dispatches/{token}/approve dispatches a fork event waiting for approval.
+GET tokens/{token}/event-context returns the event context stored for the bot token.
+DELETE tokens/{token} revokes the bot token and the access token of the run using it.
+POST pipelines/trigger dispatches a pipeline for the given repository, ref and sha.
+GET dead-letters lists all dead letters.
+POST dead-letters/{id}/redrive schedules the dead letter again and removes it from dead letters.
+DELETE dead-letters/{id} discards the dead letter.
+*/
+func (h *adminAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	metric.IncreaseCounter(metric.AdminRequestCount, metric.TotalRequestCount)
+	if !isAdminRequest(r, h.AdminToken) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		metric.IncreaseCounter(metric.TotalFailureCount)
+		return
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, adminAPIDefaultRoute), "/"), "/")
+	var status int
+	switch {
+	case len(segments) == 1 && segments[0] == "dispatches" && r.Method == http.MethodGet:
+		status = h.listDispatches(w, r)
+	case len(segments) == 2 && segments[0] == "dispatches" && r.Method == http.MethodGet:
+		status = h.getDispatch(w, segments[1])
+	case len(segments) == 3 && segments[0] == "dispatches" && segments[2] == "approve" && r.Method == http.MethodPost:
+		status = h.approveDispatch(w, r, segments[1])
+	case len(segments) == 3 && segments[0] == "tokens" && segments[2] == "event-context" && r.Method == http.MethodGet:
+		status = h.getEventContext(w, segments[1])
+	case len(segments) == 2 && segments[0] == "tokens" && r.Method == http.MethodDelete:
+		status = h.revokeToken(w, segments[1])
+	case len(segments) == 2 && segments[0] == "pipelines" && segments[1] == "trigger" && r.Method == http.MethodPost:
+		status = h.triggerPipeline(w, r)
+	case len(segments) == 1 && segments[0] == "dead-letters" && r.Method == http.MethodGet:
+		status = h.listDeadLetters(w)
+	case len(segments) == 3 && segments[0] == "dead-letters" && segments[2] == "redrive" && r.Method == http.MethodPost:
+		status = h.redriveDeadLetter(w, segments[1])
+	case len(segments) == 2 && segments[0] == "dead-letters" && r.Method == http.MethodDelete:
+		status = h.discardDeadLetter(w, segments[1])
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+		status = http.StatusNotFound
+	}
+	if status >= http.StatusBadRequest {
+		metric.IncreaseCounter(metric.TotalFailureCount)
+		return
+	}
+	metric.IncreaseCounter(metric.TotalSuccessCount)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) int {
+	response, _ := json.MarshalIndent(value, "", "  ")
+	w.Header().Add("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(response)
+	return status
+}
+
+func (h *adminAPIHandler) listDispatches(w http.ResponseWriter, r *http.Request) int {
+	query := r.URL.Query()
+	limit := defaultDispatchListLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid Limit", http.StatusBadRequest)
+			return http.StatusBadRequest
+		}
+		limit = parsed
+	}
+	records, err := h.Dispatches.List()
+	if err != nil {
+		log.WithError(err).Error("Can not list dispatches!")
+		http.Error(w, "Can not list dispatches", http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	state, repository := query.Get("state"), query.Get("repository")
+	filtered := []store.DispatchRecord{}
+	for _, record := range records {
+		if len(filtered) == limit {
+			break
+		}
+		if (state == "" || record.State == state) && (repository == "" || record.Repository == repository) {
+			filtered = append(filtered, record)
+		}
+	}
+	return writeJSON(w, http.StatusOK, filtered)
+}
+
+func (h *adminAPIHandler) getDispatch(w http.ResponseWriter, token string) int {
+	record, err := h.Dispatches.Get(token)
+	if err != nil {
+		http.Error(w, "Dispatch Not Found", http.StatusNotFound)
+		return http.StatusNotFound
+	}
+	return writeJSON(w, http.StatusOK, record)
+}
+
+func (h *adminAPIHandler) approveDispatch(w http.ResponseWriter, r *http.Request, token string) int {
+	var request adminApproveRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return http.StatusBadRequest
+		}
+	}
+	if request.Approver == "" {
+		request.Approver = defaultAdminApprover
+	}
+	if _, err := h.Dispatches.Get(token); err != nil {
+		http.Error(w, "Dispatch Not Found", http.StatusNotFound)
+		return http.StatusNotFound
+	}
+	record, err := h.Dispatcher.Approve(token, request.Approver)
+	switch {
+	case err == ErrNotPending:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return http.StatusConflict
+	case err == ErrApprovalExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+		return http.StatusGone
+	case err != nil:
+		log.WithError(err).WithField("token", token).Error("Can not dispatch approved pipeline!")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+	return writeJSON(w, http.StatusOK, record)
+}
+
+func (h *adminAPIHandler) getEventContext(w http.ResponseWriter, token string) int {
+	eventContext, err := h.EventContextStore.Get(token)
+	if err != nil {
+		http.Error(w, "Event Context Not Found", http.StatusNotFound)
+		return http.StatusNotFound
+	}
+	return writeJSON(w, http.StatusOK, model.NewEventTemplateData(eventContext))
+}
+
+func (h *adminAPIHandler) revokeToken(w http.ResponseWriter, token string) int {
+	_, contextErr := h.EventContextStore.Get(token)
+	record, recordErr := h.Dispatches.Get(token)
+	if contextErr != nil && recordErr != nil {
+		http.Error(w, "Bot Token Not Found", http.StatusNotFound)
+		return http.StatusNotFound
+	}
+	if recordErr == nil && record.IsBound() {
+		if err := h.ClientManager.RevokeToken(record.RunRepository, record.RunID); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"repository": record.RunRepository,
+				"run_id":     record.RunID,
+			}).Error("Can not revoke access token!")
+			http.Error(w, "Can not revoke access token", http.StatusBadGateway)
+			return http.StatusBadGateway
+		}
+	}
+	if err := h.EventContextStore.Delete(token); err != nil {
+		log.WithError(err).Error("Can not delete event context!")
+		http.Error(w, "Can not delete event context", http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	if recordErr == nil {
+		if _, err := h.Dispatches.Update(token, func(record *store.DispatchRecord) {
+			now := time.Now()
+			record.RevokedAt = &now
+			record.UpdatedAt = now
+		}); err != nil {
+			log.WithError(err).Warn("Can not update dispatch record")
+		}
+	}
+	log.WithField("pipeline", record.Pipeline).Info("Bot token is revoked")
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent
+}
+
+// latestInstallation returns the app and installation of the most recent dispatch triggered for the repository.
+func (h *adminAPIHandler) latestInstallation(repository string) (string, int64) {
+	records, err := h.Dispatches.List()
+	if err != nil {
+		log.WithError(err).Warn("Can not list dispatches")
+		return "", 0
+	}
+	for _, record := range records {
+		if record.Repository == repository && record.InstallationID != 0 {
+			return record.App, record.InstallationID
+		}
+	}
+	return "", 0
+}
+
+func (h *adminAPIHandler) triggerPipeline(w http.ResponseWriter, r *http.Request) int {
+	var request adminTriggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return http.StatusBadRequest
+	}
+	if request.Pipeline == "" || request.Repository == "" || request.Ref == "" || request.CommitHash == "" {
+		http.Error(w, "Provide Pipeline, Repository, Ref and SHA", http.StatusBadRequest)
+		return http.StatusBadRequest
+	}
+	pipeline, found := findPipeline(h.Pipelines, request.Pipeline)
+	if !found {
+		http.Error(w, "Pipeline Not Found", http.StatusNotFound)
+		return http.StatusNotFound
+	}
+	if request.InstallationID == 0 {
+		request.App, request.InstallationID = h.latestInstallation(request.Repository)
+	}
+	if request.InstallationID == 0 {
+		http.Error(w, "Provide Installation ID", http.StatusBadRequest)
+		return http.StatusBadRequest
+	}
+	eventContext := model.NewManualEventContext(model.ManualTrigger{
+		Repository:     request.Repository,
+		Ref:            request.Ref,
+		Type:           request.Type,
+		CommitHash:     request.CommitHash,
+		InstallationID: request.InstallationID,
+	})
+	record, err := h.Dispatcher.Trigger(request.App, eventContext, pipeline)
+	if err != nil {
+		log.WithError(err).WithField("pipeline", request.Pipeline).Error("Can not trigger pipeline manually!")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+	return writeJSON(w, http.StatusCreated, record)
+}
+
+func (h *adminAPIHandler) listDeadLetters(w http.ResponseWriter) int {
+	letters, err := h.DeadLetters.List()
+	if err != nil {
+		log.WithError(err).Error("Can not list dead letters!")
+		http.Error(w, "Can not list dead letters", http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	return writeJSON(w, http.StatusOK, letters)
+}
+
+func (h *adminAPIHandler) redriveDeadLetter(w http.ResponseWriter, id string) int {
+	letter, err := h.DeadLetters.Get(id)
+	if err != nil {
+		http.Error(w, "Dead Letter Not Found", http.StatusNotFound)
+		return http.StatusNotFound
+	}
+	if err := h.Dispatcher.Redrive(letter); err != nil {
+		log.WithError(err).WithField("dead_letter_id", id).Warn("Can not re-drive dead letter!")
+		w.Header().Set("Retry-After", queueFullRetryAfter)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return http.StatusServiceUnavailable
+	}
+	if err := h.DeadLetters.Remove(id); err != nil {
+		log.WithError(err).WithField("dead_letter_id", id).Error("Can not remove dead letter!")
+		http.Error(w, "Can not remove dead letter", http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	log.WithFields(log.Fields{
+		"dead_letter_id": id,
+		"delivery_id":    letter.DeliveryID,
+		"pipelines":      letter.Pipelines,
+	}).Info("Dead letter is re-driven")
+	w.WriteHeader(http.StatusAccepted)
+	return http.StatusAccepted
+}
+
+func (h *adminAPIHandler) discardDeadLetter(w http.ResponseWriter, id string) int {
+	if _, err := h.DeadLetters.Get(id); err != nil {
+		http.Error(w, "Dead Letter Not Found", http.StatusNotFound)
+		return http.StatusNotFound
+	}
+	if err := h.DeadLetters.Remove(id); err != nil {
+		log.WithError(err).WithField("dead_letter_id", id).Error("Can not remove dead letter!")
+		http.Error(w, "Can not remove dead letter", http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	log.WithField("dead_letter_id", id).Info("Dead letter is discarded")
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent
+}
+
+func newAdminAPIHandler(adminToken string, pipelines []config.PipelineConfig, clientManager client.GithubClientManager, eventContextStore store.EventContextStore, dispatches store.DispatchStore, deadLetters store.DeadLetterStore, dispatcher pipelineDispatcher) http.Handler {
+	return &adminAPIHandler{
+		AdminToken:        adminToken,
+		Pipelines:         pipelines,
+		ClientManager:     clientManager,
+		EventContextStore: eventContextStore,
+		Dispatches:        dispatches,
+		DeadLetters:       deadLetters,
+		Dispatcher:        dispatcher,
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/store"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRevokingClientCache struct {
+	mockClientCache
+	revoked []int64
+	fail    bool
+}
+
+func (cc *mockRevokingClientCache) RevokeToken(repository string, runID int64) error {
+	if cc.fail {
+		return errors.New("failed")
+	}
+	cc.revoked = append(cc.revoked, runID)
+	return nil
+}
+
+type mockPipelineDispatcher struct {
+	fail      bool
+	full      bool
+	triggered []model.EventContext
+	approvals []string
+	redriven  []store.DeadLetter
+}
+
+func (mt *mockPipelineDispatcher) Trigger(app string, eventContext model.EventContext, pipeline config.PipelineConfig) (store.DispatchRecord, error) {
+	if mt.fail {
+		return store.DispatchRecord{}, errors.New("failed")
+	}
+	mt.triggered = append(mt.triggered, eventContext)
+	return store.DispatchRecord{
+		Token:          "manual",
+		Pipeline:       pipeline.Key(),
+		Repository:     eventContext.GetRepository(),
+		CommitHash:     eventContext.GetCommitHash(),
+		InstallationID: eventContext.GetInstallationID(),
+		Event:          eventContext.GetEvent(),
+		State:          store.DispatchQueued,
+	}, nil
+}
+
+func (mt *mockPipelineDispatcher) Approve(token string, approver string) (store.DispatchRecord, error) {
+	switch token {
+	case "approved":
+		return store.DispatchRecord{}, ErrNotPending
+	case "expired":
+		return store.DispatchRecord{}, ErrApprovalExpired
+	}
+	mt.approvals = append(mt.approvals, approver)
+	return store.DispatchRecord{Token: token, State: store.DispatchQueued, ApprovedBy: approver}, nil
+}
+
+func (mt *mockPipelineDispatcher) Redrive(letter store.DeadLetter) error {
+	if mt.full {
+		return ErrQueueFull
+	}
+	mt.redriven = append(mt.redriven, letter)
+	return nil
+}
+
+func TestAdminAPIHandler(t *testing.T) {
+	eventContextStore := store.NewEventContextStore()
+	dispatches := store.NewMemoryDispatchStore()
+	clientManager := &mockRevokingClientCache{}
+	deadLetters := store.NewMemoryDeadLetterStore()
+	dispatcher := &mockPipelineDispatcher{}
+	pipelines := []config.PipelineConfig{{Organization: "mattermost", Repository: "delivery", Workflow: "build.yml"}}
+	handler := newAdminAPIHandler("secret", pipelines, clientManager, eventContextStore, dispatches, deadLetters, dispatcher)
+
+	eventContext := model.NewManualEventContext(model.ManualTrigger{Repository: "mattermost/test", Ref: "master", CommitHash: "abc", InstallationID: 100})
+	eventContextStore.Store(eventContext, "token-1")
+	eventContextStore.Store(eventContext, "token-2")
+	now := time.Now()
+	dispatches.Save(store.DispatchRecord{Token: "token-1", Repository: "mattermost/test", InstallationID: 100, State: store.DispatchQueued, CreatedAt: now.Add(-time.Minute)})
+	dispatches.Save(store.DispatchRecord{Token: "token-2", Repository: "mattermost/test", InstallationID: 100, State: store.DispatchRunning, RunRepository: "mattermost/delivery", RunID: 10, CreatedAt: now})
+	dispatches.Save(store.DispatchRecord{Token: "token-3", Repository: "mattermost/other", InstallationID: 200, State: store.DispatchFailed, CreatedAt: now.Add(-time.Hour)})
+	deadLetters.Add(store.DeadLetter{ID: "1", EventType: "push", DeliveryID: "100", Pipelines: []string{"a/b/c"}, FailedAt: now})
+	deadLetters.Add(store.DeadLetter{ID: "2", EventType: "push", DeliveryID: "101", Pipelines: []string{"a/b/c"}, FailedAt: now})
+
+	serve := func(method string, path string, token string, body io.Reader) *http.Response {
+		req := httptest.NewRequest(method, adminAPIDefaultRoute+path, body)
+		if token != "" {
+			req.Header.Add("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+	decode := func(res *http.Response, value interface{}) {
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(data, value))
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		assert.Equal(t, "401 Unauthorized", serve(http.MethodGet, "dispatches", "", nil).Status)
+		assert.Equal(t, "401 Unauthorized", serve(http.MethodGet, "dispatches", "wrong", nil).Status)
+	})
+	t.Run("Unknown Route", func(t *testing.T) {
+		assert.Equal(t, "404 Not Found", serve(http.MethodGet, "unknown", "secret", nil).Status)
+		assert.Equal(t, "404 Not Found", serve(http.MethodPost, "dispatches", "secret", nil).Status)
+	})
+	t.Run("List Dispatches", func(t *testing.T) {
+		var records []store.DispatchRecord
+		res := serve(http.MethodGet, "dispatches", "secret", nil)
+		assert.Equal(t, "200 OK", res.Status)
+		decode(res, &records)
+		assert.Len(t, records, 3)
+		assert.Equal(t, "token-2", records[0].Token)
+
+		decode(serve(http.MethodGet, "dispatches?repository=mattermost/test&limit=1", "secret", nil), &records)
+		assert.Len(t, records, 1)
+		assert.Equal(t, "token-2", records[0].Token)
+
+		decode(serve(http.MethodGet, "dispatches?state=failed", "secret", nil), &records)
+		assert.Len(t, records, 1)
+		assert.Equal(t, "token-3", records[0].Token)
+
+		assert.Equal(t, "400 Bad Request", serve(http.MethodGet, "dispatches?limit=none", "secret", nil).Status)
+	})
+	t.Run("Get Dispatch", func(t *testing.T) {
+		var record store.DispatchRecord
+		res := serve(http.MethodGet, "dispatches/token-1", "secret", nil)
+		assert.Equal(t, "200 OK", res.Status)
+		decode(res, &record)
+		assert.Equal(t, store.DispatchQueued, record.State)
+		assert.Equal(t, "404 Not Found", serve(http.MethodGet, "dispatches/unknown", "secret", nil).Status)
+	})
+	t.Run("Approve Dispatch", func(t *testing.T) {
+		dispatches.Save(store.DispatchRecord{Token: "pending", State: store.DispatchPending, CreatedAt: now.Add(-2 * time.Hour)})
+		dispatches.Save(store.DispatchRecord{Token: "approved", State: store.DispatchQueued, CreatedAt: now.Add(-2 * time.Hour)})
+		dispatches.Save(store.DispatchRecord{Token: "expired", State: store.DispatchPending, CreatedAt: now.Add(-2 * time.Hour)})
+		assert.Equal(t, "404 Not Found", serve(http.MethodPost, "dispatches/unknown/approve", "secret", nil).Status)
+		assert.Equal(t, "409 Conflict", serve(http.MethodPost, "dispatches/approved/approve", "secret", nil).Status)
+		assert.Equal(t, "410 Gone", serve(http.MethodPost, "dispatches/expired/approve", "secret", nil).Status)
+
+		var record store.DispatchRecord
+		res := serve(http.MethodPost, "dispatches/pending/approve", "secret", nil)
+		assert.Equal(t, "200 OK", res.Status)
+		decode(res, &record)
+		assert.Equal(t, defaultAdminApprover, record.ApprovedBy)
+		serve(http.MethodPost, "dispatches/pending/approve", "secret", bytes.NewBufferString(`{"approver": "maintainer"}`))
+		assert.Equal(t, []string{defaultAdminApprover, "maintainer"}, dispatcher.approvals)
+	})
+	t.Run("Get Event Context", func(t *testing.T) {
+		var data model.EventTemplateData
+		res := serve(http.MethodGet, "tokens/token-1/event-context", "secret", nil)
+		assert.Equal(t, "200 OK", res.Status)
+		decode(res, &data)
+		assert.Equal(t, model.ManualEvent, data.Event)
+		assert.Equal(t, "mattermost/test", data.Repository)
+		assert.Equal(t, "master", data.Name)
+		assert.Equal(t, "abc", data.CommitHash)
+		assert.Equal(t, "404 Not Found", serve(http.MethodGet, "tokens/unknown/event-context", "secret", nil).Status)
+	})
+	t.Run("Revoke Token Failure", func(t *testing.T) {
+		clientManager.fail = true
+		defer func() { clientManager.fail = false }()
+		assert.Equal(t, "502 Bad Gateway", serve(http.MethodDelete, "tokens/token-2", "secret", nil).Status)
+		_, err := eventContextStore.Get("token-2")
+		assert.Nil(t, err)
+	})
+	t.Run("Revoke Token", func(t *testing.T) {
+		assert.Equal(t, "204 No Content", serve(http.MethodDelete, "tokens/token-2", "secret", nil).Status)
+		assert.Equal(t, []int64{10}, clientManager.revoked)
+		_, err := eventContextStore.Get("token-2")
+		assert.Error(t, err)
+		record, _ := dispatches.Get("token-2")
+		assert.NotNil(t, record.RevokedAt)
+
+		// An unbound token has no access token to revoke yet.
+		assert.Equal(t, "204 No Content", serve(http.MethodDelete, "tokens/token-1", "secret", nil).Status)
+		assert.Len(t, clientManager.revoked, 1)
+		assert.Equal(t, "404 Not Found", serve(http.MethodDelete, "tokens/unknown", "secret", nil).Status)
+	})
+	t.Run("Trigger Pipeline", func(t *testing.T) {
+		request := func(body string) io.Reader {
+			return bytes.NewBufferString(body)
+		}
+		assert.Equal(t, "400 Bad Request", serve(http.MethodPost, "pipelines/trigger", "secret", request(`{"pipeline": "mattermost/delivery/build.yml"}`)).Status)
+		assert.Equal(t, "404 Not Found", serve(http.MethodPost, "pipelines/trigger", "secret", request(`{"pipeline": "mattermost/delivery/unknown.yml", "repository": "mattermost/test", "ref": "master", "sha": "abc"}`)).Status)
+		assert.Equal(t, "400 Bad Request", serve(http.MethodPost, "pipelines/trigger", "secret", request(`{"pipeline": "mattermost/delivery/build.yml", "repository": "mattermost/unknown", "ref": "master", "sha": "abc"}`)).Status)
+
+		var record store.DispatchRecord
+		res := serve(http.MethodPost, "pipelines/trigger", "secret", request(`{"pipeline": "mattermost/delivery/build.yml", "repository": "mattermost/test", "ref": "v1.0.0", "sha": "abc", "type": "tag"}`))
+		assert.Equal(t, "201 Created", res.Status)
+		decode(res, &record)
+		assert.Equal(t, "mattermost/delivery/build.yml", record.Pipeline)
+		assert.Equal(t, int64(100), record.InstallationID)
+		assert.Len(t, dispatcher.triggered, 1)
+		assert.Equal(t, "tag", dispatcher.triggered[0].GetType())
+		assert.Equal(t, "v1.0.0", dispatcher.triggered[0].GetName())
+
+		dispatcher.fail = true
+		defer func() { dispatcher.fail = false }()
+		assert.Equal(t, "502 Bad Gateway", serve(http.MethodPost, "pipelines/trigger", "secret", request(`{"pipeline": "mattermost/delivery/build.yml", "repository": "mattermost/test", "ref": "master", "sha": "abc", "installation_id": 100}`)).Status)
+	})
+	t.Run("List Dead Letters", func(t *testing.T) {
+		var letters []store.DeadLetter
+		res := serve(http.MethodGet, "dead-letters", "secret", nil)
+		assert.Equal(t, "200 OK", res.Status)
+		decode(res, &letters)
+		assert.Len(t, letters, 2)
+	})
+	t.Run("Redrive Dead Letter Queue Full", func(t *testing.T) {
+		dispatcher.full = true
+		defer func() { dispatcher.full = false }()
+		res := serve(http.MethodPost, "dead-letters/1/redrive", "secret", nil)
+		assert.Equal(t, "503 Service Unavailable", res.Status)
+		assert.Equal(t, queueFullRetryAfter, res.Header.Get("Retry-After"))
+		_, err := deadLetters.Get("1")
+		assert.Nil(t, err)
+	})
+	t.Run("Redrive Dead Letter", func(t *testing.T) {
+		assert.Equal(t, "404 Not Found", serve(http.MethodPost, "dead-letters/3/redrive", "secret", nil).Status)
+		assert.Equal(t, "202 Accepted", serve(http.MethodPost, "dead-letters/1/redrive", "secret", nil).Status)
+		assert.Len(t, dispatcher.redriven, 1)
+		assert.Equal(t, "100", dispatcher.redriven[0].DeliveryID)
+		_, err := deadLetters.Get("1")
+		assert.Error(t, err)
+	})
+	t.Run("Discard Dead Letter", func(t *testing.T) {
+		assert.Equal(t, "404 Not Found", serve(http.MethodDelete, "dead-letters/3", "secret", nil).Status)
+		assert.Equal(t, "204 No Content", serve(http.MethodDelete, "dead-letters/2", "secret", nil).Status)
+		assert.Len(t, dispatcher.redriven, 1)
+		letters, _ := deadLetters.List()
+		assert.Empty(t, letters)
+	})
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/mattermost/release-bot/client"
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	commandPrefix = "/release-bot"
+
+	approveCommand = "approve"
+	runCommand     = "run"
+	retryCommand   = "retry"
+
+	// Reactions put on command comments, the result is replied as a comment.
+	reactionAccepted = "+1"
+	reactionRejected = "-1"
+	reactionFailed   = "confused"
+
+	// minCommitPrefix is the shortest commit hash accepted by commands, like the short hashes shown by GitHub.
+	minCommitPrefix = 7
+)
+
+type command struct {
+	Name string
+	Args []string
+}
+
+// parseCommand parses the command from the first line of a comment, like "/release-bot run e2e".
+func parseCommand(body string) (command, bool) {
+	firstLine := strings.SplitN(strings.TrimSpace(body), "\n", 2)[0]
+	fields := strings.Fields(firstLine)
+	if len(fields) < 2 || fields[0] != commandPrefix {
+		return command{}, false
+	}
+	return command{Name: fields[1], Args: fields[2:]}, true
+}
+
+/*
+processCommand runs the command commented on a pull request by a user with write access.
+A reaction is put on the comment and the result is replied as a comment.
+Commands are not retried once they are run, so they are not run twice for the same comment.
+*/
+func (gh *githubHookHandler) processCommand(d dispatch, eventContext *model.IssueCommentEventContext) error {
+	cmd, ok := parseCommand(eventContext.GetCommentBody())
+	if !ok || eventContext.GetAction() != "created" || !eventContext.IsPullRequest() {
+		return nil
+	}
+	commenter := eventContext.GetCommenter()
+	logger := log.WithFields(log.Fields{
+		"delivery_id": d.DeliveryID,
+		"repository":  eventContext.GetRepository(),
+		"pr":          eventContext.GetIssueNumber(),
+		"commenter":   commenter,
+		"command":     cmd.Name,
+	})
+
+	client, err := gh.ClientManager.Get(d.App, eventContext.GetInstallationID())
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	owner, repo := eventContext.GetOwner(), eventContext.GetRepositoryName()
+	permission, _, err := client.Repositories.GetPermissionLevel(ctx, owner, repo, commenter)
+	if err != nil {
+		return ignorePermanentError(logger, err, "Can not check commenter permission")
+	}
+	if !hasWriteAccess(permission.GetPermission()) {
+		logger.WithField("permission", permission.GetPermission()).Warn("Command is ignored, commenter has no write access")
+		react(ctx, client, eventContext, reactionRejected)
+		return nil
+	}
+	pullRequest, _, err := client.PullRequests.Get(ctx, owner, repo, eventContext.GetIssueNumber())
+	if err != nil {
+		return ignorePermanentError(logger, err, "Can not get pull request")
+	}
+	eventContext.SetPullRequest(pullRequest)
+
+	logger.Info("Will run command!")
+	result, err := gh.runCommand(d.App, cmd, eventContext)
+	if err != nil {
+		logger.WithError(err).Error("Command failed")
+		react(ctx, client, eventContext, reactionFailed)
+		message := fmt.Sprintf("@%s `%s %s` failed: %s", commenter, commandPrefix, cmd.Name, err.Error())
+		if result != "" {
+			message += "\n" + result
+		}
+		reply(ctx, client, eventContext, message)
+		return nil
+	}
+	react(ctx, client, eventContext, reactionAccepted)
+	reply(ctx, client, eventContext, fmt.Sprintf("@%s %s", commenter, result))
+	return nil
+}
+
+func (gh *githubHookHandler) runCommand(app string, cmd command, eventContext *model.IssueCommentEventContext) (string, error) {
+	switch cmd.Name {
+	case approveCommand:
+		if len(cmd.Args) != 1 {
+			return "", fmt.Errorf("usage is `%s %s <commit>`", commandPrefix, approveCommand)
+		}
+		if err := checkReviewedCommit(eventContext, cmd.Args[0]); err != nil {
+			return "", err
+		}
+		approved, err := gh.approvePullRequest(eventContext)
+		if len(approved) == 0 && err == nil {
+			return "", fmt.Errorf("no pipeline is waiting for approval of %s", eventContext.GetCommitHash())
+		}
+		return describeDispatches("approved", approved), err
+	case runCommand:
+		if len(cmd.Args) != 2 {
+			return "", fmt.Errorf("usage is `%s %s <pipeline> <commit>`", commandPrefix, runCommand)
+		}
+		if err := checkReviewedCommit(eventContext, cmd.Args[1]); err != nil {
+			return "", err
+		}
+		return gh.runNamedPipeline(app, cmd.Args[0], eventContext)
+	case retryCommand:
+		retried, err := gh.retryPullRequest(eventContext)
+		if len(retried) == 0 && err == nil {
+			return "", fmt.Errorf("no failed pipeline is found for %s", eventContext.GetCommitHash())
+		}
+		return describeDispatches("retried", retried), err
+	default:
+		return "", fmt.Errorf("unknown command %s, supported commands are %s, %s and %s", cmd.Name, approveCommand, runCommand, retryCommand)
+	}
+}
+
+/*
+checkReviewedCommit makes sure the commit named in the command is still the head of the pull request.
+Commands are processed after they are commented, so a commit pushed in between is not run without being reviewed.
+*/
+func checkReviewedCommit(eventContext *model.IssueCommentEventContext, commit string) error {
+	head := eventContext.GetCommitHash()
+	if len(commit) < minCommitPrefix || !strings.HasPrefix(head, strings.ToLower(commit)) {
+		return fmt.Errorf("%s is not the head commit %s of the pull request, review the head commit and comment again", commit, head)
+	}
+	return nil
+}
+
+// runNamedPipeline triggers the pipeline with the given name for the head commit of the pull request.
+func (gh *githubHookHandler) runNamedPipeline(app string, name string, eventContext *model.IssueCommentEventContext) (string, error) {
+	var pipeline *config.PipelineConfig
+	for i := range gh.Pipelines {
+		if gh.Pipelines[i].Name == name {
+			pipeline = &gh.Pipelines[i]
+			break
+		}
+	}
+	if pipeline == nil {
+		return "", fmt.Errorf("no pipeline is named %s", name)
+	}
+	// The commenter has write access, which approves running pipelines on forks which wait for an approval.
+	approver := ""
+	if eventContext.IsFork() {
+		if !pipeline.AllowsForks() {
+			return "", fmt.Errorf("pipeline %s does not run for forks", name)
+		}
+		if pipeline.RequiresForkApproval() {
+			approver = eventContext.GetCommenter()
+		}
+	}
+	record, err := gh.triggerApprovedPipeline(context.Background(), app, eventContext, *pipeline, approver)
+	if err != nil {
+		return "", err
+	}
+	return describeDispatches("triggered", []store.DispatchRecord{record}), nil
+}
+
+/*
+retryPullRequest triggers the failed pipelines of the head commit of the pull request again,
+with the events they are triggered for at first. Each pipeline is retried once, for its latest dispatch.
+*/
+func (gh *githubHookHandler) retryPullRequest(eventContext *model.IssueCommentEventContext) ([]store.DispatchRecord, error) {
+	records, err := gh.Dispatches.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "Can not list dispatches")
+	}
+	seen := make(map[string]bool)
+	var retried []store.DispatchRecord
+	var lastErr error
+	for _, record := range records {
+		if record.Repository != eventContext.GetRepository() || record.CommitHash != eventContext.GetCommitHash() || seen[record.Pipeline] {
+			continue
+		}
+		seen[record.Pipeline] = true
+		if !isFailedDispatch(record) {
+			continue
+		}
+		pipeline, found := findPipeline(gh.Pipelines, record.Pipeline)
+		if !found {
+			continue
+		}
+		original, err := gh.EventContextStore.Get(record.Token)
+		if err != nil {
+			lastErr = fmt.Errorf("event of pipeline %s is expired, it can not be retried", record.Pipeline)
+			continue
+		}
+		retry, err := gh.triggerApprovedPipeline(context.Background(), record.App, original, pipeline, record.ApprovedBy)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		retried = append(retried, retry)
+	}
+	return retried, lastErr
+}
+
+func isFailedDispatch(record store.DispatchRecord) bool {
+	if record.State == store.DispatchFailed {
+		return true
+	}
+	return record.State == store.DispatchCompleted && isFailedConclusion(record.Conclusion)
+}
+
+func describeDispatches(verb string, records []store.DispatchRecord) string {
+	lines := []string{fmt.Sprintf("%d pipeline(s) %s:", len(records), verb)}
+	for _, record := range records {
+		lines = append(lines, fmt.Sprintf("- `%s` for %s", record.Pipeline, record.CommitHash))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func react(ctx context.Context, client *github.Client, eventContext *model.IssueCommentEventContext, reaction string) {
+	_, _, err := client.Reactions.CreateIssueCommentReaction(ctx, eventContext.GetOwner(), eventContext.GetRepositoryName(), eventContext.GetCommentID(), reaction)
+	if err != nil {
+		log.WithError(err).WithField("comment_id", eventContext.GetCommentID()).Warn("Can not react to comment")
+	}
+}
+
+func reply(ctx context.Context, client *github.Client, eventContext *model.IssueCommentEventContext, body string) {
+	_, _, err := client.Issues.CreateComment(ctx, eventContext.GetOwner(), eventContext.GetRepositoryName(), eventContext.GetIssueNumber(), &github.IssueComment{Body: &body})
+	if err != nil {
+		log.WithError(err).WithField("pr", eventContext.GetIssueNumber()).Warn("Can not reply to comment")
+	}
+}
+
+func hasWriteAccess(permission string) bool {
+	return permission == "admin" || permission == "write"
+}
+
+func ignorePermanentError(logger *log.Entry, err error, message string) error {
+	if client.IsPermanentError(err) {
+		logger.WithError(err).Error(message)
+		return nil
+	}
+	return errors.Wrap(err, message)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommand(t *testing.T) {
+	cmd, ok := parseCommand("  /release-bot run e2e ab7a32c\r\nThanks!")
+	assert.True(t, ok)
+	assert.Equal(t, command{Name: "run", Args: []string{"e2e", "ab7a32c"}}, cmd)
+	cmd, ok = parseCommand("/release-bot retry")
+	assert.True(t, ok)
+	assert.Equal(t, command{Name: "retry", Args: []string{}}, cmd)
+	_, ok = parseCommand("/release-bot")
+	assert.False(t, ok)
+	_, ok = parseCommand("Please /release-bot retry")
+	assert.False(t, ok)
+	_, ok = parseCommand("/release-botretry")
+	assert.False(t, ok)
+}
+
+func TestChatOpsCommands(t *testing.T) {
+	const headSHA = "ab7a32c308ac42df77385bbb5e97f0e3aac5c42f"
+	fixture, _ := os.ReadFile("testdata/issue_comment_event_approve.json")
+	comment := func(body string) dispatch {
+		encoded, _ := json.Marshal(body)
+		payload := strings.Replace(string(fixture), `"/release-bot approve ab7a32c\r\nLooks safe to build."`, string(encoded), 1)
+		return dispatch{EventType: model.IssueCommentEvent, DeliveryID: "200", Payload: []byte(payload)}
+	}
+	newConfig := func() *config.Config {
+		return &config.Config{
+			Queue: config.QueueConfig{Limit: 10, Workers: 1},
+			Pipelines: []config.PipelineConfig{
+				{
+					Name:         "e2e",
+					Organization: "mattermost",
+					Repository:   "test",
+					Workflow:     "e2e.yaml",
+				},
+				{
+					Name:         "build",
+					Organization: "mattermost",
+					Repository:   "test",
+					Workflow:     "build.yaml",
+					Inputs:       map[string]string{config.ApprovedByInput: ""},
+					Conditions: []config.PipelineCondition{
+						{Webhook: []string{"workflow_run"}, Type: "pr", ForkApproval: true},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("Run Named Pipeline", func(t *testing.T) {
+		dispatches := store.NewMemoryDispatchStore()
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: headSHA}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+		assert.Nil(t, handler.processEvent(comment("/release-bot run e2e ab7a32c")))
+		assert.Equal(t, []string{"e2e.yaml"}, clientManager.Dispatched())
+		assert.Empty(t, clientManager.Approvers())
+		assert.Equal(t, []string{reactionAccepted}, clientManager.Reactions())
+		assert.Len(t, clientManager.Comments(), 1)
+		assert.Contains(t, clientManager.Comments()[0], "@maintainer 1 pipeline(s) triggered:")
+		assert.Contains(t, clientManager.Comments()[0], "`mattermost/test/e2e.yaml` for "+headSHA)
+
+		records, _ := dispatches.List()
+		assert.Len(t, records, 1)
+		assert.Equal(t, model.IssueCommentEvent, records[0].Event)
+		assert.Equal(t, headSHA, records[0].CommitHash)
+		eventContext, err := handler.EventContextStore.Get(records[0].Token)
+		assert.Nil(t, err)
+		assert.Equal(t, "feature", eventContext.GetName())
+		assert.Equal(t, 1, eventContext.GetPullRequestNumber())
+	})
+	t.Run("Run Named Pipeline On Fork", func(t *testing.T) {
+		clientManager := &mockDispatchClientCache{permission: "admin", headSHA: headSHA, headRepository: "contributor/release-bot"}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager})
+		assert.Nil(t, handler.processEvent(comment("/release-bot run e2e ab7a32c")))
+		assert.Empty(t, clientManager.Dispatched())
+		assert.Equal(t, []string{reactionFailed}, clientManager.Reactions())
+		assert.Contains(t, clientManager.Comments()[0], "pipeline e2e does not run for forks")
+
+		// The command of a maintainer approves pipelines which wait for approval on forks.
+		assert.Nil(t, handler.processEvent(comment("/release-bot run build ab7a32c")))
+		assert.Equal(t, []string{"build.yaml"}, clientManager.Dispatched())
+		assert.Equal(t, []string{"maintainer"}, clientManager.Approvers())
+	})
+	t.Run("Approve Reviewed Commit Only", func(t *testing.T) {
+		// The fork author pushed another commit after the maintainer reviewed ab7a32c.
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: "0000000000000000000000000000000000000000"}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager})
+		assert.Nil(t, handler.processEvent(comment("/release-bot approve ab7a32c")))
+		assert.Nil(t, handler.processEvent(comment("/release-bot approve 0000")))
+		assert.Nil(t, handler.processEvent(comment("/release-bot approve")))
+		assert.Equal(t, []string{reactionFailed, reactionFailed, reactionFailed}, clientManager.Reactions())
+		assert.Contains(t, clientManager.Comments()[0], "ab7a32c is not the head commit 0000000000000000000000000000000000000000")
+		assert.Contains(t, clientManager.Comments()[1], "0000 is not the head commit")
+		assert.Contains(t, clientManager.Comments()[2], "usage is `/release-bot approve <commit>`")
+	})
+	t.Run("Retry", func(t *testing.T) {
+		dispatches := store.NewMemoryDispatchStore()
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: headSHA, failing: "e2e.yaml", failures: 1}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+		assert.Nil(t, handler.processEvent(comment("/release-bot retry")))
+		assert.Contains(t, clientManager.Comments()[0], "no failed pipeline is found")
+
+		assert.Nil(t, handler.processEvent(comment("/release-bot run e2e ab7a32c")))
+		records, _ := dispatches.List()
+		assert.Equal(t, store.DispatchFailed, records[0].State)
+
+		assert.Nil(t, handler.processEvent(comment("/release-bot retry")))
+		assert.Equal(t, []string{"e2e.yaml", "e2e.yaml"}, clientManager.Dispatched())
+		records, _ = dispatches.List()
+		assert.Len(t, records, 2)
+		assert.Equal(t, store.DispatchQueued, records[0].State)
+		assert.Contains(t, clientManager.Comments()[2], "1 pipeline(s) retried:")
+	})
+	t.Run("Unknown Command", func(t *testing.T) {
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: headSHA}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager})
+		assert.Nil(t, handler.processEvent(comment("/release-bot deploy")))
+		assert.Nil(t, handler.processEvent(comment("/release-bot run unknown ab7a32c")))
+		assert.Equal(t, []string{reactionFailed, reactionFailed}, clientManager.Reactions())
+		assert.Contains(t, clientManager.Comments()[0], "unknown command deploy")
+		assert.Contains(t, clientManager.Comments()[1], "no pipeline is named unknown")
+	})
+	t.Run("Run Reviewed Commit Only", func(t *testing.T) {
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: "0000000000000000000000000000000000000000"}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager})
+		assert.Nil(t, handler.processEvent(comment("/release-bot run e2e ab7a32c")))
+		assert.Nil(t, handler.processEvent(comment("/release-bot run e2e")))
+		assert.Empty(t, clientManager.Dispatched())
+		assert.Equal(t, []string{reactionFailed, reactionFailed}, clientManager.Reactions())
+		assert.Contains(t, clientManager.Comments()[0], "ab7a32c is not the head commit")
+		assert.Contains(t, clientManager.Comments()[1], "usage is `/release-bot run <pipeline> <commit>`")
+	})
+	t.Run("Commenter Without Write Access", func(t *testing.T) {
+		clientManager := &mockDispatchClientCache{permission: "read", headSHA: headSHA}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager})
+		assert.Nil(t, handler.processEvent(comment("/release-bot run e2e ab7a32c")))
+		assert.Empty(t, clientManager.Dispatched())
+		assert.Equal(t, []string{reactionRejected}, clientManager.Reactions())
+		assert.Empty(t, clientManager.Comments())
+	})
+	t.Run("Not A Command", func(t *testing.T) {
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: headSHA}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager})
+		assert.Nil(t, handler.processEvent(comment("LGTM")))
+		assert.Empty(t, clientManager.Reactions())
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v45/github"
+	"github.com/mattermost/release-bot/client"
+	"github.com/mattermost/release-bot/store"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	commitStatusPending = "pending"
+	commitStatusSuccess = "success"
+	commitStatusFailure = "failure"
+	commitStatusError   = "error"
+)
+
+/*
+commitStatusState maps the conclusion of the private workflow run to a commit status state.
+Neutral and skipped runs did not fail, cancelled runs and runs waiting for an approval did not complete,
+which is reported as an error.
+*/
+func commitStatusState(conclusion string) string {
+	switch {
+	case conclusion == "success" || conclusion == "neutral" || conclusion == "skipped":
+		return commitStatusSuccess
+	case isFailedConclusion(conclusion) && conclusion != "cancelled" && conclusion != "action_required":
+		return commitStatusFailure
+	default:
+		return commitStatusError
+	}
+}
+
+/*
+Report the state of the dispatched pipeline as a commit status on the source commit.
+Dispatches of pipelines without a status context are ignored. Failures are only logged,
+the status is informational and must not fail the dispatch.
+*/
+func reportCommitStatus(clientManager client.GithubClientManager, record store.DispatchRecord, state string, description string) {
+	if record.StatusContext == "" {
+		return
+	}
+	logger := log.WithFields(log.Fields{
+		"repository": record.Repository,
+		"sha":        record.CommitHash,
+		"context":    record.StatusContext,
+		"state":      state,
+	})
+	segments := strings.SplitN(record.Repository, "/", 2)
+	if len(segments) != 2 || record.CommitHash == "" {
+		logger.Warn("Can not report commit status without repository and commit!")
+		return
+	}
+	client, err := clientManager.Get(record.App, record.InstallationID)
+	if err != nil {
+		logger.WithError(err).Error("Can not find installation id at cache!")
+		return
+	}
+	status := &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(record.StatusContext),
+	}
+	if record.TargetURL != "" {
+		status.TargetURL = github.String(record.TargetURL)
+	}
+	if _, _, err := client.Repositories.CreateStatus(context.Background(), segments[0], segments[1], record.CommitHash, status); err != nil {
+		logger.WithError(err).Error("Can not report commit status!")
+		return
+	}
+	logger.Info("Commit status is reported")
+}
+
+func completedStatusDescription(conclusion string) string {
+	return fmt.Sprintf("Pipeline completed with %s", conclusion)
+}
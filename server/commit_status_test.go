@@ -0,0 +1,26 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitStatusState(t *testing.T) {
+	tests := map[string]string{
+		"success":         commitStatusSuccess,
+		"neutral":         commitStatusSuccess,
+		"skipped":         commitStatusSuccess,
+		"failure":         commitStatusFailure,
+		"timed_out":       commitStatusFailure,
+		"startup_failure": commitStatusFailure,
+		"cancelled":       commitStatusError,
+		"action_required": commitStatusError,
+		"stale":           commitStatusError,
+	}
+	for conclusion, state := range tests {
+		t.Run(conclusion, func(t *testing.T) {
+			assert.Equal(t, state, commitStatusState(conclusion))
+		})
+	}
+}
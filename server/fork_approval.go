@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotPending      = errors.New("dispatch is not waiting for approval")
+	ErrApprovalExpired = errors.New("approval is expired")
+)
+
+// parkPipeline records the dispatch of a fork event without triggering it, until a maintainer approves it.
+func (h *githubHookHandler) parkPipeline(ctx context.Context, app string, eventContext model.EventContext, pipeline config.PipelineConfig) (store.DispatchRecord, error) {
+	if err := h.resolveCommit(ctx, app, eventContext); err != nil {
+		return store.DispatchRecord{}, err
+	}
+	log.WithFields(log.Fields{
+		"type":     "approval",
+		"org":      pipeline.Organization,
+		"repo":     pipeline.Repository,
+		"workflow": pipeline.Workflow,
+		"source":   eventContext.GetRepository(),
+		"sha":      eventContext.GetCommitHash(),
+	}).Info("Pipeline waits for approval!")
+
+	token := uuid.New().String()
+	// Inputs are rendered at approval again, this only catches broken templates early.
+	if _, err := model.RenderPipelineInputs(eventContext, pipeline, token, h.BaseURL); err != nil {
+		return store.DispatchRecord{}, errors.Wrap(err, "Can not render pipeline inputs")
+	}
+	record, err := h.saveDispatch(app, eventContext, pipeline, token, store.DispatchPending, "")
+	if err != nil {
+		return store.DispatchRecord{}, err
+	}
+	reportCommitStatus(h.ClientManager, record, commitStatusPending, "Pipeline is waiting for maintainer approval")
+	return record, nil
+}
+
+// Approve dispatches a pipeline waiting for approval, the approver is passed to the pipeline if it declares the input.
+func (h *githubHookHandler) Approve(token string, approver string) (store.DispatchRecord, error) {
+	record, err := h.Dispatches.Get(token)
+	if err != nil {
+		return store.DispatchRecord{}, err
+	}
+	if record.State != store.DispatchPending {
+		return record, ErrNotPending
+	}
+	pipeline, found := findPipeline(h.Pipelines, record.Pipeline)
+	eventContext, err := h.EventContextStore.Get(token)
+	if err != nil || !found || (record.ExpiresAt != nil && record.ExpiresAt.Before(time.Now())) {
+		return h.expire(record)
+	}
+
+	approved := false
+	record, err = h.Dispatches.Update(token, func(record *store.DispatchRecord) {
+		approved = record.State == store.DispatchPending
+		if approved {
+			record.State = store.DispatchQueued
+			record.ApprovedBy = approver
+			record.UpdatedAt = time.Now()
+		}
+	})
+	if err != nil {
+		return record, errors.Wrap(err, "Can not update dispatch record")
+	}
+	if !approved {
+		return record, ErrNotPending
+	}
+	log.WithFields(log.Fields{
+		"pipeline": record.Pipeline,
+		"source":   record.Repository,
+		"sha":      record.CommitHash,
+		"approver": approver,
+	}).Info("Pipeline is approved!")
+
+	record, err = h.dispatchApproved(eventContext, pipeline, record, approver)
+	h.notifyDispatch(pipeline, eventContext, record, err)
+	return record, err
+}
+
+func (h *githubHookHandler) dispatchApproved(eventContext model.EventContext, pipeline config.PipelineConfig, record store.DispatchRecord, approver string) (store.DispatchRecord, error) {
+	client, err := h.dispatchClient(record.App, pipeline)
+	if err != nil {
+		return h.failDispatch(record), err
+	}
+	inputs, err := model.RenderPipelineInputs(eventContext, pipeline, record.Token, h.BaseURL)
+	if err != nil {
+		return h.failDispatch(record), errors.Wrap(err, "Can not render pipeline inputs")
+	}
+	pipeline.SetApprover(inputs, approver)
+	return h.dispatchWorkflow(context.Background(), client, pipeline, record, inputs)
+}
+
+/*
+ExpireApprovals expires the pipelines which are not approved in time, so their commit status does not stay pending.
+Approvals are also expired when they are given too late, this catches the ones which are never given.
+*/
+func (h *githubHookHandler) ExpireApprovals() (int, error) {
+	records, err := h.Dispatches.List()
+	if err != nil {
+		return 0, errors.Wrap(err, "Can not list dispatches")
+	}
+	now := time.Now()
+	expired := 0
+	for _, record := range records {
+		if record.State != store.DispatchPending || record.ExpiresAt == nil || record.ExpiresAt.After(now) {
+			continue
+		}
+		if _, err := h.expire(record); err == ErrApprovalExpired {
+			expired++
+		} else if err != ErrNotPending {
+			log.WithError(err).WithField("pipeline", record.Pipeline).Error("Can not expire pipeline approval")
+		}
+	}
+	return expired, nil
+}
+
+// expireApprovalsPeriodically expires pipelines which are not approved in time until done is closed.
+func (h *githubHookHandler) expireApprovalsPeriodically(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			expired, err := h.ExpireApprovals()
+			if err != nil {
+				log.WithError(err).Error("Error occurred while expiring pipeline approvals")
+				continue
+			}
+			log.WithField("expired", expired).Debug("Pipeline approvals are swept")
+		case <-done:
+			return
+		}
+	}
+}
+
+func (h *githubHookHandler) expire(record store.DispatchRecord) (store.DispatchRecord, error) {
+	record, err := h.Dispatches.Update(record.Token, func(record *store.DispatchRecord) {
+		if record.State == store.DispatchPending {
+			record.State = store.DispatchExpired
+			record.UpdatedAt = time.Now()
+		}
+	})
+	if err != nil {
+		return record, errors.Wrap(err, "Can not update dispatch record")
+	}
+	if record.State != store.DispatchExpired {
+		return record, ErrNotPending
+	}
+	if err := h.EventContextStore.Delete(record.Token); err != nil {
+		log.WithError(err).Warn("Can not delete event context")
+	}
+	log.WithField("pipeline", record.Pipeline).Info("Pipeline approval is expired")
+	reportCommitStatus(h.ClientManager, record, commitStatusError, "Pipeline approval is expired")
+	return record, ErrApprovalExpired
+}
+
+// approvePullRequest approves the pipelines waiting for the head commit of the pull request the comment is made on.
+func (h *githubHookHandler) approvePullRequest(eventContext *model.IssueCommentEventContext) ([]store.DispatchRecord, error) {
+	records, err := h.Dispatches.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "Can not list dispatches")
+	}
+	var approved []store.DispatchRecord
+	var lastErr error
+	// Only the head commit is approved, commits pushed before the approval are not trusted by it.
+	for _, record := range records {
+		if record.State != store.DispatchPending || record.Repository != eventContext.GetRepository() || record.CommitHash != eventContext.GetCommitHash() {
+			continue
+		}
+		record, err := h.Approve(record.Token, eventContext.GetCommenter())
+		if err != nil {
+			log.WithError(err).WithField("pipeline", record.Pipeline).Error("Can not dispatch approved pipeline")
+			lastErr = err
+			continue
+		}
+		approved = append(approved, record)
+	}
+	return approved, lastErr
+}
+
+func findPipeline(pipelines []config.PipelineConfig, key string) (config.PipelineConfig, bool) {
+	for _, pipeline := range pipelines {
+		if pipeline.Key() == key {
+			return pipeline, true
+		}
+	}
+	return config.PipelineConfig{}, false
+}
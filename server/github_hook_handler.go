@@ -1,9 +1,13 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/go-github/v45/github"
 	"github.com/google/uuid"
@@ -11,18 +15,35 @@ import (
 	"github.com/mattermost/release-bot/config"
 	"github.com/mattermost/release-bot/metric"
 	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/notifier"
 	"github.com/mattermost/release-bot/store"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	duplicateDeliveryHeader = "X-Release-Bot-Duplicate"
+	// Seconds a sender should wait before redelivering a rejected webhook.
+	queueFullRetryAfter = "30"
+	// GitHub sends the id of the app with the webhooks of its installations.
+	hookTargetIDHeader   = "X-GitHub-Hook-Installation-Target-ID"
+	hookTargetTypeHeader = "X-GitHub-Hook-Installation-Target-Type"
+	// Approvals must be given before the event context is gone, see config.EventContextTTL.
+	defaultApprovalTTL = 4 * time.Hour
+)
+
 type githubHookHandler struct {
-	WebhookSecret     []byte
+	Github            config.GithubConfig
 	Pipelines         []config.PipelineConfig
 	BaseURL           string
 	ClientManager     client.GithubClientManager
 	EventContextStore store.EventContextStore
+	Dispatches        store.DispatchStore
+	DeliveryLedger    store.DeliveryLedger
 	Scheduler         Scheduler
+	RetryPolicy       RetryPolicy
+	Notifier          notifier.Notifier
+	ApprovalTTL       time.Duration
 }
 
 func (gh *githubHookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -40,45 +61,164 @@ func (gh *githubHookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		metric.IncreaseCounter(metric.TotalFailureCount)
 		return
 	}
-	payload, err := github.ValidatePayload(r, gh.WebhookSecret)
+	app, found := gh.resolveApp(r)
+	if !found {
+		http.Error(w, "Unknown GitHub App", http.StatusNotFound)
+		metric.IncreaseCounter(metric.TotalFailureCount)
+		return
+	}
+	payload, err := validatePayload(r, app, deliveryID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		metric.IncreaseCounter(metric.TotalFailureCount)
 		return
 	}
 
-	log.WithField("type", eventType).Infof("%s is received!", eventType)
-	gh.Scheduler.Schedule(dispatch{
+	duplicate, err := gh.DeliveryLedger.MarkDelivered(deliveryID)
+	if err != nil {
+		log.WithError(err).WithField("delivery_id", deliveryID).Warn("Can not check delivery ledger, processing delivery")
+	}
+	if duplicate {
+		log.WithFields(log.Fields{
+			"type":        eventType,
+			"delivery_id": deliveryID,
+		}).Info("Duplicate delivery is ignored")
+		w.Header().Set(duplicateDeliveryHeader, "true")
+		w.WriteHeader(http.StatusOK)
+		metric.IncreaseCounter(metric.DuplicateDeliveryCount, metric.TotalSuccessCount)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"type": eventType,
+		"app":  app.Name,
+	}).Infof("%s is received!", eventType)
+	err = gh.Scheduler.Schedule(dispatch{
 		Processor:  gh.processEvent,
+		App:        app.Name,
 		EventType:  eventType,
 		DeliveryID: deliveryID,
 		Payload:    payload,
 	})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"type":        eventType,
+			"delivery_id": deliveryID,
+		}).Warn("Delivery is rejected")
+		// Let a redelivery of the rejected event through.
+		if err := gh.DeliveryLedger.Forget(deliveryID); err != nil {
+			log.WithError(err).WithField("delivery_id", deliveryID).Warn("Can not remove delivery from ledger")
+		}
+		w.Header().Set("Retry-After", queueFullRetryAfter)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		metric.IncreaseCounter(metric.RejectedDeliveryCount, metric.TotalFailureCount)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	metric.IncreaseCounter(metric.TotalSuccessCount)
 }
 
-func newGithubHookHandler(cc client.GithubClientManager, config *config.Config, eventContextStore store.EventContextStore) (http.Handler, error) {
-	scheduler, err := NewGithubEventScheduler(config.Queue.Limit, config.Queue.Workers)
+/*
+validatePayload validates the signature of the webhook with the secrets of the app. Previous secrets are accepted
+until they expire while the secret is rotated, webhooks signed with them are warned about until GitHub signs with the current one.
+*/
+func validatePayload(r *http.Request, app config.AppConfig, deliveryID string) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can not read webhook body")
+	}
+	var payload []byte
+	for i, secret := range app.GetWebhookSecrets(time.Now()) {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if payload, err = github.ValidatePayload(r, []byte(secret)); err != nil {
+			continue
+		}
+		logger := log.WithFields(log.Fields{
+			"app":         app.Name,
+			"delivery_id": deliveryID,
+			"secret":      i,
+		})
+		if i == 0 {
+			logger.Debug("Webhook is signed with the current secret")
+			metric.IncreaseCounter(metric.CurrentWebhookSecretCount)
+		} else {
+			logger.Warn("Webhook is signed with a previous secret, it is deprecated and the secret must be rotated on GitHub")
+			metric.IncreaseCounter(metric.PreviousWebhookSecretCount)
+		}
+		return payload, nil
+	}
+	return nil, err
+}
+
+/*
+resolveApp finds the app which sent the webhook, by the app name suffixed to the hook path like "/hook/private",
+or by the app id GitHub sends with the webhooks of apps. Otherwise the webhook belongs to the first app.
+*/
+func (gh *githubHookHandler) resolveApp(r *http.Request) (config.AppConfig, bool) {
+	if name := strings.TrimPrefix(r.URL.Path, githubHandlerDefaultRoute+"/"); name != r.URL.Path && name != "" {
+		return gh.Github.GetApp(name)
+	}
+	if r.Header.Get(hookTargetTypeHeader) == "integration" {
+		integrationID, err := strconv.ParseInt(r.Header.Get(hookTargetIDHeader), 10, 64)
+		if err != nil {
+			return config.AppConfig{}, false
+		}
+		return gh.Github.GetAppByIntegrationID(integrationID)
+	}
+	return gh.Github.GetApp("")
+}
+
+func newGithubHookHandler(cc client.GithubClientManager, config *config.Config, eventContextStore store.EventContextStore, dispatches store.DispatchStore, deliveryLedger store.DeliveryLedger, deadLetters store.DeadLetterStore, journal store.DispatchJournal) (*githubHookHandler, error) {
+	retryPolicy := NewRetryPolicy(config.Queue.Retry)
+	scheduler, err := NewGithubEventScheduler(config.Queue.Limit, config.Queue.Workers, config.Queue.EnqueueTimeout, retryPolicy, deadLetters, journal)
 	if err != nil {
 		return nil, errors.Wrap(err, "Scheduler error!")
 	}
-	return &githubHookHandler{
-		WebhookSecret:     []byte(config.Github.WebhookSecret),
+	gh := &githubHookHandler{
+		Github:            config.Github,
 		Pipelines:         config.Pipelines,
 		BaseURL:           config.Server.BaseURL,
 		ClientManager:     cc,
 		EventContextStore: eventContextStore,
+		Dispatches:        dispatches,
+		DeliveryLedger:    deliveryLedger,
 		Scheduler:         scheduler,
-	}, nil
+		RetryPolicy:       retryPolicy,
+		Notifier:          notifier.NewAsyncNotifier(notifier.NewMattermostNotifier(defaultNotificationTimeout), notificationQueueSize, notificationWorkers),
+		ApprovalTTL:       config.Approval.TTL,
+	}
+	if gh.ApprovalTTL <= 0 {
+		gh.ApprovalTTL = defaultApprovalTTL
+	}
+	if err := scheduler.Replay(gh.processEvent); err != nil {
+		return nil, errors.Wrap(err, "Scheduler error!")
+	}
+	return gh, nil
 }
 
-func (gh *githubHookHandler) processEvent(eventType string, deliveryID string, payload []byte) {
-	eventContext, err := model.ConvertPayloadToEventContext(eventType, payload)
+// Redrive schedules a dead letter again for the pipelines which could not be dispatched.
+func (gh *githubHookHandler) Redrive(letter store.DeadLetter) error {
+	return gh.Scheduler.Schedule(dispatch{
+		Processor:  gh.processEvent,
+		App:        letter.App,
+		EventType:  letter.EventType,
+		DeliveryID: letter.DeliveryID,
+		Payload:    letter.Payload,
+		Pipelines:  letter.Pipelines,
+	})
+}
+
+func (gh *githubHookHandler) processEvent(d dispatch) error {
+	eventContext, err := model.ConvertPayloadToEventContext(d.EventType, d.Payload)
 	if err != nil {
 		log.WithError(err).Error("Error occurred while deserializing request")
-		return
+		return nil
+	}
+	// Comments only drive pipelines through commands, they are not matched against pipeline conditions.
+	if commentContext, ok := eventContext.(*model.IssueCommentEventContext); ok {
+		commentContext.Log()
+		return gh.processCommand(d, commentContext)
 	}
 	if eventContext.GetType() == "tag" {
 		metric.IncreaseCounter(metric.TagRequestCount)
@@ -87,15 +227,21 @@ func (gh *githubHookHandler) processEvent(eventType string, deliveryID string, p
 	}
 	eventContext.Log()
 
-	if "workflow_run" == eventContext.GetEvent() && "completed" == eventContext.GetAction() {
+	if "workflow_run" == eventContext.GetEvent() && "completed" == eventContext.GetAction() && d.Attempt == 0 && len(d.Pipelines) == 0 {
 		if err := gh.ClientManager.RevokeToken(eventContext.GetRepository(), eventContext.GetWorkflowRunID()); err != nil {
 			log.WithError(err).Error("Error occurred while revoking pipeline token")
 		}
+		if err := gh.EventContextStore.ExpireBinding(eventContext.GetRepository(), eventContext.GetWorkflowRunID()); err != nil {
+			log.WithError(err).Error("Error occurred while expiring bot token binding")
+		}
+	}
+	if "workflow_run" == eventContext.GetEvent() && d.Attempt == 0 && len(d.Pipelines) == 0 {
+		gh.trackRun(eventContext)
 	}
 
-	pipeline := model.GetTargetPipeline(eventContext, gh.Pipelines)
+	pipelines := filterPipelines(model.GetTargetPipelines(eventContext, gh.Pipelines), d.Pipelines)
 
-	if pipeline == nil {
+	if len(pipelines) == 0 {
 		log.WithFields(log.Fields{
 			"type":       eventContext.GetType(),
 			"workflow":   eventContext.GetWorkflow(),
@@ -103,60 +249,219 @@ func (gh *githubHookHandler) processEvent(eventType string, deliveryID string, p
 			"repository": eventContext.GetRepository(),
 			"sha":        eventContext.GetCommitHash(),
 		}).Info("No pipeline configured")
-		return
+		return nil
+	}
+
+	var lastErr error
+	var transient, permanent []string
+	for _, pipeline := range pipelines {
+		trigger := gh.triggerPipeline
+		if model.RequiresApproval(eventContext, pipeline) {
+			trigger = gh.parkPipeline
+		}
+		record, err := trigger(context.Background(), d.App, eventContext, pipeline)
+		if err == nil && record.State != store.DispatchPending {
+			gh.notifyDispatch(pipeline, eventContext, record, nil)
+		}
+		if err != nil {
+			lastErr = err
+			// Only the last failure is notified, the dispatch is retried otherwise.
+			if client.IsPermanentError(err) || d.Attempt+1 >= gh.RetryPolicy.MaxAttempts {
+				gh.notifyDispatch(pipeline, eventContext, record, err)
+			}
+			if client.IsPermanentError(err) {
+				permanent = append(permanent, pipeline.Key())
+			} else {
+				transient = append(transient, pipeline.Key())
+			}
+			log.
+				WithError(err).
+				WithFields(log.Fields{
+					"delivery_id": d.DeliveryID,
+					"attempt":     d.Attempt,
+					"org":         pipeline.Organization,
+					"repo":        pipeline.Repository,
+					"workflow":    pipeline.Workflow,
+				}).
+				Error("Error occurred while triggering pipeline request")
+		}
+	}
+	if lastErr == nil {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"delivery_id": d.DeliveryID,
+		"matched":     len(pipelines),
+		"failed":      len(transient) + len(permanent),
+	}).Warn("Some pipelines could not be triggered")
+	return &dispatchError{
+		Transient: transient,
+		Permanent: permanent,
+		Err:       lastErr,
 	}
+}
 
-	if err := gh.triggerPipeline(context.Background(), eventContext, *pipeline); err != nil {
-		log.WithError(err).Error("Error occurred while triggering pipeline request")
+// filterPipelines keeps the pipelines listed in keys. If no key is given all pipelines are kept.
+func filterPipelines(pipelines []config.PipelineConfig, keys []string) []config.PipelineConfig {
+	if len(keys) == 0 {
+		return pipelines
+	}
+	var filtered []config.PipelineConfig
+	for _, pipeline := range pipelines {
+		for _, key := range keys {
+			if pipeline.Key() == key {
+				filtered = append(filtered, pipeline)
+				break
+			}
+		}
 	}
+	return filtered
+}
+
+// Trigger dispatches the pipeline for the event context of the app right away, without going through the scheduler.
+func (gh *githubHookHandler) Trigger(app string, eventContext model.EventContext, pipeline config.PipelineConfig) (store.DispatchRecord, error) {
+	eventContext.Log()
+	return gh.triggerPipeline(context.Background(), app, eventContext, pipeline)
+}
+
+func (h *githubHookHandler) triggerPipeline(ctx context.Context, app string, eventContext model.EventContext, pipeline config.PipelineConfig) (store.DispatchRecord, error) {
+	return h.triggerApprovedPipeline(ctx, app, eventContext, pipeline, "")
 }
 
-func (h *githubHookHandler) triggerPipeline(ctx context.Context, eventContext model.EventContext, pipeline config.PipelineConfig) error {
+// triggerApprovedPipeline triggers the pipeline and passes the approver to it, if the dispatch is approved by a maintainer.
+func (h *githubHookHandler) triggerApprovedPipeline(ctx context.Context, app string, eventContext model.EventContext, pipeline config.PipelineConfig, approver string) (store.DispatchRecord, error) {
 	log.WithFields(log.Fields{
 		"type":     "trigger",
+		"app":      app,
 		"org":      pipeline.Organization,
 		"repo":     pipeline.Repository,
 		"workflow": pipeline.Workflow,
+		"ref":      pipeline.GetRef(),
+		"approver": approver,
 	}).Info("Will trigger pipeline!")
 
-	client, err := h.ClientManager.Get(eventContext.GetInstallationID())
+	if err := h.resolveCommit(ctx, app, eventContext); err != nil {
+		log.WithError(err).WithField("repo", eventContext.GetRepository()).Error("Can not resolve commit of event!")
+		return store.DispatchRecord{}, err
+	}
+	client, err := h.dispatchClient(app, pipeline)
 
 	if err != nil {
 		log.
 			WithError(err).
-			WithField("installation_id", eventContext.GetInstallationID()).
-			Error("Can not find installation id at cache!")
-		return err
+			WithFields(log.Fields{
+				"org":  pipeline.Organization,
+				"repo": pipeline.Repository,
+			}).
+			Error("Can not find installation of pipeline repository!")
+		return store.DispatchRecord{}, err
 	}
 	token := uuid.New().String()
-	h.EventContextStore.Store(eventContext, token)
-	inputs := map[string]interface{}{
-		"repository":    eventContext.GetRepository(),
-		"name":          eventContext.GetName(),
-		"workflowRunId": strconv.FormatInt(eventContext.GetWorkflowRunID(), 10),
-		"commmitHash":   eventContext.GetCommitHash(),
-		"fork":          strconv.FormatBool(eventContext.IsFork()),
-		"type":          eventContext.GetType(),
-		"botToken":      token,
-		"botBaseUrl":    h.BaseURL,
+	inputs, err := model.RenderPipelineInputs(eventContext, pipeline, token, h.BaseURL)
+	if err != nil {
+		return store.DispatchRecord{}, errors.Wrap(err, "Can not render pipeline inputs")
 	}
+	pipeline.SetApprover(inputs, approver)
+	record, err := h.saveDispatch(app, eventContext, pipeline, token, store.DispatchQueued, approver)
+	if err != nil {
+		return store.DispatchRecord{}, err
+	}
+	return h.dispatchWorkflow(ctx, client, pipeline, record, inputs)
+}
+
+// resolveCommit resolves the commit of event contexts which do not carry it, through the installation sending the event.
+func (h *githubHookHandler) resolveCommit(ctx context.Context, app string, eventContext model.EventContext) error {
+	resolver, ok := eventContext.(model.CommitResolver)
+	if !ok {
+		return nil
+	}
+	client, err := h.ClientManager.Get(app, eventContext.GetInstallationID())
+	if err != nil {
+		return err
+	}
+	return resolver.ResolveCommitHash(ctx, client)
+}
+
+/*
+dispatchClient returns the client of the installation covering the repository of the pipeline, which is not
+the installation sending the event if the pipeline is in another organization. Pipelines are dispatched
+by the app which received the event, unless they name another app.
+*/
+func (h *githubHookHandler) dispatchClient(app string, pipeline config.PipelineConfig) (*github.Client, error) {
+	if pipeline.App != "" {
+		app = pipeline.App
+	}
+	return h.ClientManager.GetForRepository(app, pipeline.Organization, pipeline.Repository)
+}
+
+// saveDispatch stores the event context for the bot token and records the dispatch of the app with the given state.
+func (h *githubHookHandler) saveDispatch(app string, eventContext model.EventContext, pipeline config.PipelineConfig, token string, state string, approver string) (store.DispatchRecord, error) {
+	var err error
+	record := store.DispatchRecord{
+		Token:          token,
+		Pipeline:       pipeline.Key(),
+		Repository:     eventContext.GetRepository(),
+		CommitHash:     eventContext.GetCommitHash(),
+		InstallationID: eventContext.GetInstallationID(),
+		App:            app,
+		Event:          eventContext.GetEvent(),
+		State:          state,
+		ApprovedBy:     approver,
+		CreatedAt:      time.Now(),
+	}
+	record.UpdatedAt = record.CreatedAt
+	if state == store.DispatchPending {
+		expiresAt := record.CreatedAt.Add(h.ApprovalTTL)
+		record.ExpiresAt = &expiresAt
+	}
+	if pipeline.ReportsStatus() {
+		record.StatusContext = pipeline.Status.Context
+		if record.TargetURL, err = model.RenderStatusTargetURL(eventContext, pipeline); err != nil {
+			return store.DispatchRecord{}, errors.Wrap(err, "Can not render status target url")
+		}
+	}
+	if err := h.EventContextStore.Store(eventContext, token); err != nil {
+		log.WithError(err).Error("Can not store event context!")
+		// Store failures are retried, unlike other local errors.
+		return store.DispatchRecord{}, client.Transient(errors.Wrap(err, "Can not store event context"))
+	}
+	if err := h.Dispatches.Save(record); err != nil {
+		log.WithError(err).Error("Can not store dispatch record!")
+		return store.DispatchRecord{}, client.Transient(errors.Wrap(err, "Can not store dispatch record"))
+	}
+	return record, nil
+}
+
+func (h *githubHookHandler) dispatchWorkflow(ctx context.Context, client *github.Client, pipeline config.PipelineConfig, record store.DispatchRecord, inputs map[string]interface{}) (store.DispatchRecord, error) {
 	deRequest := github.CreateWorkflowDispatchEventRequest{
-		Ref:    "main",
+		Ref:    pipeline.GetRef(),
 		Inputs: inputs,
 	}
-	_, err = client.Actions.CreateWorkflowDispatchEventByFileName(ctx, pipeline.Organization, pipeline.Repository, pipeline.Workflow, deRequest)
+	_, err := client.Actions.CreateWorkflowDispatchEventByFileName(ctx, pipeline.Organization, pipeline.Repository, pipeline.Workflow, deRequest)
 	if err != nil {
 		log.
 			WithError(err).
 			WithFields(log.Fields{
-				"installation_id": eventContext.GetInstallationID(),
+				"installation_id": record.InstallationID,
 				"org":             pipeline.Organization,
 				"repo":            pipeline.Repository,
 				"workflow":        pipeline.Workflow,
 			}).
 			Error("Error occurred while triggering pipeline!")
-		return errors.Wrap(err, "")
+		return h.failDispatch(record), errors.Wrap(err, "")
 	}
+	reportCommitStatus(h.ClientManager, record, commitStatusPending, "Pipeline is triggered")
 
-	return nil
+	return record, nil
+}
+
+func (h *githubHookHandler) failDispatch(record store.DispatchRecord) store.DispatchRecord {
+	record.State = store.DispatchFailed
+	if _, err := h.Dispatches.Update(record.Token, func(record *store.DispatchRecord) {
+		record.State = store.DispatchFailed
+	}); err != nil {
+		log.WithError(err).Warn("Can not update dispatch record")
+	}
+	reportCommitStatus(h.ClientManager, record, commitStatusError, "Pipeline could not be triggered")
+	return record
 }
@@ -1,9 +1,19 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,6 +21,7 @@ import (
 	"github.com/mattermost/release-bot/client"
 	"github.com/mattermost/release-bot/config"
 	"github.com/mattermost/release-bot/metric"
+	"github.com/mattermost/release-bot/model"
 	"github.com/mattermost/release-bot/store"
 	"github.com/migueleliasweb/go-github-mock/src/mock"
 	"github.com/stretchr/testify/assert"
@@ -32,7 +43,10 @@ func (t *mockAccessToken) IsExpired() bool {
 func (t *mockAccessToken) GetToken() string {
 	return "gh-12345678"
 }
-func (cc *mockClientCache) Get(installationID int64) (*github.Client, error) {
+func (t *mockAccessToken) GetExpiresAt() time.Time {
+	return time.Now().Add(time.Hour)
+}
+func (cc *mockClientCache) Get(app string, installationID int64) (*github.Client, error) {
 	token := "gh-12345678"
 	mockedHTTPClient := mock.NewMockedHTTPClient(
 		mock.WithRequestMatch(
@@ -41,22 +55,256 @@ func (cc *mockClientCache) Get(installationID int64) (*github.Client, error) {
 				Token: &token,
 			},
 		),
+		mock.WithRequestMatchHandler(
+			mock.PostReposActionsWorkflowsDispatchesByOwnerByRepoByWorkflowId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}),
+		),
 	)
 	return github.NewClient(mockedHTTPClient), nil
 }
 
-func (cc *mockClientCache) CreateToken(repository string, runID int64, installationID int64) (client.AccessToken, error) {
+func (cc *mockClientCache) GetForRepository(app string, owner string, repository string) (*github.Client, error) {
+	return cc.Get(app, 0)
+}
+
+func (cc *mockClientCache) CreateToken(app string, repository string, runID int64, installationID int64, options *github.InstallationTokenOptions) (client.AccessToken, error) {
 	return &mockAccessToken{}, nil
 }
 func (cc *mockClientCache) RevokeToken(repository string, runID int64) error {
 	return nil
 }
+func (cc *mockClientCache) GetBotLogin(app string) (string, error) {
+	return "release-bot[bot]", nil
+}
+
+/*
+mockDispatchClientCache records dispatched workflows and reported commit statuses,
+and fails the dispatch of the failing workflow with failingStatus.
+Installations are recorded as app and repository they are found for.
+Collaborators have the given permission and pull requests have the given head sha,
+their head repository is headRepository if it is set. Reactions and comments are recorded.
+All clients share one test server, it is closed with the test hook handler.
+*/
+type mockDispatchClientCache struct {
+	mockClientCache
+	mu             sync.Mutex
+	server         *httptest.Server
+	closed         bool
+	dispatched     []string
+	installations  []string
+	botTokens      []string
+	approvers      []string
+	statuses       []github.RepoStatus
+	reactions      []string
+	comments       []string
+	permission     string
+	headSHA        string
+	headRepository string
+	failing        string
+	failingStatus  int
+	failures       int
+}
+
+func (cc *mockDispatchClientCache) Get(app string, installationID int64) (*github.Client, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.closed {
+		return nil, errors.New("client cache is closed")
+	}
+	if cc.server == nil {
+		cc.server = httptest.NewServer(http.HandlerFunc(cc.serveHTTP))
+	}
+	client := github.NewClient(cc.server.Client())
+	client.BaseURL, _ = url.Parse(cc.server.URL + "/")
+	return client, nil
+}
+
+func (cc *mockDispatchClientCache) Close() {
+	cc.mu.Lock()
+	server := cc.server
+	cc.server, cc.closed = nil, true
+	cc.mu.Unlock()
+	if server != nil {
+		server.Close()
+	}
+}
+
+func (cc *mockDispatchClientCache) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(r.URL.Path, "/")
+	if r.Method == http.MethodGet && segments[len(segments)-1] == "permission" {
+		json.NewEncoder(w).Encode(github.RepositoryPermissionLevel{Permission: github.String(cc.permission)})
+		return
+	}
+	if r.Method == http.MethodGet && segments[len(segments)-2] == "pulls" {
+		base := &github.Repository{FullName: github.String(segments[2] + "/" + segments[3])}
+		head := base
+		if cc.headRepository != "" {
+			head = &github.Repository{FullName: github.String(cc.headRepository)}
+		}
+		json.NewEncoder(w).Encode(github.PullRequest{
+			Head: &github.PullRequestBranch{SHA: github.String(cc.headSHA), Ref: github.String("feature"), Repo: head},
+			Base: &github.PullRequestBranch{Ref: github.String("main"), Repo: base},
+		})
+		return
+	}
+	if r.Method == http.MethodPost && (segments[len(segments)-1] == "reactions" || segments[len(segments)-1] == "comments") {
+		var body struct {
+			Content string `json:"content"`
+			Body    string `json:"body"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		cc.mu.Lock()
+		if body.Content != "" {
+			cc.reactions = append(cc.reactions, body.Content)
+		} else {
+			cc.comments = append(cc.comments, body.Body)
+		}
+		cc.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+		return
+	}
+	if r.Method == http.MethodPost && segments[len(segments)-2] == "statuses" {
+		var status github.RepoStatus
+		json.NewDecoder(r.Body).Decode(&status)
+		cc.mu.Lock()
+		cc.statuses = append(cc.statuses, status)
+		cc.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+		return
+	}
+	if r.Method != http.MethodPost || segments[len(segments)-1] != "dispatches" {
+		http.Error(w, `{"message": "not found"}`, http.StatusNotFound)
+		return
+	}
+	workflow := segments[len(segments)-2]
+	var request github.CreateWorkflowDispatchEventRequest
+	json.NewDecoder(r.Body).Decode(&request)
+	cc.mu.Lock()
+	cc.dispatched = append(cc.dispatched, workflow)
+	if token, ok := request.Inputs[config.BotTokenInput].(string); ok {
+		cc.botTokens = append(cc.botTokens, token)
+	}
+	if approver, ok := request.Inputs[config.ApprovedByInput].(string); ok {
+		cc.approvers = append(cc.approvers, approver)
+	}
+	fail := workflow == cc.failing && (cc.failures < 0 || len(cc.dispatched) <= cc.failures)
+	cc.mu.Unlock()
+	if fail {
+		status := cc.failingStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, `{"message": "failure"}`, status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cc *mockDispatchClientCache) GetForRepository(app string, owner string, repository string) (*github.Client, error) {
+	cc.mu.Lock()
+	cc.installations = append(cc.installations, app+":"+owner+"/"+repository)
+	cc.mu.Unlock()
+	return cc.Get(app, 0)
+}
+
+func (cc *mockDispatchClientCache) Installations() []string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]string{}, cc.installations...)
+}
+
+func (cc *mockDispatchClientCache) Dispatched() []string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]string{}, cc.dispatched...)
+}
+
+func (cc *mockDispatchClientCache) BotTokens() []string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]string{}, cc.botTokens...)
+}
+
+func (cc *mockDispatchClientCache) Approvers() []string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]string{}, cc.approvers...)
+}
+
+func (cc *mockDispatchClientCache) Reactions() []string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]string{}, cc.reactions...)
+}
+
+func (cc *mockDispatchClientCache) Comments() []string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]string{}, cc.comments...)
+}
+
+func (cc *mockDispatchClientCache) Statuses() []github.RepoStatus {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]github.RepoStatus{}, cc.statuses...)
+}
 
 func init() {
 	metric.RegisterMetrics()
 }
+
+// testHookHandlerOptions holds the dependencies of a test hook handler, the missing stores are kept in memory.
+type testHookHandlerOptions struct {
+	clientManager     client.GithubClientManager
+	eventContextStore store.EventContextStore
+	dispatches        store.DispatchStore
+	deliveryLedger    store.DeliveryLedger
+	deadLetters       store.DeadLetterStore
+}
+
+func newTestHookHandler(t *testing.T, config *config.Config, opts testHookHandlerOptions) *githubHookHandler {
+	t.Helper()
+	if opts.clientManager == nil {
+		opts.clientManager = &mockDispatchClientCache{}
+	}
+	if closer, ok := opts.clientManager.(interface{ Close() }); ok {
+		t.Cleanup(closer.Close)
+	}
+	if opts.eventContextStore == nil {
+		opts.eventContextStore = store.NewEventContextStore()
+	}
+	if opts.dispatches == nil {
+		opts.dispatches = store.NewMemoryDispatchStore()
+	}
+	if opts.deliveryLedger == nil {
+		opts.deliveryLedger, _ = store.NewMemoryDeliveryLedger(10, time.Hour)
+	}
+	if opts.deadLetters == nil {
+		opts.deadLetters = store.NewMemoryDeadLetterStore()
+	}
+	handler, err := newGithubHookHandler(opts.clientManager, config, opts.eventContextStore, opts.dispatches, opts.deliveryLedger, opts.deadLetters, nil)
+	if err != nil {
+		t.Fatalf("Can not create hook handler: %v", err)
+	}
+	return handler
+}
+
+// sendEvent delivers the webhook event stored in file to the handler.
+func sendEvent(handler http.Handler, eventType string, file string, deliveryID string) *http.Response {
+	payload, _ := os.ReadFile(file)
+	req := httptest.NewRequest(http.MethodPost, githubHandlerDefaultRoute, bytes.NewReader(payload))
+	req.Header.Add("X-GitHub-Event", eventType)
+	req.Header.Add("X-GitHub-Delivery", deliveryID)
+	req.Header.Add("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Result()
+}
 func TestGithubHookHandlerFailureCases(t *testing.T) {
-	eventContextStore := store.NewEventContextStore()
 	config := &config.Config{
 		Github: config.GithubConfig{
 			IntegrationID: int64(100),
@@ -72,8 +320,8 @@ func TestGithubHookHandlerFailureCases(t *testing.T) {
 		},
 	}
 
-	cc, _ := client.BuildFromConfig(config)
-	handler, _ := newGithubHookHandler(cc, config, eventContextStore)
+	cc, _ := client.BuildFromConfig(config, nil)
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: cc})
 	t.Run("Missing Event Type", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, githubHandlerDefaultRoute, nil)
 		req.Header.Add("X-GitHub-Delivery", "100")
@@ -103,7 +351,6 @@ func TestGithubHookHandlerFailureCases(t *testing.T) {
 }
 
 func TestGithubHookHandlerRouteWithNoPipelineTrigger(t *testing.T) {
-	eventContextStore := store.NewEventContextStore()
 	config := &config.Config{
 		Github: config.GithubConfig{
 			IntegrationID: int64(100),
@@ -119,23 +366,15 @@ func TestGithubHookHandlerRouteWithNoPipelineTrigger(t *testing.T) {
 		},
 	}
 
-	cc, _ := client.BuildFromConfig(config)
-	handler, _ := newGithubHookHandler(cc, config, eventContextStore)
+	cc, _ := client.BuildFromConfig(config, nil)
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: cc})
 
-	request, _ := os.Open("testdata/workflow_run_event_pr.json")
-	req := httptest.NewRequest(http.MethodPost, githubHandlerDefaultRoute, request)
-	req.Header.Add("X-GitHub-Event", "workflow_run")
-	req.Header.Add("X-GitHub-Delivery", "100")
-	req.Header.Add("Content-Type", "application/json")
-	w := httptest.NewRecorder()
-	handler.ServeHTTP(w, req)
-	res := w.Result()
+	res := sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
 	assert.Equal(t, "200 OK", res.Status)
 	time.Sleep(10 * time.Millisecond)
 }
 
 func TestGithubHookHandlerRouteWithPipelineTrigger(t *testing.T) {
-	eventContextStore := store.NewEventContextStore()
 	config := &config.Config{
 		Github: config.GithubConfig{
 			WebhookSecret: "",
@@ -162,16 +401,586 @@ func TestGithubHookHandlerRouteWithPipelineTrigger(t *testing.T) {
 		},
 	}
 
-	handler, _ := newGithubHookHandler(&mockClientCache{}, config, eventContextStore)
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: &mockClientCache{}})
 
-	request, _ := os.Open("testdata/workflow_run_event_pr.json")
-	req := httptest.NewRequest(http.MethodPost, githubHandlerDefaultRoute, request)
-	req.Header.Add("X-GitHub-Event", "workflow_run")
-	req.Header.Add("X-GitHub-Delivery", "100")
-	req.Header.Add("Content-Type", "application/json")
-	w := httptest.NewRecorder()
-	handler.ServeHTTP(w, req)
-	res := w.Result()
+	res := sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+	assert.Equal(t, "200 OK", res.Status)
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestGithubHookHandlerRouteWithMultiplePipelineTrigger(t *testing.T) {
+	deadLetters := store.NewMemoryDeadLetterStore()
+	condition := config.PipelineCondition{
+		Webhook: []string{"workflow_run"},
+		Type:    "pr",
+	}
+	fanOut := false
+	config := &config.Config{
+		Queue: config.QueueConfig{
+			Limit:   10,
+			Workers: 1,
+			Retry: config.RetryConfig{
+				MaxAttempts: 1,
+			},
+		},
+		Pipelines: []config.PipelineConfig{
+			{Organization: "mattermost", Repository: "test", Workflow: "build.yaml", StopOnMatch: &fanOut, Conditions: []config.PipelineCondition{condition}},
+			{Organization: "mattermost", Repository: "test", Workflow: "scan.yaml", StopOnMatch: &fanOut, Conditions: []config.PipelineCondition{condition}},
+			{Organization: "mattermost", Repository: "test", Workflow: "deploy.yaml", Conditions: []config.PipelineCondition{condition}},
+		},
+	}
+
+	clientManager := &mockDispatchClientCache{failing: "build.yaml", failures: -1}
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: clientManager, deadLetters: deadLetters})
+
+	res := sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+	assert.Equal(t, "200 OK", res.Status)
+	assert.Eventually(t, func() bool {
+		return len(clientManager.Dispatched()) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"build.yaml", "scan.yaml", "deploy.yaml"}, clientManager.Dispatched())
+	assert.Eventually(t, func() bool {
+		letters, _ := deadLetters.List()
+		return len(letters) == 1
+	}, time.Second, 10*time.Millisecond)
+	letters, _ := deadLetters.List()
+	assert.Equal(t, []string{"mattermost/test/build.yaml"}, letters[0].Pipelines)
+	assert.Equal(t, "100", letters[0].DeliveryID)
+	assert.Equal(t, 1, letters[0].Attempts)
+}
+
+func TestGithubHookHandlerDuplicateDelivery(t *testing.T) {
+	config := &config.Config{
+		Queue: config.QueueConfig{
+			Limit:   10,
+			Workers: 1,
+		},
+		Pipelines: []config.PipelineConfig{
+			{
+				Organization: "mattermost",
+				Repository:   "test",
+				Workflow:     "build.yaml",
+				Conditions: []config.PipelineCondition{
+					{
+						Webhook: []string{"workflow_run"},
+						Type:    "pr",
+					},
+				},
+			},
+		},
+	}
+
+	clientManager := &mockDispatchClientCache{}
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: clientManager})
+
+	res := sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
 	assert.Equal(t, "200 OK", res.Status)
+	assert.Empty(t, res.Header.Get(duplicateDeliveryHeader))
+
+	res = sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+	assert.Equal(t, "200 OK", res.Status)
+	assert.Equal(t, "true", res.Header.Get(duplicateDeliveryHeader))
+
+	res = sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "101")
+	assert.Equal(t, "200 OK", res.Status)
+	assert.Empty(t, res.Header.Get(duplicateDeliveryHeader))
+
+	assert.Eventually(t, func() bool {
+		return len(clientManager.Dispatched()) == 2
+	}, time.Second, 10*time.Millisecond)
 	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, clientManager.Dispatched(), 2)
+}
+
+type rejectingScheduler struct{}
+
+func (s rejectingScheduler) Schedule(d dispatch) error {
+	return ErrQueueFull
+}
+
+func (s rejectingScheduler) Replay(processor GithubEventProcessor) error {
+	return nil
+}
+
+func (s rejectingScheduler) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestGithubHookHandlerQueueFull(t *testing.T) {
+	deliveryLedger, _ := store.NewMemoryDeliveryLedger(10, time.Hour)
+	config := &config.Config{
+		Queue: config.QueueConfig{
+			Limit:   10,
+			Workers: 1,
+		},
+	}
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{deliveryLedger: deliveryLedger})
+	handler.Scheduler = rejectingScheduler{}
+
+	res := sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+	assert.Equal(t, "503 Service Unavailable", res.Status)
+	assert.Equal(t, queueFullRetryAfter, res.Header.Get("Retry-After"))
+
+	// The rejected delivery must not be treated as a duplicate when it is redelivered.
+	duplicate, err := deliveryLedger.MarkDelivered("100")
+	assert.Nil(t, err)
+	assert.False(t, duplicate)
+}
+
+type recordingScheduler struct {
+	dispatches []dispatch
+}
+
+func (s *recordingScheduler) Schedule(d dispatch) error {
+	s.dispatches = append(s.dispatches, d)
+	return nil
+}
+
+func (s *recordingScheduler) Replay(processor GithubEventProcessor) error {
+	return nil
+}
+
+func (s *recordingScheduler) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestGithubHookHandlerApps(t *testing.T) {
+	config := &config.Config{
+		Github: config.GithubConfig{
+			IntegrationID: 100,
+			PrivateKey:    "Private Key File",
+			WebhookSecret: "public-secret",
+			WebhookSecrets: []config.WebhookSecretConfig{
+				{Secret: "previous-secret"},
+				{Secret: "expired-secret", ExpiresAt: time.Now().Add(-time.Minute)},
+			},
+			Apps: []config.AppConfig{
+				{Name: "private", IntegrationID: 200, PrivateKey: "Private Key File", WebhookSecret: "private-secret"},
+			},
+		},
+		Queue: config.QueueConfig{
+			Limit:   10,
+			Workers: 1,
+		},
+		Pipelines: []config.PipelineConfig{
+			{
+				Organization: "mattermost",
+				Repository:   "test",
+				Workflow:     "build.yaml",
+				App:          "private",
+				Conditions: []config.PipelineCondition{
+					{Webhook: []string{"workflow_run"}, Type: "pr"},
+				},
+			},
+		},
+	}
+	clientManager := &mockDispatchClientCache{}
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: clientManager})
+	scheduler := &recordingScheduler{}
+	handler.Scheduler = scheduler
+
+	payload, _ := os.ReadFile("testdata/workflow_run_event_pr.json")
+	send := func(path string, deliveryID string, secret string, headers map[string]string) *http.Response {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+		req.Header.Add("X-GitHub-Event", "workflow_run")
+		req.Header.Add("X-GitHub-Delivery", deliveryID)
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		for key, value := range headers {
+			req.Header.Add(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+	integration := func(id string) map[string]string {
+		return map[string]string{hookTargetTypeHeader: "integration", hookTargetIDHeader: id}
+	}
+
+	t.Run("Default App", func(t *testing.T) {
+		assert.Equal(t, "200 OK", send(githubHandlerDefaultRoute, "100", "public-secret", nil).Status)
+		assert.Equal(t, "400 Bad Request", send(githubHandlerDefaultRoute, "101", "private-secret", nil).Status)
+		assert.Equal(t, "default", scheduler.dispatches[len(scheduler.dispatches)-1].App)
+	})
+	t.Run("App By Path", func(t *testing.T) {
+		assert.Equal(t, "200 OK", send(githubHandlerDefaultRoute+"/private", "102", "private-secret", nil).Status)
+		assert.Equal(t, "private", scheduler.dispatches[len(scheduler.dispatches)-1].App)
+		assert.Equal(t, "400 Bad Request", send(githubHandlerDefaultRoute+"/private", "103", "public-secret", nil).Status)
+		assert.Equal(t, "404 Not Found", send(githubHandlerDefaultRoute+"/unknown", "104", "private-secret", nil).Status)
+	})
+	t.Run("App By Installation Target", func(t *testing.T) {
+		assert.Equal(t, "200 OK", send(githubHandlerDefaultRoute, "105", "private-secret", integration("200")).Status)
+		assert.Equal(t, "private", scheduler.dispatches[len(scheduler.dispatches)-1].App)
+		assert.Equal(t, "200 OK", send(githubHandlerDefaultRoute, "106", "public-secret", integration("100")).Status)
+		assert.Equal(t, "default", scheduler.dispatches[len(scheduler.dispatches)-1].App)
+		assert.Equal(t, "404 Not Found", send(githubHandlerDefaultRoute, "107", "private-secret", integration("300")).Status)
+	})
+	t.Run("Previous Secrets", func(t *testing.T) {
+		assert.Equal(t, "200 OK", send(githubHandlerDefaultRoute, "108", "previous-secret", nil).Status)
+		assert.Equal(t, "400 Bad Request", send(githubHandlerDefaultRoute, "109", "expired-secret", nil).Status)
+		// Previous secrets of an app are not accepted for the other apps.
+		assert.Equal(t, "400 Bad Request", send(githubHandlerDefaultRoute+"/private", "110", "previous-secret", nil).Status)
+	})
+	t.Run("Pipeline Of Another App", func(t *testing.T) {
+		// The pipeline is dispatched by the app it names, whichever app received the event.
+		assert.Nil(t, handler.processEvent(scheduler.dispatches[0]))
+		assert.Nil(t, handler.processEvent(scheduler.dispatches[1]))
+		assert.Equal(t, []string{"build.yaml", "build.yaml"}, clientManager.Dispatched())
+		assert.Equal(t, []string{"private:mattermost/test", "private:mattermost/test"}, clientManager.Installations())
+
+		records, _ := handler.Dispatches.List()
+		assert.ElementsMatch(t, []string{"default", "private"}, []string{records[0].App, records[1].App})
+	})
+}
+
+func TestGithubHookHandlerRetry(t *testing.T) {
+	newConfig := func() *config.Config {
+		return &config.Config{
+			Queue: config.QueueConfig{
+				Limit:   10,
+				Workers: 1,
+				Retry: config.RetryConfig{
+					MaxAttempts:    3,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     5 * time.Millisecond,
+				},
+			},
+			Pipelines: []config.PipelineConfig{
+				{
+					Organization: "mattermost",
+					Repository:   "test",
+					Workflow:     "build.yaml",
+					Conditions: []config.PipelineCondition{
+						{
+							Webhook: []string{"workflow_run"},
+							Type:    "pr",
+						},
+					},
+				},
+			},
+		}
+	}
+	t.Run("Transient Failure Is Retried", func(t *testing.T) {
+		deadLetters := store.NewMemoryDeadLetterStore()
+		clientManager := &mockDispatchClientCache{failing: "build.yaml", failures: 2, failingStatus: http.StatusBadGateway}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager, deadLetters: deadLetters})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+		assert.Eventually(t, func() bool {
+			return len(clientManager.Dispatched()) == 3
+		}, time.Second, 5*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		letters, _ := deadLetters.List()
+		assert.Empty(t, letters)
+	})
+	t.Run("Exhausted Retries Are Dead Lettered", func(t *testing.T) {
+		deadLetters := store.NewMemoryDeadLetterStore()
+		clientManager := &mockDispatchClientCache{failing: "build.yaml", failures: -1, failingStatus: http.StatusBadGateway}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager, deadLetters: deadLetters})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+		assert.Eventually(t, func() bool {
+			letters, _ := deadLetters.List()
+			return len(letters) == 1
+		}, time.Second, 5*time.Millisecond)
+		assert.Len(t, clientManager.Dispatched(), 3)
+		letters, _ := deadLetters.List()
+		assert.Equal(t, 3, letters[0].Attempts)
+	})
+	t.Run("Permanent Failure Is Not Retried", func(t *testing.T) {
+		deadLetters := store.NewMemoryDeadLetterStore()
+		clientManager := &mockDispatchClientCache{failing: "build.yaml", failures: -1, failingStatus: http.StatusUnprocessableEntity}
+		handler := newTestHookHandler(t, newConfig(), testHookHandlerOptions{clientManager: clientManager, deadLetters: deadLetters})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+		assert.Eventually(t, func() bool {
+			letters, _ := deadLetters.List()
+			return len(letters) == 1
+		}, time.Second, 5*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		assert.Len(t, clientManager.Dispatched(), 1)
+	})
+	t.Run("Local Failure Is Not Retried", func(t *testing.T) {
+		deadLetters := store.NewMemoryDeadLetterStore()
+		clientManager := &mockDispatchClientCache{}
+		config := newConfig()
+		config.Pipelines[0].Inputs = map[string]string{"version": "{{ .Unknown }}"}
+		handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: clientManager, deadLetters: deadLetters})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+		assert.Eventually(t, func() bool {
+			letters, _ := deadLetters.List()
+			return len(letters) == 1
+		}, time.Second, 5*time.Millisecond)
+		letters, _ := deadLetters.List()
+		assert.Equal(t, 1, letters[0].Attempts)
+		assert.Empty(t, clientManager.Dispatched())
+	})
+}
+
+func TestGithubHookHandlerCommitStatus(t *testing.T) {
+	eventContextStore := store.NewEventContextStore()
+	dispatches := store.NewMemoryDispatchStore()
+	config := &config.Config{
+		Queue: config.QueueConfig{
+			Limit:   10,
+			Workers: 1,
+		},
+		Pipelines: []config.PipelineConfig{
+			{
+				Organization: "mattermost",
+				Repository:   "test",
+				Workflow:     "build.yaml",
+				Status: config.StatusConfig{
+					Context:   "release-bot/build",
+					TargetURL: "https://example.com/{{ .Repository }}/{{ .CommitHash | short }}",
+				},
+				Conditions: []config.PipelineCondition{
+					{
+						Webhook: []string{"workflow_run"},
+						Action:  "requested",
+						Type:    "pr",
+					},
+				},
+			},
+		},
+	}
+	clientManager := &mockDispatchClientCache{}
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: clientManager, eventContextStore: eventContextStore, dispatches: dispatches})
+
+	sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+	assert.Eventually(t, func() bool {
+		return len(clientManager.Statuses()) == 1
+	}, time.Second, 5*time.Millisecond)
+	status := clientManager.Statuses()[0]
+	assert.Equal(t, "pending", status.GetState())
+	assert.Equal(t, "release-bot/build", status.GetContext())
+	assert.Equal(t, "https://example.com/mattermost/release-bot/ab7a32c", status.GetTargetURL())
+
+	// The dispatched run requests its token, which binds the run to the dispatch.
+	tokens := clientManager.BotTokens()
+	assert.Len(t, tokens, 1)
+	tokenHandler := newGithubTokenHandler(clientManager, config.Pipelines, nil, eventContextStore, dispatches)
+	body, _ := json.Marshal(githubTokenRequest{BotToken: tokens[0], Repository: "mattermost/release-bot", RunID: 2926155304})
+	w := httptest.NewRecorder()
+	tokenHandler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tokenGenerationHandlerDefaultRoute, strings.NewReader(string(body))))
+	assert.Equal(t, "200 OK", w.Result().Status)
+	assert.Len(t, clientManager.Statuses(), 2)
+	assert.Equal(t, "Pipeline is running", clientManager.Statuses()[1].GetDescription())
+
+	sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr_completed.json", "101")
+	assert.Eventually(t, func() bool {
+		return len(clientManager.Statuses()) == 3
+	}, time.Second, 5*time.Millisecond)
+	status = clientManager.Statuses()[2]
+	assert.Equal(t, "success", status.GetState())
+	assert.Equal(t, "release-bot/build", status.GetContext())
+	assert.Len(t, clientManager.Dispatched(), 1)
+}
+
+func TestGithubHookHandlerRunTracking(t *testing.T) {
+	dispatches := store.NewMemoryDispatchStore()
+	config := &config.Config{
+		Queue: config.QueueConfig{
+			Limit:   10,
+			Workers: 1,
+		},
+		Pipelines: []config.PipelineConfig{
+			{
+				Organization: "mattermost",
+				Repository:   "test",
+				Workflow:     "build.yaml",
+				Status: config.StatusConfig{
+					Context: "release-bot/build",
+				},
+				Conditions: []config.PipelineCondition{
+					{
+						Webhook: []string{"workflow_run"},
+						Type:    "pr",
+					},
+				},
+			},
+		},
+	}
+	clientManager := &mockDispatchClientCache{}
+	handler := newTestHookHandler(t, config, testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+	state := func() string {
+		records, _ := dispatches.List()
+		if len(records) != 1 {
+			return ""
+		}
+		return records[0].State
+	}
+
+	sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+	assert.Eventually(t, func() bool { return state() == store.DispatchQueued }, time.Second, 5*time.Millisecond)
+	records, _ := dispatches.List()
+	assert.Equal(t, "workflow_run", records[0].Event)
+	assert.Equal(t, "mattermost/release-bot", records[0].Repository)
+	assert.Equal(t, "ab7a32c308ac42df77385bbb5e97f0e3aac5c42f", records[0].CommitHash)
+	assert.Equal(t, "mattermost/test/build.yaml", records[0].Pipeline)
+
+	// A run of the pipeline started by a person is not taken for the dispatched run.
+	sendEvent(handler, "workflow_run", "testdata/workflow_run_event_dispatch_requested_by_user.json", "103")
+	// The private run of the dispatched pipeline is learned from its requested event, as it is created by the bot of the app.
+	sendEvent(handler, "workflow_run", "testdata/workflow_run_event_dispatch_requested.json", "101")
+	assert.Eventually(t, func() bool { return state() == store.DispatchRunning }, time.Second, 5*time.Millisecond)
+	record, err := dispatches.GetByRun("mattermost/test", 3000000001)
+	assert.Nil(t, err)
+	assert.NotNil(t, record.StartedAt)
+	_, err = dispatches.GetByRun("mattermost/test", 3000000002)
+	assert.Error(t, err)
+
+	sendEvent(handler, "workflow_run", "testdata/workflow_run_event_dispatch_completed.json", "102")
+	assert.Eventually(t, func() bool { return state() == store.DispatchCompleted }, time.Second, 5*time.Millisecond)
+	record, _ = dispatches.GetByRun("mattermost/test", 3000000001)
+	assert.Equal(t, "failure", record.Conclusion)
+	assert.NotNil(t, record.CompletedAt)
+	assert.Eventually(t, func() bool {
+		statuses := clientManager.Statuses()
+		return len(statuses) == 2 && statuses[1].GetState() == "failure"
+	}, time.Second, 5*time.Millisecond)
+	assert.Len(t, clientManager.Dispatched(), 1)
+}
+
+func TestGithubHookHandlerForkApproval(t *testing.T) {
+	newConfig := func(ttl time.Duration) *config.Config {
+		return &config.Config{
+			Queue:    config.QueueConfig{Limit: 10, Workers: 1},
+			Approval: config.ApprovalConfig{TTL: ttl},
+			Pipelines: []config.PipelineConfig{
+				{
+					Organization: "mattermost",
+					Repository:   "test",
+					Workflow:     "build.yaml",
+					Inputs:       map[string]string{config.ApprovedByInput: ""},
+					Status:       config.StatusConfig{Context: "release-bot/build"},
+					Conditions: []config.PipelineCondition{
+						{
+							Webhook:      []string{"workflow_run"},
+							Action:       "requested",
+							Type:         "pr",
+							ForkApproval: true,
+						},
+					},
+				},
+			},
+		}
+	}
+	comment, _ := os.ReadFile("testdata/issue_comment_event_approve.json")
+	// pending waits until the fork event is parked and its pending status is reported.
+	pending := func(dispatches store.DispatchStore, clientManager *mockDispatchClientCache) store.DispatchRecord {
+		var record store.DispatchRecord
+		assert.Eventually(t, func() bool {
+			records, _ := dispatches.List()
+			if len(records) == 1 {
+				record = records[0]
+			}
+			return len(records) == 1 && len(clientManager.Statuses()) == 1
+		}, time.Second, 5*time.Millisecond)
+		return record
+	}
+
+	t.Run("Approve By Comment", func(t *testing.T) {
+		dispatches := store.NewMemoryDispatchStore()
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: "ab7a32c308ac42df77385bbb5e97f0e3aac5c42f"}
+		handler := newTestHookHandler(t, newConfig(0), testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr_fork.json", "100")
+		record := pending(dispatches, clientManager)
+		assert.Equal(t, store.DispatchPending, record.State)
+		assert.Equal(t, record.CreatedAt.Add(defaultApprovalTTL), *record.ExpiresAt)
+		assert.Empty(t, clientManager.Dispatched())
+		assert.Equal(t, "Pipeline is waiting for maintainer approval", clientManager.Statuses()[0].GetDescription())
+
+		// The bot token of a parked dispatch can not be exchanged for an access token.
+		tokenHandler := newGithubTokenHandler(clientManager, handler.Pipelines, nil, handler.EventContextStore, dispatches)
+		body, _ := json.Marshal(githubTokenRequest{BotToken: record.Token, Repository: "mattermost/test", RunID: 1})
+		w := httptest.NewRecorder()
+		tokenHandler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tokenGenerationHandlerDefaultRoute, strings.NewReader(string(body))))
+		assert.Equal(t, "400 Bad Request", w.Result().Status)
+
+		sendEvent(handler, model.IssueCommentEvent, "testdata/issue_comment_event_approve.json", "101")
+		assert.Eventually(t, func() bool {
+			return len(clientManager.Dispatched()) == 1
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []string{"maintainer"}, clientManager.Approvers())
+		assert.Equal(t, []string{record.Token}, clientManager.BotTokens())
+		record, _ = dispatches.Get(record.Token)
+		assert.Equal(t, store.DispatchQueued, record.State)
+		assert.Equal(t, "maintainer", record.ApprovedBy)
+
+		_, err := handler.Approve(record.Token, "maintainer")
+		assert.Equal(t, ErrNotPending, err)
+	})
+	t.Run("Comment Without Write Access", func(t *testing.T) {
+		dispatches := store.NewMemoryDispatchStore()
+		clientManager := &mockDispatchClientCache{permission: "read", headSHA: "ab7a32c308ac42df77385bbb5e97f0e3aac5c42f"}
+		handler := newTestHookHandler(t, newConfig(0), testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr_fork.json", "100")
+		record := pending(dispatches, clientManager)
+		assert.Nil(t, handler.processEvent(dispatch{EventType: model.IssueCommentEvent, Payload: comment}))
+		record, _ = dispatches.Get(record.Token)
+		assert.Equal(t, store.DispatchPending, record.State)
+		assert.Empty(t, clientManager.Dispatched())
+	})
+	t.Run("Comment On Another Head", func(t *testing.T) {
+		dispatches := store.NewMemoryDispatchStore()
+		clientManager := &mockDispatchClientCache{permission: "admin", headSHA: "0000000000000000000000000000000000000000"}
+		handler := newTestHookHandler(t, newConfig(0), testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr_fork.json", "100")
+		record := pending(dispatches, clientManager)
+		assert.Nil(t, handler.processEvent(dispatch{EventType: model.IssueCommentEvent, Payload: comment}))
+		record, _ = dispatches.Get(record.Token)
+		assert.Equal(t, store.DispatchPending, record.State)
+	})
+	t.Run("Expired Approval", func(t *testing.T) {
+		dispatches := store.NewMemoryDispatchStore()
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: "ab7a32c308ac42df77385bbb5e97f0e3aac5c42f"}
+		handler := newTestHookHandler(t, newConfig(time.Millisecond), testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr_fork.json", "100")
+		record := pending(dispatches, clientManager)
+		time.Sleep(5 * time.Millisecond)
+		record, err := handler.Approve(record.Token, "admin")
+		assert.Equal(t, ErrApprovalExpired, err)
+		assert.Equal(t, store.DispatchExpired, record.State)
+		_, err = handler.EventContextStore.Get(record.Token)
+		assert.Error(t, err)
+		assert.Empty(t, clientManager.Dispatched())
+		assert.Equal(t, "error", clientManager.Statuses()[1].GetState())
+	})
+	t.Run("Expired Approval Is Swept", func(t *testing.T) {
+		dispatches := store.NewMemoryDispatchStore()
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: "ab7a32c308ac42df77385bbb5e97f0e3aac5c42f"}
+		handler := newTestHookHandler(t, newConfig(time.Millisecond), testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr_fork.json", "100")
+		record := pending(dispatches, clientManager)
+
+		done := make(chan struct{})
+		defer close(done)
+		go handler.expireApprovalsPeriodically(time.Millisecond, done)
+		assert.Eventually(t, func() bool {
+			record, _ = dispatches.Get(record.Token)
+			return record.State == store.DispatchExpired
+		}, time.Second, 5*time.Millisecond)
+		assert.Eventually(t, func() bool {
+			statuses := clientManager.Statuses()
+			return len(statuses) == 2 && statuses[1].GetState() == "error"
+		}, time.Second, 5*time.Millisecond)
+		_, err := handler.EventContextStore.Get(record.Token)
+		assert.Error(t, err)
+
+		expired, err := handler.ExpireApprovals()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, expired)
+	})
+	t.Run("Pending Approval Is Not Swept", func(t *testing.T) {
+		dispatches := store.NewMemoryDispatchStore()
+		clientManager := &mockDispatchClientCache{permission: "write", headSHA: "ab7a32c308ac42df77385bbb5e97f0e3aac5c42f"}
+		handler := newTestHookHandler(t, newConfig(0), testHookHandlerOptions{clientManager: clientManager, dispatches: dispatches})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr_fork.json", "100")
+		record := pending(dispatches, clientManager)
+		expired, err := handler.ExpireApprovals()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, expired)
+		record, _ = dispatches.Get(record.Token)
+		assert.Equal(t, store.DispatchPending, record.State)
+	})
 }
@@ -2,23 +2,35 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/google/go-github/v45/github"
 	"github.com/mattermost/release-bot/client"
+	"github.com/mattermost/release-bot/config"
 	"github.com/mattermost/release-bot/metric"
+	"github.com/mattermost/release-bot/oidc"
 	"github.com/mattermost/release-bot/store"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 type githubTokenHandler struct {
 	ClientManager     client.GithubClientManager
+	Pipelines         []config.PipelineConfig
+	Verifier          oidc.Verifier
 	EventContextStore store.EventContextStore
+	Dispatches        store.DispatchStore
 }
 
 type githubTokenRequest struct {
 	BotToken   string `json:"bot_token"`
 	Repository string `json:"repository"`
 	RunID      int64  `json:"run_id"`
+	// IDToken is the GitHub Actions ID token of the run, required if OIDC verification is enabled.
+	IDToken string `json:"id_token"`
 }
 
 func (gtr *githubTokenRequest) Log() {
@@ -75,10 +87,50 @@ func (gh *githubTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		metric.IncreaseCounter(metric.TotalFailureCount)
 		return
 	}
+	record, recordErr := gh.Dispatches.Get(request.BotToken)
+	// Bot tokens of dispatches waiting for approval are not handed out, but they must not be usable either.
+	if recordErr == nil && (record.State == store.DispatchPending || record.State == store.DispatchExpired) {
+		http.Error(w, "Invalid Bot Token", http.StatusBadRequest)
+		metric.IncreaseCounter(metric.TotalFailureCount)
+		return
+	}
+	if recordErr != nil {
+		record = store.DispatchRecord{}
+	}
+	if gh.Verifier != nil {
+		if err := gh.verifyRun(request, record); err != nil {
+			log.WithError(err).WithField("pipeline", record.Pipeline).Warn("Workflow run can not be verified!")
+			http.Error(w, "Invalid ID Token", http.StatusUnauthorized)
+			metric.IncreaseCounter(metric.TotalFailureCount)
+			return
+		}
+	}
+	options, err := gh.tokenOptions(record)
+	if err != nil {
+		log.WithError(err).WithField("pipeline", record.Pipeline).Error("Invalid token scope!")
+		http.Error(w, "Invalid Token Scope", http.StatusForbidden)
+		metric.IncreaseCounter(metric.TotalFailureCount)
+		return
+	}
+	// The first run redeeming the bot token is the only one which can redeem it, until it is completed.
+	if err := gh.EventContextStore.Bind(request.BotToken, request.Repository, request.RunID); err != nil {
+		if err == store.ErrRunMismatch || err == store.ErrBindingExpired {
+			log.WithError(err).WithField("pipeline", record.Pipeline).Warn("Bot token can not be redeemed by the workflow run!")
+			http.Error(w, "Bot Token Is Bound To Another Run", http.StatusForbidden)
+			metric.IncreaseCounter(metric.TotalFailureCount)
+			return
+		}
+		log.WithError(err).Error("Can not bind bot token to workflow run!")
+		http.Error(w, "Invalid Bot Token", http.StatusInternalServerError)
+		metric.IncreaseCounter(metric.TotalFailureCount)
+		return
+	}
 	accessToken, err := gh.ClientManager.CreateToken(
+		record.App,
 		request.Repository,
 		request.RunID,
 		context.GetInstallationID(),
+		options,
 	)
 	if err != nil {
 		log.WithError(err).Error("Unable to create GitHub Access Token!")
@@ -86,15 +138,82 @@ func (gh *githubTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		metric.IncreaseCounter(metric.TotalFailureCount)
 		return
 	}
+	// The token is requested by the dispatched run, which is how its run id becomes known.
+	if record, err := gh.Dispatches.BindRun(request.BotToken, request.Repository, request.RunID); err != nil {
+		log.WithError(err).Warn("Can not bind dispatch to workflow run")
+	} else {
+		reportCommitStatus(gh.ClientManager, record, commitStatusPending, "Pipeline is running")
+	}
 	response, _ := json.MarshalIndent(githubTokenResponse{Token: accessToken.GetToken()}, "", "  ")
 	w.Header().Add("Content-Type", "application/json;charset=utf-8")
 	w.Write(response)
 	metric.IncreaseCounter(metric.TotalSuccessCount)
 }
 
-func newGithubTokenHandler(clientManager client.GithubClientManager, eventContextStore store.EventContextStore) http.Handler {
+// verifyRun checks the ID token is issued to the requesting run, which runs the workflow of the pipeline dispatched for the bot token.
+func (gh *githubTokenHandler) verifyRun(request githubTokenRequest, record store.DispatchRecord) error {
+	if request.IDToken == "" {
+		return errors.New("id token is not provided")
+	}
+	claims, err := gh.Verifier.Verify(request.IDToken)
+	if err != nil {
+		return err
+	}
+	if claims.Repository != request.Repository || claims.RunID != strconv.FormatInt(request.RunID, 10) {
+		return fmt.Errorf("id token is issued for run %s of %s", claims.RunID, claims.Repository)
+	}
+	if record.Token == "" {
+		return errors.New("dispatch of bot token is not found")
+	}
+	pipeline, found := findPipeline(gh.Pipelines, record.Pipeline)
+	if !found {
+		return fmt.Errorf("pipeline %s is not configured", record.Pipeline)
+	}
+	for _, workflowRef := range workflowRefs(pipeline) {
+		if claims.WorkflowRef == workflowRef {
+			return nil
+		}
+	}
+	return fmt.Errorf("id token is issued for workflow %s, not for pipeline %s at %s", claims.WorkflowRef, pipeline.Key(), pipeline.GetRef())
+}
+
+/*
+workflowRefs returns the workflow refs, like "mattermost/delivery/.github/workflows/build.yml@refs/heads/main", a run
+dispatched for the pipeline can have. The ref of the pipeline can name a branch or a tag, unless it is fully qualified.
+*/
+func workflowRefs(pipeline config.PipelineConfig) []string {
+	workflowPath := fmt.Sprintf("%s/%s/.github/workflows/%s", pipeline.Organization, pipeline.Repository, pipeline.Workflow)
+	ref := pipeline.GetRef()
+	if strings.HasPrefix(ref, "refs/") {
+		return []string{workflowPath + "@" + ref}
+	}
+	return []string{workflowPath + "@refs/heads/" + ref, workflowPath + "@refs/tags/" + ref}
+}
+
+/*
+Narrow the token to the scope configured for the pipeline of the dispatch.
+Tokens are not created for unknown dispatches or pipelines, their scope is not known.
+*/
+func (gh *githubTokenHandler) tokenOptions(record store.DispatchRecord) (*github.InstallationTokenOptions, error) {
+	if record.Token == "" {
+		return nil, errors.New("dispatch of bot token is not found")
+	}
+	pipeline, found := findPipeline(gh.Pipelines, record.Pipeline)
+	if !found {
+		return nil, fmt.Errorf("pipeline %s is not configured", record.Pipeline)
+	}
+	if !pipeline.Token.IsScoped() {
+		return nil, nil
+	}
+	return pipeline.Token.InstallationTokenOptions()
+}
+
+func newGithubTokenHandler(clientManager client.GithubClientManager, pipelines []config.PipelineConfig, verifier oidc.Verifier, eventContextStore store.EventContextStore, dispatches store.DispatchStore) http.Handler {
 	return &githubTokenHandler{
 		ClientManager:     clientManager,
+		Pipelines:         pipelines,
+		Verifier:          verifier,
 		EventContextStore: eventContextStore,
+		Dispatches:        dispatches,
 	}
 }
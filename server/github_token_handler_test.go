@@ -2,13 +2,20 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-github/v45/github"
+	"github.com/mattermost/release-bot/client"
+	"github.com/mattermost/release-bot/config"
 	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/oidc"
 	"github.com/mattermost/release-bot/store"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,7 +29,7 @@ func TestGithubTokenHandlerInvalidRequests(t *testing.T) {
 
 	eventContextStore := store.NewEventContextStore()
 	eventContextStore.Store(createWorkflowRunEvent(t), "12345")
-	handler := newGithubTokenHandler(&mockClientCache{}, eventContextStore)
+	handler := newGithubTokenHandler(&mockClientCache{}, nil, nil, eventContextStore, store.NewMemoryDispatchStore())
 	tests := []test{
 		{testFile: "", httpMethod: http.MethodGet, want: "405 Method Not Allowed"},
 		{testFile: "github_token_request_missing_token.json", httpMethod: http.MethodPost, want: "400 Bad Request"},
@@ -47,8 +54,11 @@ func TestGithubTokenHandlerInvalidRequests(t *testing.T) {
 func TestGithubTokenHandler(t *testing.T) {
 	eventContextStore := store.NewEventContextStore()
 	eventContextStore.Store(createWorkflowRunEvent(t), "bot_token")
+	dispatches := store.NewMemoryDispatchStore()
+	dispatches.Save(store.DispatchRecord{Token: "bot_token", Pipeline: "mattermost/delivery/build.yml", State: store.DispatchQueued})
+	pipelines := []config.PipelineConfig{{Organization: "mattermost", Repository: "delivery", Workflow: "build.yml"}}
 
-	handler := newGithubTokenHandler(&mockClientCache{}, eventContextStore)
+	handler := newGithubTokenHandler(&mockClientCache{}, pipelines, nil, eventContextStore, dispatches)
 	request, _ := os.Open("testdata/github_token_request.json")
 	req := httptest.NewRequest(http.MethodPost, tokenGenerationHandlerDefaultRoute, request)
 	w := httptest.NewRecorder()
@@ -65,6 +75,139 @@ func TestGithubTokenHandler(t *testing.T) {
 	assert.Equal(t, "gh-12345678", gtr.Token)
 }
 
+type mockScopedClientCache struct {
+	mockClientCache
+	options []*github.InstallationTokenOptions
+}
+
+func (cc *mockScopedClientCache) CreateToken(app string, repository string, runID int64, installationID int64, options *github.InstallationTokenOptions) (client.AccessToken, error) {
+	cc.options = append(cc.options, options)
+	return &mockAccessToken{}, nil
+}
+
+func TestGithubTokenHandlerScopedToken(t *testing.T) {
+	eventContextStore := store.NewEventContextStore()
+	dispatches := store.NewMemoryDispatchStore()
+	pipelines := []config.PipelineConfig{
+		{
+			Organization: "mattermost",
+			Repository:   "delivery",
+			Workflow:     "build.yml",
+			Token: config.TokenConfig{
+				Repositories: []string{"release-bot"},
+				Permissions:  map[string]string{"contents": "read", "statuses": "write"},
+			},
+		},
+		{Organization: "mattermost", Repository: "delivery", Workflow: "lint.yml"},
+	}
+	clientManager := &mockScopedClientCache{}
+	handler := newGithubTokenHandler(clientManager, pipelines, nil, eventContextStore, dispatches)
+	request := func(botToken string) string {
+		body, _ := json.Marshal(githubTokenRequest{BotToken: botToken, Repository: "mattermost/delivery", RunID: 1})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tokenGenerationHandlerDefaultRoute, strings.NewReader(string(body))))
+		return w.Result().Status
+	}
+	for token, pipeline := range map[string]string{"scoped": "mattermost/delivery/build.yml", "unscoped": "mattermost/delivery/lint.yml", "removed": "mattermost/delivery/e2e.yml"} {
+		eventContextStore.Store(createWorkflowRunEvent(t), token)
+		dispatches.Save(store.DispatchRecord{Token: token, Pipeline: pipeline, State: store.DispatchQueued})
+	}
+
+	assert.Equal(t, "200 OK", request("scoped"))
+	assert.Len(t, clientManager.options, 1)
+	assert.Equal(t, []string{"release-bot"}, clientManager.options[0].Repositories)
+	assert.Equal(t, "read", clientManager.options[0].Permissions.GetContents())
+	assert.Equal(t, "write", clientManager.options[0].Permissions.GetStatuses())
+	assert.Nil(t, clientManager.options[0].Permissions.Issues)
+
+	assert.Equal(t, "200 OK", request("unscoped"))
+	assert.Nil(t, clientManager.options[1])
+
+	// Tokens of unknown dispatches or pipelines are not created with the permissions of the whole installation.
+	eventContextStore.Store(createWorkflowRunEvent(t), "unknown")
+	assert.Equal(t, "403 Forbidden", request("unknown"))
+	assert.Equal(t, "403 Forbidden", request("removed"))
+	assert.Len(t, clientManager.options, 2)
+}
+
+func TestGithubTokenHandlerRunBinding(t *testing.T) {
+	eventContextStore := store.NewEventContextStore()
+	eventContextStore.Store(createWorkflowRunEvent(t), "bot_token")
+	dispatches := store.NewMemoryDispatchStore()
+	dispatches.Save(store.DispatchRecord{Token: "bot_token", Pipeline: "mattermost/delivery/build.yml", State: store.DispatchQueued})
+	pipelines := []config.PipelineConfig{{Organization: "mattermost", Repository: "delivery", Workflow: "build.yml"}}
+	handler := newGithubTokenHandler(&mockClientCache{}, pipelines, nil, eventContextStore, dispatches)
+	request := func(runID int64) string {
+		body, _ := json.Marshal(githubTokenRequest{BotToken: "bot_token", Repository: "mattermost/test", RunID: runID})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tokenGenerationHandlerDefaultRoute, strings.NewReader(string(body))))
+		return w.Result().Status
+	}
+
+	assert.Equal(t, "200 OK", request(3000000001))
+	assert.Equal(t, "200 OK", request(3000000001))
+	assert.Equal(t, "403 Forbidden", request(3000000002))
+
+	// The completed event of the bound run expires the binding.
+	deliveryLedger, _ := store.NewMemoryDeliveryLedger(10, time.Hour)
+	hookHandler, _ := newGithubHookHandler(&mockClientCache{}, &config.Config{Queue: config.QueueConfig{Limit: 10, Workers: 1}}, eventContextStore, store.NewMemoryDispatchStore(), deliveryLedger, store.NewMemoryDeadLetterStore(), nil)
+	completed, _ := os.ReadFile("testdata/workflow_run_event_dispatch_completed.json")
+	assert.Nil(t, hookHandler.processEvent(dispatch{EventType: "workflow_run", DeliveryID: "100", Payload: completed}))
+	assert.Equal(t, "403 Forbidden", request(3000000001))
+}
+
+// mockVerifier accepts the ID tokens it knows the claims of.
+type mockVerifier map[string]oidc.Claims
+
+func (mv mockVerifier) Verify(rawToken string) (*oidc.Claims, error) {
+	claims, found := mv[rawToken]
+	if !found {
+		return nil, errors.New("invalid id token")
+	}
+	return &claims, nil
+}
+
+func TestGithubTokenHandlerVerifiedRun(t *testing.T) {
+	eventContextStore := store.NewEventContextStore()
+	dispatches := store.NewMemoryDispatchStore()
+	pipelines := []config.PipelineConfig{{Organization: "mattermost", Repository: "delivery", Workflow: "build.yml"}}
+	eventContextStore.Store(createWorkflowRunEvent(t), "bot_token")
+	eventContextStore.Store(createWorkflowRunEvent(t), "unknown_dispatch")
+	dispatches.Save(store.DispatchRecord{Token: "bot_token", Pipeline: "mattermost/delivery/build.yml", State: store.DispatchQueued})
+	verifier := mockVerifier{
+		"dispatched": {Repository: "mattermost/delivery", RunID: "1", WorkflowRef: "mattermost/delivery/.github/workflows/build.yml@refs/heads/main"},
+		"other-run":  {Repository: "mattermost/delivery", RunID: "2", WorkflowRef: "mattermost/delivery/.github/workflows/build.yml@refs/heads/main"},
+		"other-flow": {Repository: "mattermost/delivery", RunID: "1", WorkflowRef: "mattermost/delivery/.github/workflows/lint.yml@refs/heads/main"},
+		"other-ref":  {Repository: "mattermost/delivery", RunID: "1", WorkflowRef: "mattermost/delivery/.github/workflows/build.yml@refs/heads/feature"},
+	}
+	handler := newGithubTokenHandler(&mockClientCache{}, pipelines, verifier, eventContextStore, dispatches)
+	request := func(botToken string, idToken string) string {
+		body, _ := json.Marshal(githubTokenRequest{BotToken: botToken, Repository: "mattermost/delivery", RunID: 1, IDToken: idToken})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tokenGenerationHandlerDefaultRoute, strings.NewReader(string(body))))
+		return w.Result().Status
+	}
+
+	assert.Equal(t, "401 Unauthorized", request("bot_token", ""))
+	assert.Equal(t, "401 Unauthorized", request("bot_token", "forged"))
+	assert.Equal(t, "401 Unauthorized", request("bot_token", "other-run"))
+	assert.Equal(t, "401 Unauthorized", request("bot_token", "other-flow"))
+	// The workflow of the pipeline is only trusted at the ref it is dispatched on.
+	assert.Equal(t, "401 Unauthorized", request("bot_token", "other-ref"))
+	assert.Equal(t, "401 Unauthorized", request("unknown_dispatch", "dispatched"))
+	assert.Equal(t, "200 OK", request("bot_token", "dispatched"))
+}
+
+func TestWorkflowRefs(t *testing.T) {
+	pipeline := config.PipelineConfig{Organization: "mattermost", Repository: "delivery", Workflow: "build.yml"}
+	assert.Equal(t, []string{
+		"mattermost/delivery/.github/workflows/build.yml@refs/heads/main",
+		"mattermost/delivery/.github/workflows/build.yml@refs/tags/main",
+	}, workflowRefs(pipeline))
+	pipeline.Ref = "refs/tags/v1.0.0"
+	assert.Equal(t, []string{"mattermost/delivery/.github/workflows/build.yml@refs/tags/v1.0.0"}, workflowRefs(pipeline))
+}
+
 func createWorkflowRunEvent(t *testing.T) model.EventContext {
 	source, err := os.ReadFile("testdata/workflow_run_event_pr.json")
 	if err != nil {
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"time"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/notifier"
+	"github.com/mattermost/release-bot/store"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultNotificationTimeout = 10 * time.Second
+	// Notifications are posted by a few workers, the ones which do not fit into the queue are dropped.
+	notificationQueueSize = 100
+	notificationWorkers   = 2
+)
+
+/*
+Post the outcome of the dispatched pipeline to the notifications configured for it.
+The event context is nil if the event is not known anymore, the record is empty if the dispatch could not be recorded.
+Like commit statuses, notifications are informational and failures are only logged.
+Notifications are queued and posted in the background, so dispatches are not held up by slow webhooks.
+*/
+func (gh *githubHookHandler) notify(pipeline config.PipelineConfig, eventContext model.EventContext, record store.DispatchRecord, outcome string, cause error) {
+	if len(pipeline.Notifications) == 0 {
+		return
+	}
+	data := model.NewNotificationTemplateData(eventContext, pipeline, outcome)
+	if eventContext == nil {
+		data.Repository = record.Repository
+		data.CommitHash = record.CommitHash
+	}
+	data.Conclusion = record.Conclusion
+	data.RunRepository = record.RunRepository
+	data.RunID = record.RunID
+	if cause != nil {
+		data.Error = cause.Error()
+	}
+	logger := log.WithFields(log.Fields{
+		"pipeline": pipeline.Key(),
+		"outcome":  outcome,
+	})
+	for _, notification := range pipeline.Notifications {
+		if !notification.Notifies(outcome) {
+			continue
+		}
+		text, err := model.RenderNotificationMessage(notification, data)
+		if err != nil {
+			logger.WithError(err).Error("Can not render notification message!")
+			continue
+		}
+		message := notifier.Message{
+			Channel:  notification.Channel,
+			Username: notification.Username,
+			Text:     text,
+		}
+		if err := gh.Notifier.Notify(notification.WebhookURL, message); err != nil {
+			logger.WithError(err).Error("Can not queue notification!")
+			continue
+		}
+		logger.WithField("channel", notification.Channel).Debug("Notification is queued")
+	}
+}
+
+// notifyDispatch notifies whether the pipeline is triggered or not.
+func (gh *githubHookHandler) notifyDispatch(pipeline config.PipelineConfig, eventContext model.EventContext, record store.DispatchRecord, err error) {
+	if err != nil {
+		gh.notify(pipeline, eventContext, record, config.OutcomeTriggerFailed, err)
+		return
+	}
+	gh.notify(pipeline, eventContext, record, config.OutcomeTriggered, nil)
+}
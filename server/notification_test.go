@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/notifier"
+	"github.com/mattermost/release-bot/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockWebhook stands in for a Mattermost incoming webhook and records the posted messages.
+type mockWebhook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	messages []notifier.Message
+}
+
+func newMockWebhook() *mockWebhook {
+	webhook := &mockWebhook{}
+	webhook.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message notifier.Message
+		json.NewDecoder(r.Body).Decode(&message)
+		webhook.mu.Lock()
+		webhook.messages = append(webhook.messages, message)
+		webhook.mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	return webhook
+}
+
+func (m *mockWebhook) Messages() []notifier.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]notifier.Message(nil), m.messages...)
+}
+
+func TestGithubHookHandlerNotifications(t *testing.T) {
+	newConfig := func(webhook *mockWebhook) *config.Config {
+		return &config.Config{
+			Queue: config.QueueConfig{
+				Limit:   10,
+				Workers: 1,
+				Retry: config.RetryConfig{
+					MaxAttempts:    3,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     5 * time.Millisecond,
+				},
+			},
+			Pipelines: []config.PipelineConfig{
+				{
+					Organization: "mattermost",
+					Repository:   "test",
+					Workflow:     "build.yaml",
+					Notifications: []config.NotificationConfig{
+						{
+							WebhookURL: webhook.URL + "/hooks/all",
+							Channel:    "release",
+						},
+						{
+							WebhookURL: webhook.URL + "/hooks/failures",
+							Channel:    "alerts",
+							Username:   "release-bot",
+							Message:    "{{ .Pipeline }} {{ .Conclusion }} on {{ .RunRepository }}#{{ .RunID }} for PR {{ .PullRequestNumber }}",
+							On:         []string{config.OutcomeRunFailed},
+						},
+					},
+					Conditions: []config.PipelineCondition{
+						{
+							Webhook: []string{"workflow_run"},
+							Type:    "pr",
+						},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("Triggered And Run Failed", func(t *testing.T) {
+		webhook := newMockWebhook()
+		defer webhook.Close()
+		clientManager := &mockDispatchClientCache{}
+		handler := newTestHookHandler(t, newConfig(webhook), testHookHandlerOptions{clientManager: clientManager})
+
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+		assert.Eventually(t, func() bool { return len(webhook.Messages()) == 1 }, time.Second, 5*time.Millisecond)
+		message := webhook.Messages()[0]
+		assert.Equal(t, "release", message.Channel)
+		assert.Empty(t, message.Username)
+		assert.Equal(t, "Pipeline `mattermost/test/build.yaml` is triggered for mattermost/release-bot@ab7a32c", message.Text)
+
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_dispatch_requested.json", "101")
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_dispatch_completed.json", "102")
+		assert.Eventually(t, func() bool { return len(webhook.Messages()) == 3 }, time.Second, 5*time.Millisecond)
+		// Notifications are posted in the background, they can arrive in any order.
+		messages := webhook.Messages()[1:]
+		if messages[0].Channel == "alerts" {
+			messages[0], messages[1] = messages[1], messages[0]
+		}
+		assert.Equal(t, "Pipeline `mattermost/test/build.yaml` completed with failure for mattermost/release-bot@ab7a32c", messages[0].Text)
+		assert.Equal(t, "alerts", messages[1].Channel)
+		assert.Equal(t, "release-bot", messages[1].Username)
+		assert.Equal(t, "mattermost/test/build.yaml failure on mattermost/test#3000000001 for PR 1", messages[1].Text)
+	})
+	t.Run("Trigger Failure Is Notified Once", func(t *testing.T) {
+		webhook := newMockWebhook()
+		defer webhook.Close()
+		deadLetters := store.NewMemoryDeadLetterStore()
+		clientManager := &mockDispatchClientCache{failing: "build.yaml", failures: -1, failingStatus: http.StatusBadGateway}
+		handler := newTestHookHandler(t, newConfig(webhook), testHookHandlerOptions{clientManager: clientManager, deadLetters: deadLetters})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+
+		assert.Eventually(t, func() bool {
+			letters, _ := deadLetters.List()
+			return len(letters) == 1
+		}, time.Second, 5*time.Millisecond)
+		assert.Len(t, clientManager.Dispatched(), 3)
+		assert.Eventually(t, func() bool { return len(webhook.Messages()) == 1 }, time.Second, 5*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		messages := webhook.Messages()
+		assert.Len(t, messages, 1)
+		assert.Contains(t, messages[0].Text, "Pipeline `mattermost/test/build.yaml` could not be triggered for mattermost/release-bot@ab7a32c: ")
+	})
+	t.Run("Unreachable Webhook", func(t *testing.T) {
+		webhook := newMockWebhook()
+		webhook.Close()
+		clientManager := &mockDispatchClientCache{}
+		handler := newTestHookHandler(t, newConfig(webhook), testHookHandlerOptions{clientManager: clientManager})
+		sendEvent(handler, "workflow_run", "testdata/workflow_run_event_pr.json", "100")
+		// Notifications are informational, the pipeline is dispatched once without being retried.
+		assert.Eventually(t, func() bool { return len(clientManager.Dispatched()) == 1 }, time.Second, 5*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		assert.Len(t, clientManager.Dispatched(), 1)
+	})
+}
+
+func TestIsFailedConclusion(t *testing.T) {
+	for _, conclusion := range []string{"failure", "timed_out", "startup_failure", "cancelled", "action_required"} {
+		assert.True(t, isFailedConclusion(conclusion), conclusion)
+	}
+	for _, conclusion := range []string{"success", "neutral", "skipped", "stale", ""} {
+		assert.False(t, isFailedConclusion(conclusion), conclusion)
+	}
+}
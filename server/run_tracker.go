@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/model"
+	"github.com/mattermost/release-bot/store"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Follow the private workflow run of a dispatched pipeline through its workflow_run events.
+Runs are bound to their dispatch when they request a bot token. A requested workflow_dispatch run
+is bound earlier, if it is created by the bot of the dispatching app and it is the only queued dispatch of its pipeline,
+otherwise it is bound once it requests a token. Runs dispatched by people are never bound from their events.
+*/
+func (gh *githubHookHandler) trackRun(eventContext model.EventContext) {
+	runContext, ok := eventContext.(*model.WorkflowRunEventContext)
+	if !ok {
+		return
+	}
+	repository, runID := runContext.GetRepository(), runContext.GetWorkflowRunID()
+	logger := log.WithFields(log.Fields{
+		"repository": repository,
+		"run_id":     runID,
+		"action":     runContext.GetAction(),
+	})
+	record, err := gh.Dispatches.GetByRun(repository, runID)
+	if err != nil {
+		if runContext.GetAction() != "requested" || runContext.GetTriggerEvent() != "workflow_dispatch" {
+			return
+		}
+		candidate, found := gh.findQueuedDispatch(fmt.Sprintf("%s/%s", repository, runContext.GetWorkflowFile()))
+		if !found || !gh.isDispatchedByApp(runContext, candidate.App) {
+			return
+		}
+		token := candidate.Token
+		if record, err = gh.Dispatches.BindRun(token, repository, runID); err != nil {
+			logger.WithError(err).Warn("Can not bind dispatch to workflow run")
+			return
+		}
+		logger.WithField("pipeline", record.Pipeline).Info("Dispatch is bound to workflow run")
+	}
+
+	if runContext.GetAction() != "completed" {
+		return
+	}
+	conclusion := runContext.GetConclusion()
+	record, err = gh.Dispatches.Update(record.Token, func(record *store.DispatchRecord) {
+		now := time.Now()
+		record.State = store.DispatchCompleted
+		record.Conclusion = conclusion
+		record.CompletedAt = &now
+	})
+	if err != nil {
+		logger.WithError(err).Error("Can not complete dispatch record")
+		return
+	}
+	logger.WithFields(log.Fields{
+		"pipeline":   record.Pipeline,
+		"conclusion": conclusion,
+	}).Info("Dispatched workflow run is completed")
+	reportCommitStatus(gh.ClientManager, record, commitStatusState(conclusion), completedStatusDescription(conclusion))
+	if isFailedConclusion(conclusion) {
+		gh.notifyRunFailure(record)
+	}
+}
+
+/*
+isFailedConclusion reports whether the run failed, skipped and neutral runs did not fail.
+Commit statuses, notifications and retries share it, so they agree on which runs failed.
+*/
+func isFailedConclusion(conclusion string) bool {
+	switch conclusion {
+	case "failure", "timed_out", "startup_failure", "cancelled", "action_required":
+		return true
+	default:
+		return false
+	}
+}
+
+// notifyRunFailure notifies the failure of the run with the event the pipeline is dispatched for, if it is still known.
+func (gh *githubHookHandler) notifyRunFailure(record store.DispatchRecord) {
+	pipeline, found := findPipeline(gh.Pipelines, record.Pipeline)
+	if !found {
+		return
+	}
+	eventContext, err := gh.EventContextStore.Get(record.Token)
+	if err != nil {
+		eventContext = nil
+	}
+	gh.notify(pipeline, eventContext, record, config.OutcomeRunFailed, nil)
+}
+
+// isDispatchedByApp reports whether the run is created and triggered by the bot of the app, as the runs it dispatches are.
+func (gh *githubHookHandler) isDispatchedByApp(runContext *model.WorkflowRunEventContext, app string) bool {
+	login, err := gh.ClientManager.GetBotLogin(app)
+	if err != nil {
+		log.WithError(err).WithField("app", app).Warn("Can not get bot login of app, waiting for the token request of the run")
+		return false
+	}
+	if runContext.GetActor() != login || runContext.GetSender() != login {
+		log.WithFields(log.Fields{
+			"repository": runContext.GetRepository(),
+			"run_id":     runContext.GetWorkflowRunID(),
+			"actor":      runContext.GetActor(),
+			"sender":     runContext.GetSender(),
+		}).Info("Workflow run is not dispatched by the app, waiting for its token request")
+		return false
+	}
+	return true
+}
+
+// findQueuedDispatch returns the only queued dispatch of the pipeline which is not bound to a run yet.
+func (gh *githubHookHandler) findQueuedDispatch(pipeline string) (store.DispatchRecord, bool) {
+	records, err := gh.Dispatches.List()
+	if err != nil {
+		log.WithError(err).Error("Can not list dispatch records")
+		return store.DispatchRecord{}, false
+	}
+	var candidates []store.DispatchRecord
+	for _, record := range records {
+		if record.Pipeline == pipeline && record.State == store.DispatchQueued && !record.IsBound() {
+			candidates = append(candidates, record)
+		}
+	}
+	if len(candidates) != 1 {
+		if len(candidates) > 1 {
+			log.WithFields(log.Fields{
+				"pipeline":   pipeline,
+				"candidates": len(candidates),
+			}).Info("Workflow run matches several dispatches, waiting for its token request")
+		}
+		return store.DispatchRecord{}, false
+	}
+	return candidates[0], true
+}
@@ -1,11 +1,26 @@
 package server
 
 import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mattermost/release-bot/config"
 	"github.com/mattermost/release-bot/metric"
+	"github.com/mattermost/release-bot/store"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRetryMaxAttempts    = 5
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = time.Minute
 )
 
-type GithubEventProcessor func(eventType string, deliveryID string, payload []byte)
+type GithubEventProcessor func(d dispatch) error
 
 type dispatch struct {
 	Processor GithubEventProcessor
@@ -13,17 +28,106 @@ type dispatch struct {
 	EventType  string
 	DeliveryID string
 	Payload    []byte
+	// App is the name of the GitHub App which received the event.
+	App string
+	// Pipelines limits processing to the given pipeline keys. All matching pipelines are processed when empty.
+	Pipelines []string
+	Attempt   int
+	// JournalID identifies the journal entry of the dispatch, it is zero if the dispatch is not journaled.
+	JournalID uint64
 }
 
+// dispatchError reports which pipelines of a dispatch failed and whether they are worth retrying.
+type dispatchError struct {
+	Transient []string
+	Permanent []string
+	Err       error
+}
+
+func (e *dispatchError) Error() string {
+	return e.Err.Error()
+}
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func NewRetryPolicy(config config.RetryConfig) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:    config.MaxAttempts,
+		InitialBackoff: config.InitialBackoff,
+		MaxBackoff:     config.MaxBackoff,
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = defaultRetryMaxBackoff
+		if policy.MaxBackoff < policy.InitialBackoff {
+			policy.MaxBackoff = policy.InitialBackoff
+		}
+	}
+	return policy
+}
+
+// Backoff returns the exponential delay before the given attempt with equal jitter applied.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+var (
+	ErrQueueFull        = errors.New("Queue is full")
+	ErrSchedulerStopped = errors.New("Scheduler is stopped")
+)
+
 type Scheduler interface {
-	Schedule(d dispatch)
+	// Schedule queues the dispatch, ErrQueueFull is returned if the queue has no room for it.
+	Schedule(d dispatch) error
+	// Replay schedules the dispatches left in the journal by a previous run with the given processor.
+	Replay(processor GithubEventProcessor) error
+	/*
+		Shutdown stops accepting dispatches and waits for workers to finish in-flight ones until ctx is done.
+		Queued dispatches and pending retries stay in the journal to be replayed on the next start,
+		without a journal they are moved to dead letters, so they can be re-driven later.
+	*/
+	Shutdown(ctx context.Context) error
 }
 
 type scheduler struct {
-	queue chan dispatch
+	queue          chan dispatch
+	enqueueTimeout time.Duration
+	retryPolicy    RetryPolicy
+	deadLetters    store.DeadLetterStore
+	journal        store.DispatchJournal
+
+	// mu is held for reading while scheduling, so Shutdown can wait for pending Schedule calls.
+	mu        sync.RWMutex
+	stopOnce  sync.Once
+	stopping  chan struct{}
+	workers   sync.WaitGroup
+	retriesMu sync.Mutex
+	retries   map[*time.Timer]dispatch
 }
 
-func NewGithubEventScheduler(queueSize int, workers int) (Scheduler, error) {
+/*
+Create a scheduler processing dispatches with the given number of workers.
+If the queue is full, Schedule waits up to enqueueTimeout for room before rejecting the dispatch.
+If a journal is given, dispatches are persisted before Schedule returns and completed once they are processed.
+*/
+func NewGithubEventScheduler(queueSize int, workers int, enqueueTimeout time.Duration, retryPolicy RetryPolicy, deadLetters store.DeadLetterStore, journal store.DispatchJournal) (Scheduler, error) {
 	if queueSize < 0 {
 		return nil, errors.New("Queue size must be non-negative")
 	}
@@ -31,22 +135,328 @@ func NewGithubEventScheduler(queueSize int, workers int) (Scheduler, error) {
 		return nil, errors.New("Worker count must be positive")
 	}
 
-	s := &scheduler{queue: make(chan dispatch, queueSize)}
+	s := &scheduler{
+		queue:          make(chan dispatch, queueSize),
+		enqueueTimeout: enqueueTimeout,
+		retryPolicy:    retryPolicy,
+		deadLetters:    deadLetters,
+		journal:        journal,
+		stopping:       make(chan struct{}),
+		retries:        make(map[*time.Timer]dispatch),
+	}
+	s.workers.Add(workers)
 	for i := 0; i < workers; i++ {
-		go func() {
-			for d := range s.queue {
-				metric.DecreaseGauge(metric.QueuedRequests)
-				metric.IncreaseGauge(metric.ActiveWorkers)
-				d.Processor(d.EventType, d.DeliveryID, d.Payload)
-				metric.DecreaseGauge(metric.ActiveWorkers)
-			}
-		}()
+		go s.work()
 	}
 
 	return s, nil
 }
 
-func (s *scheduler) Schedule(d dispatch) {
+func (s *scheduler) work() {
+	defer s.workers.Done()
+	for {
+		// Prefer stopping over picking up more work, queued dispatches are drained by Shutdown.
+		select {
+		case <-s.stopping:
+			return
+		default:
+		}
+		select {
+		case <-s.stopping:
+			return
+		case d := <-s.queue:
+			metric.DecreaseGauge(metric.QueuedRequests)
+			metric.IncreaseGauge(metric.ActiveWorkers)
+			if err := d.Processor(d); err != nil {
+				s.handleFailure(d, err)
+			}
+			s.complete(d)
+			metric.DecreaseGauge(metric.ActiveWorkers)
+		}
+	}
+}
+
+func (s *scheduler) Schedule(d dispatch) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.isStopping() {
+		return ErrSchedulerStopped
+	}
+	journaled := d.JournalID != 0
+	if err := s.persist(&d); err != nil {
+		return err
+	}
+	metric.IncreaseGauge(metric.QueuedRequests)
+	select {
+	case s.queue <- d:
+		return nil
+	default:
+	}
+	err := ErrQueueFull
+	if s.enqueueTimeout > 0 {
+		timer := time.NewTimer(s.enqueueTimeout)
+		defer timer.Stop()
+		select {
+		case s.queue <- d:
+			return nil
+		case <-timer.C:
+		case <-s.stopping:
+			err = ErrSchedulerStopped
+		}
+	}
+	metric.DecreaseGauge(metric.QueuedRequests)
+	// The caller owns the dispatch again, only entries persisted by this call are discarded.
+	if !journaled {
+		s.complete(d)
+	}
+	return err
+}
+
+// persist appends the dispatch to the journal unless it is journaled already or no journal is configured.
+func (s *scheduler) persist(d *dispatch) error {
+	if s.journal == nil || d.JournalID != 0 {
+		return nil
+	}
+	id, err := s.journal.Append(store.JournalEntry{
+		EventType:  d.EventType,
+		DeliveryID: d.DeliveryID,
+		Payload:    d.Payload,
+		App:        d.App,
+		Pipelines:  d.Pipelines,
+		Attempt:    d.Attempt,
+		EnqueuedAt: time.Now(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Can not persist dispatch")
+	}
+	d.JournalID = id
+	return nil
+}
+
+func (s *scheduler) complete(d dispatch) {
+	if s.journal == nil || d.JournalID == 0 {
+		return
+	}
+	if err := s.journal.Complete(d.JournalID); err != nil {
+		log.WithError(err).WithField("delivery_id", d.DeliveryID).Error("Can not complete journal entry, dispatch will be replayed")
+	}
+}
+
+// abandon handles a dispatch which is not processed because the scheduler is stopping.
+func (s *scheduler) abandon(d dispatch, reason string) {
+	if d.JournalID != 0 {
+		log.WithFields(log.Fields{
+			"delivery_id": d.DeliveryID,
+			"journal_id":  d.JournalID,
+		}).Info("Dispatch is kept in journal for replay")
+		return
+	}
+	s.deadLetter(d, d.Pipelines, errors.Wrap(ErrSchedulerStopped, reason))
+}
+
+func (s *scheduler) Replay(processor GithubEventProcessor) error {
+	if s.journal == nil {
+		return nil
+	}
+	entries, err := s.journal.Pending()
+	if err != nil {
+		return errors.Wrap(err, "Can not read dispatch journal")
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	log.WithField("count", len(entries)).Info("Replaying journaled dispatches")
+	// Replayed dispatches may exceed the queue size, so they are queued without blocking the caller.
+	go func() {
+		for _, entry := range entries {
+			d := dispatch{
+				Processor:  processor,
+				EventType:  entry.EventType,
+				DeliveryID: entry.DeliveryID,
+				Payload:    entry.Payload,
+				App:        entry.App,
+				Pipelines:  entry.Pipelines,
+				Attempt:    entry.Attempt,
+				JournalID:  entry.ID,
+			}
+			if !s.enqueue(d) {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// enqueue waits for room in the queue and reports false if the scheduler is stopping.
+func (s *scheduler) enqueue(d dispatch) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.isStopping() {
+		return false
+	}
 	metric.IncreaseGauge(metric.QueuedRequests)
-	s.queue <- d
+	select {
+	case s.queue <- d:
+		return true
+	case <-s.stopping:
+		metric.DecreaseGauge(metric.QueuedRequests)
+		return false
+	}
+}
+
+func (s *scheduler) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *scheduler) Shutdown(ctx context.Context) error {
+	first := false
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+		first = true
+	})
+	if !first {
+		return nil
+	}
+	log.Info("Stopping scheduler...")
+	// Closing stopping releases Schedule calls waiting for room, afterwards no dispatch can be queued anymore.
+	s.mu.Lock()
+	s.mu.Unlock()
+
+	s.retriesMu.Lock()
+	retries := s.retries
+	s.retries = make(map[*time.Timer]dispatch)
+	s.retriesMu.Unlock()
+	for timer, d := range retries {
+		if timer.Stop() {
+			s.abandon(d, "Retry is not scheduled")
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "Workers did not finish in time")
+		log.WithError(err).Error("In-flight dispatches are abandoned")
+	}
+
+	drained := 0
+	for {
+		select {
+		case d := <-s.queue:
+			metric.DecreaseGauge(metric.QueuedRequests)
+			s.abandon(d, "Dispatch is not processed")
+			drained++
+			continue
+		default:
+		}
+		break
+	}
+	log.WithField("drained", drained).Info("Scheduler stopped")
+	return err
+}
+
+func (s *scheduler) handleFailure(d dispatch, err error) {
+	d.Attempt++
+	transient, permanent := d.Pipelines, []string(nil)
+	var dErr *dispatchError
+	if errors.As(err, &dErr) {
+		transient, permanent = dErr.Transient, dErr.Permanent
+	}
+	if len(permanent) > 0 {
+		s.deadLetter(d, permanent, err)
+	}
+	if len(permanent) > 0 && len(transient) == 0 {
+		return
+	}
+	if d.Attempt >= s.retryPolicy.MaxAttempts {
+		s.deadLetter(d, transient, err)
+		return
+	}
+
+	retry := d
+	retry.Pipelines = transient
+	// The retry is journaled before the failed dispatch is completed, so it survives a crash during the backoff.
+	retry.JournalID = 0
+	if err := s.persist(&retry); err != nil {
+		log.WithError(err).WithField("delivery_id", d.DeliveryID).Warn("Retry is kept in memory only")
+	}
+	s.retriesMu.Lock()
+	defer s.retriesMu.Unlock()
+	if s.isStopping() {
+		s.abandon(retry, "Retry is not scheduled")
+		return
+	}
+	delay := s.retryPolicy.Backoff(d.Attempt)
+	log.
+		WithError(err).
+		WithFields(log.Fields{
+			"delivery_id": d.DeliveryID,
+			"attempt":     d.Attempt,
+			"pipelines":   transient,
+			"delay":       delay.String(),
+		}).
+		Warn("Dispatch failed, will retry")
+	metric.IncreaseCounter(metric.RetriedDispatchCount)
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		s.retriesMu.Lock()
+		_, pending := s.retries[timer]
+		delete(s.retries, timer)
+		s.retriesMu.Unlock()
+		if !pending {
+			// Shutdown took over the retry.
+			return
+		}
+		err := s.Schedule(retry)
+		if err == ErrSchedulerStopped {
+			s.abandon(retry, "Retry is not scheduled")
+		} else if err != nil {
+			s.deadLetter(retry, retry.Pipelines, errors.Wrap(err, "Can not schedule retry"))
+			s.complete(retry)
+		}
+	})
+	s.retries[timer] = retry
+}
+
+func (s *scheduler) deadLetter(d dispatch, pipelines []string, err error) {
+	letter := store.DeadLetter{
+		ID:         uuid.New().String(),
+		EventType:  d.EventType,
+		DeliveryID: d.DeliveryID,
+		Payload:    d.Payload,
+		App:        d.App,
+		Pipelines:  pipelines,
+		Attempts:   d.Attempt,
+		Error:      err.Error(),
+		FailedAt:   time.Now(),
+	}
+	logger := log.
+		WithError(err).
+		WithFields(log.Fields{
+			"dead_letter_id": letter.ID,
+			"delivery_id":    d.DeliveryID,
+			"attempts":       d.Attempt,
+			"pipelines":      pipelines,
+		})
+	metric.IncreaseCounter(metric.DeadLetterCount)
+	if s.deadLetters == nil {
+		logger.Error("Dispatch failed permanently, no dead letter store is configured")
+		return
+	}
+	if storeErr := s.deadLetters.Add(letter); storeErr != nil {
+		logger.WithField("store_error", storeErr.Error()).Error("Dispatch failed permanently and can not be stored as dead letter")
+		return
+	}
+	logger.Error("Dispatch failed permanently, moved to dead letters")
 }
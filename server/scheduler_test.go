@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/mattermost/release-bot/config"
+	"github.com/mattermost/release-bot/store"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,31 +18,224 @@ type TestData struct {
 
 func TestScheduler(t *testing.T) {
 	t.Run("Negative Queue Length", func(t *testing.T) {
-		s, err := NewGithubEventScheduler(-1, 1)
+		s, err := NewGithubEventScheduler(-1, 1, 0, NewRetryPolicy(config.RetryConfig{}), nil, nil)
 		assert.Nil(t, s)
 		assert.Error(t, err)
 	})
 	t.Run("Zero Workers", func(t *testing.T) {
-		s, err := NewGithubEventScheduler(100, 0)
+		s, err := NewGithubEventScheduler(100, 0, 0, NewRetryPolicy(config.RetryConfig{}), nil, nil)
 		assert.Nil(t, s)
 		assert.Error(t, err)
 	})
 	t.Run("Valid Scheduler Test", func(t *testing.T) {
 		done := make(chan bool)
 		test := &TestData{Called: false}
-		s, _ := NewGithubEventScheduler(1, 1)
+		s, _ := NewGithubEventScheduler(1, 1, 0, NewRetryPolicy(config.RetryConfig{}), nil, nil)
 		d := dispatch{
-			Processor: func(eventType string, deliveryID string, payload []byte) {
+			Processor: func(d dispatch) error {
 				test.Called = true
 				done <- true
+				return nil
 			},
 		}
-		s.Schedule(d)
+		assert.Nil(t, s.Schedule(d))
 		select {
 		case <-done:
 		case <-time.After(100 * time.Millisecond):
-			panic("timeout")
+			t.Fatal("timeout")
 		}
 		assert.True(t, test.Called)
 	})
+	t.Run("Queue Full", func(t *testing.T) {
+		release := make(chan bool)
+		s, _ := NewGithubEventScheduler(1, 1, 10*time.Millisecond, NewRetryPolicy(config.RetryConfig{}), nil, nil)
+		d := dispatch{
+			Processor: func(d dispatch) error {
+				<-release
+				return nil
+			},
+		}
+		defer close(release)
+		// The first dispatch keeps the only worker busy, the second one fills the queue.
+		assert.Nil(t, s.Schedule(d))
+		assert.Eventually(t, func() bool { return len(s.(*scheduler).queue) == 0 }, time.Second, time.Millisecond)
+		assert.Nil(t, s.Schedule(d))
+		start := time.Now()
+		assert.Equal(t, ErrQueueFull, s.Schedule(d))
+		assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	})
+	t.Run("Shutdown Drains Queue", func(t *testing.T) {
+		deadLetters := store.NewMemoryDeadLetterStore()
+		started := make(chan bool)
+		release := make(chan bool)
+		finished := make(chan bool, 1)
+		processed := make(chan string, 1)
+		s, _ := NewGithubEventScheduler(1, 1, 0, NewRetryPolicy(config.RetryConfig{}), deadLetters, nil)
+		assert.Nil(t, s.Schedule(dispatch{
+			DeliveryID: "100",
+			Processor: func(d dispatch) error {
+				started <- true
+				<-release
+				finished <- true
+				return nil
+			},
+		}))
+		<-started
+		assert.Nil(t, s.Schedule(dispatch{
+			DeliveryID: "101",
+			Processor: func(d dispatch) error {
+				processed <- d.DeliveryID
+				return nil
+			},
+		}))
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(release)
+		}()
+		assert.Nil(t, s.Shutdown(context.Background()))
+		assert.Len(t, finished, 1)
+		select {
+		case deliveryID := <-processed:
+			t.Errorf("Queued dispatch %s must not be processed after shutdown", deliveryID)
+		default:
+		}
+
+		letters, _ := deadLetters.List()
+		assert.Len(t, letters, 1)
+		assert.Equal(t, "101", letters[0].DeliveryID)
+		assert.Equal(t, ErrSchedulerStopped, s.Schedule(dispatch{}))
+		assert.Nil(t, s.Shutdown(context.Background()))
+	})
+	t.Run("Shutdown Dead Letters Pending Retries", func(t *testing.T) {
+		deadLetters := store.NewMemoryDeadLetterStore()
+		done := make(chan bool)
+		policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+		s, _ := NewGithubEventScheduler(1, 1, 0, policy, deadLetters, nil)
+		assert.Nil(t, s.Schedule(dispatch{
+			DeliveryID: "100",
+			Pipelines:  []string{"a/b/c"},
+			Processor: func(d dispatch) error {
+				defer close(done)
+				return errors.New("failed")
+			},
+		}))
+		<-done
+		assert.Eventually(t, func() bool {
+			sc := s.(*scheduler)
+			sc.retriesMu.Lock()
+			defer sc.retriesMu.Unlock()
+			return len(sc.retries) == 1
+		}, time.Second, time.Millisecond)
+
+		assert.Nil(t, s.Shutdown(context.Background()))
+		letters, _ := deadLetters.List()
+		assert.Len(t, letters, 1)
+		assert.Equal(t, []string{"a/b/c"}, letters[0].Pipelines)
+		assert.Equal(t, 1, letters[0].Attempts)
+	})
+	t.Run("Shutdown Timeout", func(t *testing.T) {
+		started := make(chan bool)
+		release := make(chan bool)
+		defer close(release)
+		s, _ := NewGithubEventScheduler(1, 1, 0, NewRetryPolicy(config.RetryConfig{}), nil, nil)
+		assert.Nil(t, s.Schedule(dispatch{
+			Processor: func(d dispatch) error {
+				started <- true
+				<-release
+				return nil
+			},
+		}))
+		<-started
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Error(t, s.Shutdown(ctx))
+	})
+	t.Run("Journal", func(t *testing.T) {
+		journal, _ := store.NewBoltDispatchJournal(filepath.Join(t.TempDir(), "journal.db"))
+		defer journal.Close()
+		started := make(chan bool)
+		release := make(chan bool)
+		s, _ := NewGithubEventScheduler(1, 1, 0, NewRetryPolicy(config.RetryConfig{}), nil, journal)
+		blocking := func(d dispatch) error {
+			started <- true
+			<-release
+			return nil
+		}
+		assert.Nil(t, s.Schedule(dispatch{EventType: "push", DeliveryID: "100", Processor: blocking}))
+		<-started
+		assert.Nil(t, s.Schedule(dispatch{EventType: "push", DeliveryID: "101", Payload: []byte(`{}`), Processor: blocking}))
+		// A rejected dispatch is not left in the journal.
+		assert.Equal(t, ErrQueueFull, s.Schedule(dispatch{EventType: "push", DeliveryID: "102", Processor: blocking}))
+		entries, _ := journal.Pending()
+		assert.Len(t, entries, 2)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(release)
+		}()
+		assert.Nil(t, s.Shutdown(context.Background()))
+		// The in-flight dispatch is completed, the queued one is kept for replay.
+		entries, _ = journal.Pending()
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "101", entries[0].DeliveryID)
+
+		replayed := make(chan dispatch, 1)
+		s, _ = NewGithubEventScheduler(1, 1, 0, NewRetryPolicy(config.RetryConfig{}), nil, journal)
+		assert.Nil(t, s.Replay(func(d dispatch) error {
+			replayed <- d
+			return nil
+		}))
+		select {
+		case d := <-replayed:
+			assert.Equal(t, "101", d.DeliveryID)
+			assert.Equal(t, []byte(`{}`), d.Payload)
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+		assert.Nil(t, s.Shutdown(context.Background()))
+		entries, _ = journal.Pending()
+		assert.Empty(t, entries)
+	})
+	t.Run("Journaled Retry Replaces Failed Dispatch", func(t *testing.T) {
+		journal, _ := store.NewBoltDispatchJournal(filepath.Join(t.TempDir(), "journal.db"))
+		defer journal.Close()
+		done := make(chan bool)
+		policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+		s, _ := NewGithubEventScheduler(1, 1, 0, policy, nil, journal)
+		assert.Nil(t, s.Schedule(dispatch{
+			DeliveryID: "100",
+			Pipelines:  []string{"a/b/c"},
+			Processor: func(d dispatch) error {
+				defer close(done)
+				return errors.New("failed")
+			},
+		}))
+		<-done
+		assert.Nil(t, s.Shutdown(context.Background()))
+		entries, _ := journal.Pending()
+		assert.Len(t, entries, 1)
+		assert.Equal(t, 1, entries[0].Attempt)
+		assert.Equal(t, []string{"a/b/c"}, entries[0].Pipelines)
+	})
+	t.Run("Retry Policy Defaults", func(t *testing.T) {
+		policy := NewRetryPolicy(config.RetryConfig{})
+		assert.Equal(t, 5, policy.MaxAttempts)
+		assert.Equal(t, time.Second, policy.InitialBackoff)
+		assert.Equal(t, time.Minute, policy.MaxBackoff)
+	})
+	t.Run("Retry Policy Backoff", func(t *testing.T) {
+		policy := NewRetryPolicy(config.RetryConfig{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second})
+		for i := 0; i < 100; i++ {
+			delay := policy.Backoff(1)
+			assert.GreaterOrEqual(t, delay, 500*time.Millisecond)
+			assert.LessOrEqual(t, delay, time.Second)
+			delay = policy.Backoff(3)
+			assert.GreaterOrEqual(t, delay, 2*time.Second)
+			assert.LessOrEqual(t, delay, 4*time.Second)
+			delay = policy.Backoff(10)
+			assert.GreaterOrEqual(t, delay, 5*time.Second)
+			assert.LessOrEqual(t, delay, 10*time.Second)
+		}
+	})
 }
@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/mattermost/release-bot/client"
 	"github.com/mattermost/release-bot/config"
 	"github.com/mattermost/release-bot/metric"
+	"github.com/mattermost/release-bot/notifier"
+	"github.com/mattermost/release-bot/oidc"
 	"github.com/mattermost/release-bot/store"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -20,6 +23,7 @@ const (
 	healthHandlerDefaultRoute          string = "/healthz"
 	tokenGenerationHandlerDefaultRoute string = "/token"
 	metricsHandlerDetaultRoute         string = "/metrics"
+	adminAPIDefaultRoute               string = "/api/v1/"
 )
 
 type Server interface {
@@ -27,8 +31,25 @@ type Server interface {
 	Stop() error
 }
 
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	// Pending approvals are expired within this interval after their ttl.
+	approvalSweepInterval = time.Minute
+)
+
 type server struct {
-	server *http.Server
+	server            *http.Server
+	scheduler         Scheduler
+	shutdownTimeout   time.Duration
+	eventContextStore store.EventContextStore
+	dispatches        store.DispatchStore
+	deliveryLedger    store.DeliveryLedger
+	deadLetters       store.DeadLetterStore
+	journal           store.DispatchJournal
+	redisClient       *redis.Client
+	notifier          *notifier.AsyncNotifier
+	// sweeping is closed to stop expiring pending approvals.
+	sweeping chan struct{}
 }
 
 func New() Server {
@@ -59,30 +80,143 @@ func (s *server) Start(ctx context.Context) error {
 }
 
 func (s *server) Stop() error {
+	var err error
+	if s.sweeping != nil {
+		close(s.sweeping)
+	}
 	if s.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		return s.server.Shutdown(ctx)
+		err = s.server.Shutdown(ctx)
 	}
-	return nil
+	// Stores are closed after the scheduler, undrained dispatches are persisted as dead letters.
+	abandoned := false
+	if s.scheduler != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+		if shutdownErr := s.scheduler.Shutdown(ctx); shutdownErr != nil {
+			abandoned = true
+			if err == nil {
+				err = shutdownErr
+			}
+		}
+	}
+	// Notifications queued by the scheduler are posted before stopping.
+	if s.notifier != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultNotificationTimeout)
+		defer cancel()
+		if closeErr := s.notifier.Close(ctx); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	// The delivery ledger is only used by the http server, which is already closed.
+	if s.deliveryLedger != nil {
+		if closeErr := s.deliveryLedger.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if abandoned {
+		// Workers which did not finish in time still use the other stores, they are released when the process exits.
+		log.Warn("Stores of in-flight dispatches are left open")
+		return err
+	}
+	if s.eventContextStore != nil {
+		if closeErr := s.eventContextStore.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if s.dispatches != nil {
+		if closeErr := s.dispatches.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if s.deadLetters != nil {
+		if closeErr := s.deadLetters.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if s.journal != nil {
+		if closeErr := s.journal.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if s.redisClient != nil {
+		if closeErr := s.redisClient.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 func (s *server) registerHandlers(config *config.Config) error {
-	eventContextStore := store.NewEventContextStore()
+	redisClient, err := store.BuildRedisClientFromConfig(config)
+	if err != nil {
+		log.WithError(err).Error("Can not create redis client! Check configuration settings.")
+		return err
+	}
+	s.redisClient = redisClient
+
+	eventContextStore, err := store.BuildFromConfig(config, redisClient)
+	if err != nil {
+		log.WithError(err).Error("Can not create event context store! Check configuration settings.")
+		return err
+	}
+	s.eventContextStore = eventContextStore
+
+	dispatches, err := store.BuildDispatchStoreFromConfig(config, redisClient)
+	if err != nil {
+		log.WithError(err).Error("Can not create dispatch store! Check configuration settings.")
+		return err
+	}
+	s.dispatches = dispatches
+
+	deliveryLedger, err := store.BuildDeliveryLedgerFromConfig(config, redisClient)
+	if err != nil {
+		log.WithError(err).Error("Can not create delivery ledger! Check configuration settings.")
+		return err
+	}
+	s.deliveryLedger = deliveryLedger
 
-	cc, err := client.BuildFromConfig(config)
+	deadLetters, err := store.BuildDeadLetterStoreFromConfig(config, redisClient)
+	if err != nil {
+		log.WithError(err).Error("Can not create dead letter store! Check configuration settings.")
+		return err
+	}
+	s.deadLetters = deadLetters
+
+	journal, err := store.BuildDispatchJournalFromConfig(config)
+	if err != nil {
+		log.WithError(err).Error("Can not create dispatch journal! Check configuration settings.")
+		return err
+	}
+	s.journal = journal
+
+	cc, err := client.BuildFromConfig(config, redisClient)
 	if err != nil {
 		log.WithError(err).Error("Can not create github client creator! Check configuration settings.")
 		return err
 	}
-	githubHookHandler, err := newGithubHookHandler(cc, config, eventContextStore)
+	githubHookHandler, err := newGithubHookHandler(cc, config, eventContextStore, dispatches, deliveryLedger, deadLetters, journal)
 	if err != nil {
 		log.WithError(err).Error("Can not create github request scheduler! Check configuration settings.")
 		return err
 	}
+	s.scheduler = githubHookHandler.Scheduler
+	s.notifier, _ = githubHookHandler.Notifier.(*notifier.AsyncNotifier)
+	s.sweeping = make(chan struct{})
+	go githubHookHandler.expireApprovalsPeriodically(approvalSweepInterval, s.sweeping)
+	s.shutdownTimeout = config.Queue.ShutdownTimeout
+	if s.shutdownTimeout <= 0 {
+		s.shutdownTimeout = defaultShutdownTimeout
+	}
 	http.Handle(healthHandlerDefaultRoute, newHealthHandler())
 	http.Handle(githubHandlerDefaultRoute, githubHookHandler)
-	http.Handle(tokenGenerationHandlerDefaultRoute, newGithubTokenHandler(cc, eventContextStore))
+	// Webhooks of an app can be sent to its own path, like "/hook/private".
+	http.Handle(githubHandlerDefaultRoute+"/", githubHookHandler)
+	http.Handle(tokenGenerationHandlerDefaultRoute, newGithubTokenHandler(cc, config.Pipelines, oidc.BuildFromConfig(config), eventContextStore, dispatches))
 	http.Handle(metricsHandlerDetaultRoute, promhttp.Handler())
+	if config.Server.AdminToken != "" {
+		http.Handle(adminAPIDefaultRoute, newAdminAPIHandler(config.Server.AdminToken, config.Pipelines, cc, eventContextStore, dispatches, deadLetters, githubHookHandler))
+	}
 	return nil
 }
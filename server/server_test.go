@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/release-bot/store"
+	"github.com/stretchr/testify/assert"
+)
+
+type timingOutScheduler struct {
+	rejectingScheduler
+}
+
+func (s timingOutScheduler) Shutdown(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type closingDispatchStore struct {
+	store.DispatchStore
+	closed bool
+}
+
+func (s *closingDispatchStore) Close() error {
+	s.closed = true
+	return s.DispatchStore.Close()
+}
+
+type closingDeliveryLedger struct {
+	store.DeliveryLedger
+	closed bool
+}
+
+func (l *closingDeliveryLedger) Close() error {
+	l.closed = true
+	return l.DeliveryLedger.Close()
+}
+
+func TestServerStop(t *testing.T) {
+	newServer := func(scheduler Scheduler) (*server, *closingDispatchStore, *closingDeliveryLedger) {
+		deliveryLedger, _ := store.NewMemoryDeliveryLedger(10, time.Hour)
+		dispatches := &closingDispatchStore{DispatchStore: store.NewMemoryDispatchStore()}
+		ledger := &closingDeliveryLedger{DeliveryLedger: deliveryLedger}
+		return &server{
+			scheduler:       scheduler,
+			shutdownTimeout: 10 * time.Millisecond,
+			dispatches:      dispatches,
+			deliveryLedger:  ledger,
+		}, dispatches, ledger
+	}
+	t.Run("Stores Are Closed", func(t *testing.T) {
+		srv, dispatches, ledger := newServer(rejectingScheduler{})
+		assert.Nil(t, srv.Stop())
+		assert.True(t, dispatches.closed)
+		assert.True(t, ledger.closed)
+	})
+	t.Run("Stores Of In-Flight Dispatches Are Left Open", func(t *testing.T) {
+		srv, dispatches, ledger := newServer(timingOutScheduler{})
+		assert.Error(t, srv.Stop())
+		assert.False(t, dispatches.closed)
+		assert.True(t, ledger.closed)
+	})
+}
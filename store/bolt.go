@@ -0,0 +1,82 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+type boltHandle struct {
+	db         *bolt.DB
+	references int
+}
+
+// Bolt locks its file exclusively, so stores configured with the same path share one database handle.
+var (
+	boltHandlesLock sync.Mutex
+	boltHandles     = make(map[string]*boltHandle)
+)
+
+func openBoltDB(path string) (*bolt.DB, error) {
+	if path == "" {
+		return nil, errors.New("bolt store path is not configured")
+	}
+	boltHandlesLock.Lock()
+	defer boltHandlesLock.Unlock()
+	if handle, ok := boltHandles[path]; ok {
+		handle.references++
+		return handle.db, nil
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not open bolt store at %s", path)
+	}
+	boltHandles[path] = &boltHandle{db: db, references: 1}
+	return db, nil
+}
+
+func closeBoltDB(db *bolt.DB) error {
+	boltHandlesLock.Lock()
+	defer boltHandlesLock.Unlock()
+	path := db.Path()
+	handle, ok := boltHandles[path]
+	if !ok || handle.db != db {
+		return db.Close()
+	}
+	handle.references--
+	if handle.references > 0 {
+		return nil
+	}
+	delete(boltHandles, path)
+	return db.Close()
+}
+
+// compactBoltBucket removes the entries of the bucket for which isExpired returns true and returns the number of removed entries.
+func compactBoltBucket(db *bolt.DB, bucket []byte, isExpired func(value []byte) bool) (int, error) {
+	removed := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		var expired [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			if isExpired(v) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "can not compact bolt bucket %s", bucket)
+	}
+	return removed, nil
+}
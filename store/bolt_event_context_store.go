@@ -0,0 +1,247 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattermost/release-bot/model"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Version 2 adds the bucket of run bindings.
+const boltSchemaVersion uint64 = 2
+
+var (
+	boltMetaBucket         = []byte("meta")
+	boltEventContextBucket = []byte("event_contexts")
+	boltRunBindingBucket   = []byte("run_bindings")
+	boltSchemaVersionKey   = []byte("schema_version")
+)
+
+type boltEventContextRecord struct {
+	ExpiresAt    time.Time       `json:"expires_at"`
+	EventContext json.RawMessage `json:"event_context"`
+	Binding      *RunBinding     `json:"binding,omitempty"`
+}
+
+// boltRunBindingRecord points a workflow run to the bot token bound to it.
+type boltRunBindingRecord struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Token     string    `json:"token"`
+}
+
+type boltEventContextStore struct {
+	ItemDuration *time.Duration
+	db           *bolt.DB
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func NewBoltEventContextStore(path string) (EventContextStore, error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+	store := &boltEventContextStore{
+		ItemDuration: &itemExpireDuration,
+		db:           db,
+		done:         make(chan struct{}),
+	}
+	if err := store.migrate(); err != nil {
+		closeBoltDB(db)
+		return nil, err
+	}
+	if _, err := store.Compact(); err != nil {
+		closeBoltDB(db)
+		return nil, err
+	}
+	go store.compactPeriodically(cacheExpireInterval)
+	return store, nil
+}
+
+// migrate creates the buckets and upgrades stored data to the current schema version.
+func (store *boltEventContextStore) migrate() error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return errors.Wrap(err, "can not create meta bucket")
+		}
+		var version uint64
+		if v := meta.Get(boltSchemaVersionKey); v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+		if version > boltSchemaVersion {
+			return fmt.Errorf("bolt store schema version %d is newer than supported version %d", version, boltSchemaVersion)
+		}
+		if _, err := tx.CreateBucketIfNotExists(boltEventContextBucket); err != nil {
+			return errors.Wrap(err, "can not create event context bucket")
+		}
+		if _, err := tx.CreateBucketIfNotExists(boltRunBindingBucket); err != nil {
+			return errors.Wrap(err, "can not create run binding bucket")
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, boltSchemaVersion)
+		return meta.Put(boltSchemaVersionKey, v)
+	})
+}
+
+func (store *boltEventContextStore) Store(eventContext model.EventContext, token string) error {
+	serialized, err := model.MarshalEventContext(eventContext)
+	if err != nil {
+		return err
+	}
+	record, err := json.Marshal(boltEventContextRecord{
+		ExpiresAt:    time.Now().Add(*store.ItemDuration),
+		EventContext: serialized,
+	})
+	if err != nil {
+		return errors.Wrap(err, "can not serialize event context record")
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEventContextBucket).Put([]byte(token), record)
+	})
+}
+
+func (store *boltEventContextStore) Get(token string) (model.EventContext, error) {
+	var record boltEventContextRecord
+	err := store.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltEventContextBucket).Get([]byte(token))
+		if data == nil {
+			return fmt.Errorf("not found")
+		}
+		return json.Unmarshal(data, &record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record.ExpiresAt.Before(time.Now()) {
+		return nil, fmt.Errorf("not found")
+	}
+	return model.UnmarshalEventContext(record.EventContext)
+}
+
+func (store *boltEventContextStore) Delete(token string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		contexts := tx.Bucket(boltEventContextBucket)
+		if record, err := getBoltEventContextRecord(contexts, token); err == nil && record.Binding != nil {
+			if err := tx.Bucket(boltRunBindingBucket).Delete([]byte(runBindingKey(record.Binding.Repository, record.Binding.RunID))); err != nil {
+				return err
+			}
+		}
+		return contexts.Delete([]byte(token))
+	})
+}
+
+func (store *boltEventContextStore) Bind(token string, repository string, runID int64) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		contexts := tx.Bucket(boltEventContextBucket)
+		record, err := getBoltEventContextRecord(contexts, token)
+		if err != nil {
+			return err
+		}
+		if record.Binding != nil {
+			return record.Binding.check(repository, runID)
+		}
+		record.Binding = &RunBinding{Repository: repository, RunID: runID}
+		if err := putBoltRecord(contexts, token, record); err != nil {
+			return err
+		}
+		return putBoltRecord(tx.Bucket(boltRunBindingBucket), runBindingKey(repository, runID), boltRunBindingRecord{
+			ExpiresAt: record.ExpiresAt,
+			Token:     token,
+		})
+	})
+}
+
+func (store *boltEventContextStore) ExpireBinding(repository string, runID int64) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltRunBindingBucket).Get([]byte(runBindingKey(repository, runID)))
+		if data == nil {
+			return nil
+		}
+		var binding boltRunBindingRecord
+		if err := json.Unmarshal(data, &binding); err != nil {
+			return errors.Wrap(err, "can not read run binding record")
+		}
+		contexts := tx.Bucket(boltEventContextBucket)
+		record, err := getBoltEventContextRecord(contexts, binding.Token)
+		if err != nil || record.Binding == nil {
+			return nil
+		}
+		record.Binding.Expired = true
+		return putBoltRecord(contexts, binding.Token, record)
+	})
+}
+
+func getBoltEventContextRecord(bucket *bolt.Bucket, token string) (boltEventContextRecord, error) {
+	var record boltEventContextRecord
+	data := bucket.Get([]byte(token))
+	if data == nil {
+		return record, fmt.Errorf("not found")
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, errors.Wrap(err, "can not read event context record")
+	}
+	if record.ExpiresAt.Before(time.Now()) {
+		return record, fmt.Errorf("not found")
+	}
+	return record, nil
+}
+
+func putBoltRecord(bucket *bolt.Bucket, key string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "can not serialize record")
+	}
+	return bucket.Put([]byte(key), data)
+}
+
+// Compact removes expired and unreadable event contexts and run bindings, it returns the number of removed entries.
+func (store *boltEventContextStore) Compact() (int, error) {
+	now := time.Now()
+	removed, err := compactBoltBucket(store.db, boltEventContextBucket, func(v []byte) bool {
+		var record boltEventContextRecord
+		return json.Unmarshal(v, &record) != nil || record.ExpiresAt.Before(now)
+	})
+	if err != nil {
+		return removed, err
+	}
+	removedBindings, err := compactBoltBucket(store.db, boltRunBindingBucket, func(v []byte) bool {
+		var record boltRunBindingRecord
+		return json.Unmarshal(v, &record) != nil || record.ExpiresAt.Before(now)
+	})
+	return removed + removedBindings, err
+}
+
+func (store *boltEventContextStore) compactPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := store.Compact()
+			if err != nil {
+				log.WithError(err).Error("Error occurred while compacting event context store")
+				continue
+			}
+			log.WithField("removed", removed).Debug("Event context store compacted")
+		case <-store.done:
+			return
+		}
+	}
+}
+
+// Close stops the cleanup and releases the database, only the first call closes the store.
+func (store *boltEventContextStore) Close() error {
+	var err error
+	store.closeOnce.Do(func() {
+		close(store.done)
+		err = closeBoltDB(store.db)
+	})
+	return err
+}
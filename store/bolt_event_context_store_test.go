@@ -0,0 +1,81 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltEventContextStore(t *testing.T) {
+	t.Run("Missing Path", func(t *testing.T) {
+		store, err := NewBoltEventContextStore("")
+		assert.Nil(t, store)
+		assert.Error(t, err)
+	})
+	t.Run("Context Store Survives Restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "release-bot.db")
+		token := "test"
+		event := createWorkflowRunEvent(t)
+
+		store, err := NewBoltEventContextStore(path)
+		assert.Nil(t, err)
+		assert.Nil(t, store.Store(event, token))
+		assert.Nil(t, store.Close())
+		assert.Nil(t, store.Close())
+
+		store, err = NewBoltEventContextStore(path)
+		assert.Nil(t, err)
+		defer store.Close()
+		context, err := store.Get(token)
+		assert.Nil(t, err)
+		assert.Equal(t, event, context)
+
+		context, err = store.Get("unknown")
+		assert.Error(t, err)
+		assert.Nil(t, context)
+
+		assert.Nil(t, store.Delete(token))
+		_, err = store.Get(token)
+		assert.Error(t, err)
+	})
+	t.Run("Context Store Expiry And Compaction Test", func(t *testing.T) {
+		itemExpireDuration = time.Millisecond
+		defer func() { itemExpireDuration = 6 * time.Hour }()
+		store, err := NewBoltEventContextStore(filepath.Join(t.TempDir(), "release-bot.db"))
+		assert.Nil(t, err)
+		defer store.Close()
+		assert.Nil(t, store.Store(createWorkflowRunEvent(t), "test"))
+
+		time.Sleep(5 * time.Millisecond)
+
+		context, err := store.Get("test")
+		assert.Error(t, err)
+		assert.Nil(t, context)
+
+		removed, err := store.(*boltEventContextStore).Compact()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, removed)
+	})
+}
+
+func TestBuildFromConfig(t *testing.T) {
+	t.Run("Default Store", func(t *testing.T) {
+		store, err := BuildFromConfig(&config.Config{}, nil)
+		assert.Nil(t, err)
+		assert.IsType(t, &eventContextStore{}, store)
+	})
+	t.Run("Bolt Store", func(t *testing.T) {
+		store, err := BuildFromConfig(&config.Config{Store: config.StoreConfig{Type: BoltStoreType, Path: filepath.Join(t.TempDir(), "release-bot.db")}}, nil)
+		assert.Nil(t, err)
+		assert.IsType(t, &boltEventContextStore{}, store)
+		store.Close()
+	})
+	t.Run("Unknown Store", func(t *testing.T) {
+		store, err := BuildFromConfig(&config.Config{Store: config.StoreConfig{Type: "unknown"}}, nil)
+		assert.Nil(t, store)
+		assert.Error(t, err)
+	})
+}
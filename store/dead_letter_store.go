@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltDeadLetterBucket = []byte("dead_letters")
+
+// DeadLetter is a webhook delivery whose pipelines could not be dispatched.
+type DeadLetter struct {
+	ID         string    `json:"id"`
+	EventType  string    `json:"event_type"`
+	DeliveryID string    `json:"delivery_id"`
+	Payload    []byte    `json:"payload"`
+	App        string    `json:"app,omitempty"`
+	Pipelines  []string  `json:"pipelines"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+type DeadLetterStore interface {
+	Add(letter DeadLetter) error
+	Get(id string) (DeadLetter, error)
+	// List returns dead letters ordered by failure time, oldest first.
+	List() ([]DeadLetter, error)
+	Remove(id string) error
+	Close() error
+}
+
+func BuildDeadLetterStoreFromConfig(config *config.Config, redisClient *redis.Client) (DeadLetterStore, error) {
+	switch config.Store.Type {
+	case "", MemoryStoreType:
+		return NewMemoryDeadLetterStore(), nil
+	case BoltStoreType:
+		return NewBoltDeadLetterStore(config.Store.Path)
+	case RedisStoreType:
+		if err := requireRedisClient(redisClient); err != nil {
+			return nil, err
+		}
+		return NewRedisDeadLetterStore(redisClient, RedisKeyPrefix(config.Store.Redis)), nil
+	default:
+		return nil, fmt.Errorf("unknown store type %s", config.Store.Type)
+	}
+}
+
+func sortDeadLetters(letters []DeadLetter) []DeadLetter {
+	sort.SliceStable(letters, func(i, j int) bool {
+		return letters[i].FailedAt.Before(letters[j].FailedAt)
+	})
+	return letters
+}
+
+type memoryDeadLetterStore struct {
+	mu      sync.RWMutex
+	letters map[string]DeadLetter
+}
+
+func NewMemoryDeadLetterStore() DeadLetterStore {
+	return &memoryDeadLetterStore{
+		letters: make(map[string]DeadLetter),
+	}
+}
+
+func (s *memoryDeadLetterStore) Add(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters[letter.ID] = letter
+	return nil
+}
+
+func (s *memoryDeadLetterStore) Get(id string) (DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	letter, ok := s.letters[id]
+	if !ok {
+		return DeadLetter{}, fmt.Errorf("not found")
+	}
+	return letter, nil
+}
+
+func (s *memoryDeadLetterStore) List() ([]DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	return sortDeadLetters(letters), nil
+}
+
+func (s *memoryDeadLetterStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.letters, id)
+	return nil
+}
+
+func (s *memoryDeadLetterStore) Close() error {
+	return nil
+}
+
+type boltDeadLetterStore struct {
+	db        *bolt.DB
+	closeOnce sync.Once
+}
+
+func NewBoltDeadLetterStore(path string) (DeadLetterStore, error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltDeadLetterBucket)
+		return err
+	})
+	if err != nil {
+		closeBoltDB(db)
+		return nil, errors.Wrap(err, "can not create dead letter bucket")
+	}
+	return &boltDeadLetterStore{db: db}, nil
+}
+
+func (s *boltDeadLetterStore) Add(letter DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return errors.Wrap(err, "can not serialize dead letter")
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeadLetterBucket).Put([]byte(letter.ID), data)
+	})
+}
+
+func (s *boltDeadLetterStore) Get(id string) (DeadLetter, error) {
+	var letter DeadLetter
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltDeadLetterBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("not found")
+		}
+		return json.Unmarshal(data, &letter)
+	})
+	return letter, err
+}
+
+func (s *boltDeadLetterStore) List() ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeadLetterBucket).ForEach(func(k, v []byte) error {
+			var letter DeadLetter
+			if err := json.Unmarshal(v, &letter); err != nil {
+				return errors.Wrapf(err, "can not deserialize dead letter %s", k)
+			}
+			letters = append(letters, letter)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortDeadLetters(letters), nil
+}
+
+func (s *boltDeadLetterStore) Remove(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeadLetterBucket).Delete([]byte(id))
+	})
+}
+
+func (s *boltDeadLetterStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		err = closeBoltDB(s.db)
+	})
+	return err
+}
+
+type redisDeadLetterStore struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisDeadLetterStore keeps all dead letters in a single hash, so every replica can list and re-drive them.
+func NewRedisDeadLetterStore(client *redis.Client, prefix string) DeadLetterStore {
+	return &redisDeadLetterStore{
+		client: client,
+		key:    fmt.Sprintf("%s:dead-letters", prefix),
+	}
+}
+
+func (s *redisDeadLetterStore) Add(letter DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return errors.Wrap(err, "can not serialize dead letter")
+	}
+	return s.client.HSet(context.Background(), s.key, letter.ID, data).Err()
+}
+
+func (s *redisDeadLetterStore) Get(id string) (DeadLetter, error) {
+	var letter DeadLetter
+	data, err := s.client.HGet(context.Background(), s.key, id).Bytes()
+	if err == redis.Nil {
+		return letter, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return letter, errors.Wrap(err, "can not read dead letter")
+	}
+	err = json.Unmarshal(data, &letter)
+	return letter, err
+}
+
+func (s *redisDeadLetterStore) List() ([]DeadLetter, error) {
+	values, err := s.client.HGetAll(context.Background(), s.key).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read dead letters")
+	}
+	letters := make([]DeadLetter, 0, len(values))
+	for id, data := range values {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(data), &letter); err != nil {
+			return nil, errors.Wrapf(err, "can not deserialize dead letter %s", id)
+		}
+		letters = append(letters, letter)
+	}
+	return sortDeadLetters(letters), nil
+}
+
+func (s *redisDeadLetterStore) Remove(id string) error {
+	return s.client.HDel(context.Background(), s.key, id).Err()
+}
+
+func (s *redisDeadLetterStore) Close() error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mattermost/release-bot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	configs := map[string]config.StoreConfig{
+		MemoryStoreType: {Type: MemoryStoreType},
+		BoltStoreType:   {Type: BoltStoreType, Path: filepath.Join(t.TempDir(), "release-bot.db")},
+		RedisStoreType:  {Type: RedisStoreType, Redis: config.RedisConfig{Address: server.Addr()}},
+	}
+	for name, storeConfig := range configs {
+		t.Run(name, func(t *testing.T) {
+			deadLetters, err := BuildDeadLetterStoreFromConfig(&config.Config{Store: storeConfig}, newTestRedisClient(t, storeConfig))
+			assert.Nil(t, err)
+			defer deadLetters.Close()
+
+			now := time.Now().UTC().Round(time.Second)
+			second := DeadLetter{ID: "2", EventType: "push", DeliveryID: "101", Payload: []byte(`{}`), Pipelines: []string{"a/b/c"}, Attempts: 5, Error: "failure", FailedAt: now}
+			first := DeadLetter{ID: "1", EventType: "push", DeliveryID: "100", Payload: []byte(`{}`), Pipelines: []string{"a/b/c"}, Attempts: 1, Error: "failure", FailedAt: now.Add(-time.Minute)}
+			assert.Nil(t, deadLetters.Add(second))
+			assert.Nil(t, deadLetters.Add(first))
+
+			letters, err := deadLetters.List()
+			assert.Nil(t, err)
+			assert.Equal(t, []DeadLetter{first, second}, letters)
+
+			letter, err := deadLetters.Get("2")
+			assert.Nil(t, err)
+			assert.Equal(t, second, letter)
+
+			assert.Nil(t, deadLetters.Remove("2"))
+			_, err = deadLetters.Get("2")
+			assert.Error(t, err)
+			letters, err = deadLetters.List()
+			assert.Nil(t, err)
+			assert.Len(t, letters, 1)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultDeliveryTTL   = 72 * time.Hour
+	defaultDeliveryLimit = 10000
+)
+
+var boltDeliveryBucket = []byte("deliveries")
+
+/*
+DeliveryLedger remembers processed webhook deliveries, so redeliveries of the same event can be ignored.
+Deliveries expire after the delivery ttl, the memory ledger also keeps at most the delivery limit of them.
+*/
+type DeliveryLedger interface {
+	// MarkDelivered records the delivery and reports whether it was already recorded and not expired.
+	MarkDelivered(deliveryID string) (bool, error)
+	// Forget removes the delivery, so it is processed again when it is redelivered.
+	Forget(deliveryID string) error
+	Close() error
+}
+
+func BuildDeliveryLedgerFromConfig(config *config.Config, redisClient *redis.Client) (DeliveryLedger, error) {
+	ttl := config.Store.DeliveryTTL
+	if ttl <= 0 {
+		ttl = defaultDeliveryTTL
+	}
+	limit := config.Store.DeliveryLimit
+	if limit <= 0 {
+		limit = defaultDeliveryLimit
+	}
+	switch config.Store.Type {
+	case "", MemoryStoreType:
+		return NewMemoryDeliveryLedger(limit, ttl)
+	// Bolt and redis ledgers are bounded by the ttl only, a delivery limit is rejected for them by the configuration.
+	case BoltStoreType:
+		return NewBoltDeliveryLedger(config.Store.Path, ttl)
+	case RedisStoreType:
+		if err := requireRedisClient(redisClient); err != nil {
+			return nil, err
+		}
+		return NewRedisDeliveryLedger(redisClient, RedisKeyPrefix(config.Store.Redis), ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown store type %s", config.Store.Type)
+	}
+}
+
+type memoryDeliveryLedger struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	cache *lru.Cache
+}
+
+// NewMemoryDeliveryLedger keeps at most limit deliveries, the least recently seen ones are evicted first.
+func NewMemoryDeliveryLedger(limit int, ttl time.Duration) (DeliveryLedger, error) {
+	cache, err := lru.New(limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create delivery cache")
+	}
+	return &memoryDeliveryLedger{
+		ttl:   ttl,
+		cache: cache,
+	}, nil
+}
+
+func (l *memoryDeliveryLedger) MarkDelivered(deliveryID string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if expiresAt, ok := l.cache.Peek(deliveryID); ok && now.Before(expiresAt.(time.Time)) {
+		return true, nil
+	}
+	l.cache.Add(deliveryID, now.Add(l.ttl))
+	return false, nil
+}
+
+func (l *memoryDeliveryLedger) Forget(deliveryID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cache.Remove(deliveryID)
+	return nil
+}
+
+func (l *memoryDeliveryLedger) Close() error {
+	return nil
+}
+
+type boltDeliveryLedger struct {
+	ttl       time.Duration
+	db        *bolt.DB
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewBoltDeliveryLedger(path string, ttl time.Duration) (DeliveryLedger, error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltDeliveryBucket)
+		return err
+	})
+	if err != nil {
+		closeBoltDB(db)
+		return nil, errors.Wrap(err, "can not create delivery bucket")
+	}
+	ledger := &boltDeliveryLedger{
+		ttl:  ttl,
+		db:   db,
+		done: make(chan struct{}),
+	}
+	go ledger.compactPeriodically(cacheExpireInterval)
+	return ledger, nil
+}
+
+func (l *boltDeliveryLedger) MarkDelivered(deliveryID string) (bool, error) {
+	duplicate := false
+	now := time.Now()
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDeliveryBucket)
+		if v := bucket.Get([]byte(deliveryID)); v != nil {
+			var expiresAt time.Time
+			if err := json.Unmarshal(v, &expiresAt); err == nil && now.Before(expiresAt) {
+				duplicate = true
+				return nil
+			}
+		}
+		v, err := json.Marshal(now.Add(l.ttl))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(deliveryID), v)
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "can not record delivery")
+	}
+	return duplicate, nil
+}
+
+func (l *boltDeliveryLedger) Forget(deliveryID string) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDeliveryBucket).Delete([]byte(deliveryID))
+	})
+}
+
+func (l *boltDeliveryLedger) Compact() (int, error) {
+	now := time.Now()
+	return compactBoltBucket(l.db, boltDeliveryBucket, func(v []byte) bool {
+		var expiresAt time.Time
+		return json.Unmarshal(v, &expiresAt) != nil || !now.Before(expiresAt)
+	})
+}
+
+func (l *boltDeliveryLedger) compactPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := l.Compact(); err != nil {
+				log.WithError(err).Error("Error occurred while compacting delivery ledger")
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *boltDeliveryLedger) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = closeBoltDB(l.db)
+	})
+	return err
+}
+
+type redisDeliveryLedger struct {
+	ttl    time.Duration
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisDeliveryLedger(client *redis.Client, prefix string, ttl time.Duration) DeliveryLedger {
+	return &redisDeliveryLedger{
+		ttl:    ttl,
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (l *redisDeliveryLedger) key(deliveryID string) string {
+	return fmt.Sprintf("%s:delivery:%s", l.prefix, deliveryID)
+}
+
+func (l *redisDeliveryLedger) MarkDelivered(deliveryID string) (bool, error) {
+	created, err := l.client.SetNX(context.Background(), l.key(deliveryID), time.Now().Unix(), l.ttl).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "can not record delivery")
+	}
+	return !created, nil
+}
+
+func (l *redisDeliveryLedger) Forget(deliveryID string) error {
+	return l.client.Del(context.Background(), l.key(deliveryID)).Err()
+}
+
+func (l *redisDeliveryLedger) Close() error {
+	return nil
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mattermost/release-bot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryLedger(t *testing.T) {
+	server := miniredis.RunT(t)
+	configs := map[string]config.StoreConfig{
+		MemoryStoreType: {Type: MemoryStoreType},
+		BoltStoreType:   {Type: BoltStoreType, Path: filepath.Join(t.TempDir(), "release-bot.db")},
+		RedisStoreType:  {Type: RedisStoreType, Redis: config.RedisConfig{Address: server.Addr()}},
+	}
+	for name, storeConfig := range configs {
+		t.Run(name, func(t *testing.T) {
+			ledger, err := BuildDeliveryLedgerFromConfig(&config.Config{Store: storeConfig}, newTestRedisClient(t, storeConfig))
+			assert.Nil(t, err)
+			defer ledger.Close()
+
+			duplicate, err := ledger.MarkDelivered("100")
+			assert.Nil(t, err)
+			assert.False(t, duplicate)
+
+			duplicate, err = ledger.MarkDelivered("100")
+			assert.Nil(t, err)
+			assert.True(t, duplicate)
+
+			duplicate, err = ledger.MarkDelivered("101")
+			assert.Nil(t, err)
+			assert.False(t, duplicate)
+
+			assert.Nil(t, ledger.Forget("100"))
+			duplicate, err = ledger.MarkDelivered("100")
+			assert.Nil(t, err)
+			assert.False(t, duplicate)
+		})
+	}
+	t.Run("Memory Ledger Expiry", func(t *testing.T) {
+		ledger, err := NewMemoryDeliveryLedger(10, time.Millisecond)
+		assert.Nil(t, err)
+		duplicate, _ := ledger.MarkDelivered("100")
+		assert.False(t, duplicate)
+		time.Sleep(5 * time.Millisecond)
+		duplicate, _ = ledger.MarkDelivered("100")
+		assert.False(t, duplicate)
+	})
+	t.Run("Memory Ledger Limit", func(t *testing.T) {
+		ledger, err := NewMemoryDeliveryLedger(1, time.Hour)
+		assert.Nil(t, err)
+		ledger.MarkDelivered("100")
+		ledger.MarkDelivered("101")
+		duplicate, _ := ledger.MarkDelivered("100")
+		assert.False(t, duplicate)
+	})
+	t.Run("Bolt Ledger Compaction", func(t *testing.T) {
+		ledger, err := NewBoltDeliveryLedger(filepath.Join(t.TempDir(), "release-bot.db"), time.Millisecond)
+		assert.Nil(t, err)
+		defer ledger.Close()
+		ledger.MarkDelivered("100")
+		time.Sleep(5 * time.Millisecond)
+		removed, err := ledger.(*boltDeliveryLedger).Compact()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, removed)
+	})
+	t.Run("Bolt Ledger Shares Store Database", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "release-bot.db")
+		contextStore, err := NewBoltEventContextStore(path)
+		assert.Nil(t, err)
+		dispatches, err := NewBoltDispatchStore(path)
+		assert.Nil(t, err)
+		deadLetters, err := NewBoltDeadLetterStore(path)
+		assert.Nil(t, err)
+		journal, err := NewBoltDispatchJournal(path)
+		assert.Nil(t, err)
+		ledger, err := NewBoltDeliveryLedger(path, time.Hour)
+		assert.Nil(t, err)
+		// Closing a store twice must not release the database of the other stores.
+		for _, closer := range []interface{ Close() error }{contextStore, dispatches, deadLetters, journal} {
+			assert.Nil(t, closer.Close())
+			assert.Nil(t, closer.Close())
+		}
+		duplicate, err := ledger.MarkDelivered("100")
+		assert.Nil(t, err)
+		assert.False(t, duplicate)
+		assert.Nil(t, ledger.Close())
+		assert.Nil(t, ledger.Close())
+	})
+}
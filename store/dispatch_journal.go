@@ -0,0 +1,130 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltDispatchJournalBucket = []byte("dispatch_journal")
+
+// JournalEntry is a webhook delivery accepted by the scheduler but not processed yet.
+type JournalEntry struct {
+	ID         uint64    `json:"id"`
+	EventType  string    `json:"event_type"`
+	DeliveryID string    `json:"delivery_id"`
+	Payload    []byte    `json:"payload"`
+	App        string    `json:"app,omitempty"`
+	Pipelines  []string  `json:"pipelines"`
+	Attempt    int       `json:"attempt"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+/*
+DispatchJournal is a write-ahead log of scheduled dispatches.
+Entries are synced to disk before Append returns and stay pending until they are completed,
+so deliveries accepted before a crash are replayed on the next start at least once.
+*/
+type DispatchJournal interface {
+	// Append persists the entry and returns the ID assigned to it.
+	Append(entry JournalEntry) (uint64, error)
+	// Complete marks the entry as processed, completed entries are not replayed.
+	Complete(id uint64) error
+	// Pending returns the entries which are not completed, in the order they were appended.
+	Pending() ([]JournalEntry, error)
+	Close() error
+}
+
+// BuildDispatchJournalFromConfig returns nil if no journal path is configured, dispatches are kept in memory only then.
+func BuildDispatchJournalFromConfig(config *config.Config) (DispatchJournal, error) {
+	if config.Queue.JournalPath == "" {
+		return nil, nil
+	}
+	return NewBoltDispatchJournal(config.Queue.JournalPath)
+}
+
+type boltDispatchJournal struct {
+	db        *bolt.DB
+	closeOnce sync.Once
+}
+
+// NewBoltDispatchJournal relies on bolt syncing every committed transaction to disk.
+func NewBoltDispatchJournal(path string) (DispatchJournal, error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltDispatchJournalBucket)
+		return err
+	})
+	if err != nil {
+		closeBoltDB(db)
+		return nil, errors.Wrap(err, "can not create dispatch journal bucket")
+	}
+	return &boltDispatchJournal{db: db}, nil
+}
+
+func journalKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func (j *boltDispatchJournal) Append(entry JournalEntry) (uint64, error) {
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDispatchJournalBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(journalKey(id), data)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "can not append to dispatch journal")
+	}
+	return entry.ID, nil
+}
+
+func (j *boltDispatchJournal) Complete(id uint64) error {
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDispatchJournalBucket).Delete(journalKey(id))
+	})
+	return errors.Wrapf(err, "can not complete dispatch journal entry %d", id)
+}
+
+func (j *boltDispatchJournal) Pending() ([]JournalEntry, error) {
+	var entries []JournalEntry
+	err := j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDispatchJournalBucket).ForEach(func(k, v []byte) error {
+			var entry JournalEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return errors.Wrapf(err, "can not deserialize dispatch journal entry %d", binary.BigEndian.Uint64(k))
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (j *boltDispatchJournal) Close() error {
+	var err error
+	j.closeOnce.Do(func() {
+		err = closeBoltDB(j.db)
+	})
+	return err
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattermost/release-bot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchJournal(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		journal, err := BuildDispatchJournalFromConfig(&config.Config{})
+		assert.Nil(t, err)
+		assert.Nil(t, journal)
+	})
+	t.Run("Pending Entries Survive Reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.db")
+		journal, err := BuildDispatchJournalFromConfig(&config.Config{Queue: config.QueueConfig{JournalPath: path}})
+		assert.Nil(t, err)
+
+		now := time.Now().UTC().Round(time.Second)
+		first, err := journal.Append(JournalEntry{EventType: "push", DeliveryID: "100", Payload: []byte(`{}`), EnqueuedAt: now})
+		assert.Nil(t, err)
+		second, err := journal.Append(JournalEntry{EventType: "push", DeliveryID: "101", Payload: []byte(`{}`), Pipelines: []string{"a/b/c"}, Attempt: 1, EnqueuedAt: now})
+		assert.Nil(t, err)
+		third, err := journal.Append(JournalEntry{EventType: "push", DeliveryID: "102", Payload: []byte(`{}`), EnqueuedAt: now})
+		assert.Nil(t, err)
+		assert.True(t, first < second && second < third)
+		assert.Nil(t, journal.Complete(first))
+		assert.Nil(t, journal.Close())
+
+		journal, err = NewBoltDispatchJournal(path)
+		assert.Nil(t, err)
+		defer journal.Close()
+		entries, err := journal.Pending()
+		assert.Nil(t, err)
+		assert.Equal(t, []JournalEntry{
+			{ID: second, EventType: "push", DeliveryID: "101", Payload: []byte(`{}`), Pipelines: []string{"a/b/c"}, Attempt: 1, EnqueuedAt: now},
+			{ID: third, EventType: "push", DeliveryID: "102", Payload: []byte(`{}`), EnqueuedAt: now},
+		}, entries)
+	})
+}
@@ -0,0 +1,552 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akyoto/cache"
+	"github.com/go-redis/redis/v8"
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// States a dispatched pipeline goes through.
+const (
+	// DispatchPending dispatches wait for a maintainer approval, they are expired if they are not approved in time.
+	DispatchPending   = "pending_approval"
+	DispatchExpired   = "expired"
+	DispatchQueued    = "queued"
+	DispatchFailed    = "failed"
+	DispatchRunning   = "running"
+	DispatchCompleted = "completed"
+)
+
+var (
+	boltDispatchBucket    = []byte("dispatches")
+	boltDispatchRunBucket = []byte("dispatch_runs")
+)
+
+// DispatchRecord describes a triggered pipeline, the event it was triggered for and the private run executing it.
+type DispatchRecord struct {
+	Token          string     `json:"token"`
+	Pipeline       string     `json:"pipeline"`
+	Event          string     `json:"event"`
+	Repository     string     `json:"repository"`
+	CommitHash     string     `json:"commit_hash"`
+	InstallationID int64      `json:"installation_id"`
+	App            string     `json:"app,omitempty"`
+	StatusContext  string     `json:"status_context,omitempty"`
+	TargetURL      string     `json:"target_url,omitempty"`
+	State          string     `json:"state"`
+	Conclusion     string     `json:"conclusion,omitempty"`
+	RunRepository  string     `json:"run_repository,omitempty"`
+	RunID          int64      `json:"run_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	ApprovedBy     string     `json:"approved_by,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// IsBound tells if the private run executing the dispatch is known.
+func (r *DispatchRecord) IsBound() bool {
+	return r.RunID != 0
+}
+
+// bind links the record to the run and moves a queued dispatch to running.
+func (r *DispatchRecord) bind(repository string, runID int64) error {
+	if r.IsBound() && (r.RunRepository != repository || r.RunID != runID) {
+		return fmt.Errorf("dispatch is bound to run %d of %s", r.RunID, r.RunRepository)
+	}
+	now := time.Now()
+	r.RunRepository = repository
+	r.RunID = runID
+	r.UpdatedAt = now
+	if r.State == DispatchQueued || r.State == "" {
+		r.State = DispatchRunning
+		r.StartedAt = &now
+	}
+	return nil
+}
+
+/*
+DispatchStore keeps dispatched pipelines by their bot token.
+Once the private run executing a dispatch is known, the dispatch is bound to the run,
+so events of the run can be traced back to the source event.
+*/
+type DispatchStore interface {
+	Save(record DispatchRecord) error
+	Get(token string) (DispatchRecord, error)
+	// Update applies the change to the stored dispatch and returns the updated record.
+	Update(token string, change func(record *DispatchRecord)) (DispatchRecord, error)
+	// BindRun links the dispatch to the run, it fails if the dispatch is bound to another run already.
+	BindRun(token string, repository string, runID int64) (DispatchRecord, error)
+	GetByRun(repository string, runID int64) (DispatchRecord, error)
+	// List returns the dispatches which are not expired, newest first.
+	List() ([]DispatchRecord, error)
+	Close() error
+}
+
+func BuildDispatchStoreFromConfig(config *config.Config, redisClient *redis.Client) (DispatchStore, error) {
+	switch config.Store.Type {
+	case "", MemoryStoreType:
+		return NewMemoryDispatchStore(), nil
+	case BoltStoreType:
+		return NewBoltDispatchStore(config.Store.Path)
+	case RedisStoreType:
+		if err := requireRedisClient(redisClient); err != nil {
+			return nil, err
+		}
+		return NewRedisDispatchStore(redisClient, RedisKeyPrefix(config.Store.Redis)), nil
+	default:
+		return nil, fmt.Errorf("unknown store type %s", config.Store.Type)
+	}
+}
+
+func runKey(repository string, runID int64) string {
+	return fmt.Sprintf("%s-%v", repository, runID)
+}
+
+func sortDispatchRecords(records []DispatchRecord) []DispatchRecord {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreatedAt.After(records[j].CreatedAt)
+	})
+	return records
+}
+
+type memoryDispatchRun string
+
+type memoryDispatchStore struct {
+	ItemDuration *time.Duration
+	Cache        *cache.Cache
+	mu           sync.Mutex
+}
+
+func NewMemoryDispatchStore() DispatchStore {
+	return &memoryDispatchStore{
+		ItemDuration: &itemExpireDuration,
+		Cache:        cache.New(cacheExpireInterval),
+	}
+}
+
+func (store *memoryDispatchStore) Save(record DispatchRecord) error {
+	store.Cache.Set(record.Token, record, *store.ItemDuration)
+	return nil
+}
+
+func (store *memoryDispatchStore) Get(token string) (DispatchRecord, error) {
+	record, found := store.Cache.Get(token)
+	if !found {
+		return DispatchRecord{}, fmt.Errorf("not found")
+	}
+	return record.(DispatchRecord), nil
+}
+
+func (store *memoryDispatchStore) Update(token string, change func(record *DispatchRecord)) (DispatchRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	record, err := store.Get(token)
+	if err != nil {
+		return record, err
+	}
+	change(&record)
+	record.UpdatedAt = time.Now()
+	store.Cache.Set(token, record, *store.ItemDuration)
+	return record, nil
+}
+
+func (store *memoryDispatchStore) BindRun(token string, repository string, runID int64) (DispatchRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	record, err := store.Get(token)
+	if err != nil {
+		return record, err
+	}
+	if err := record.bind(repository, runID); err != nil {
+		return record, err
+	}
+	store.Cache.Set(token, record, *store.ItemDuration)
+	store.Cache.Set(memoryDispatchRun(runKey(repository, runID)), token, *store.ItemDuration)
+	return record, nil
+}
+
+func (store *memoryDispatchStore) GetByRun(repository string, runID int64) (DispatchRecord, error) {
+	token, found := store.Cache.Get(memoryDispatchRun(runKey(repository, runID)))
+	if !found {
+		return DispatchRecord{}, fmt.Errorf("not found")
+	}
+	return store.Get(token.(string))
+}
+
+func (store *memoryDispatchStore) List() ([]DispatchRecord, error) {
+	var records []DispatchRecord
+	store.Cache.Range(func(key, value interface{}) bool {
+		if record, ok := value.(DispatchRecord); ok {
+			records = append(records, record)
+		}
+		return true
+	})
+	return sortDispatchRecords(records), nil
+}
+
+func (store *memoryDispatchStore) Close() error {
+	store.Cache.Close()
+	return nil
+}
+
+type boltDispatchRecord struct {
+	ExpiresAt time.Time      `json:"expires_at"`
+	Record    DispatchRecord `json:"record"`
+}
+
+type boltDispatchRun struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Token     string    `json:"token"`
+}
+
+type boltDispatchStore struct {
+	ItemDuration *time.Duration
+	db           *bolt.DB
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+func NewBoltDispatchStore(path string) (DispatchStore, error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltDispatchBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltDispatchRunBucket)
+		return err
+	})
+	if err != nil {
+		closeBoltDB(db)
+		return nil, errors.Wrap(err, "can not create dispatch buckets")
+	}
+	store := &boltDispatchStore{
+		ItemDuration: &itemExpireDuration,
+		db:           db,
+		done:         make(chan struct{}),
+	}
+	go store.compactPeriodically(cacheExpireInterval)
+	return store, nil
+}
+
+func (store *boltDispatchStore) put(tx *bolt.Tx, record DispatchRecord) error {
+	data, err := json.Marshal(boltDispatchRecord{
+		ExpiresAt: time.Now().Add(*store.ItemDuration),
+		Record:    record,
+	})
+	if err != nil {
+		return errors.Wrap(err, "can not serialize dispatch record")
+	}
+	return tx.Bucket(boltDispatchBucket).Put([]byte(record.Token), data)
+}
+
+func (store *boltDispatchStore) get(tx *bolt.Tx, token string) (DispatchRecord, error) {
+	data := tx.Bucket(boltDispatchBucket).Get([]byte(token))
+	if data == nil {
+		return DispatchRecord{}, fmt.Errorf("not found")
+	}
+	var record boltDispatchRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return DispatchRecord{}, errors.Wrap(err, "can not deserialize dispatch record")
+	}
+	if record.ExpiresAt.Before(time.Now()) {
+		return DispatchRecord{}, fmt.Errorf("not found")
+	}
+	return record.Record, nil
+}
+
+func (store *boltDispatchStore) Save(record DispatchRecord) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return store.put(tx, record)
+	})
+}
+
+func (store *boltDispatchStore) Get(token string) (DispatchRecord, error) {
+	var record DispatchRecord
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = store.get(tx, token)
+		return err
+	})
+	return record, err
+}
+
+func (store *boltDispatchStore) Update(token string, change func(record *DispatchRecord)) (DispatchRecord, error) {
+	var record DispatchRecord
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		record, err = store.get(tx, token)
+		if err != nil {
+			return err
+		}
+		change(&record)
+		record.UpdatedAt = time.Now()
+		return store.put(tx, record)
+	})
+	return record, err
+}
+
+func (store *boltDispatchStore) BindRun(token string, repository string, runID int64) (DispatchRecord, error) {
+	var record DispatchRecord
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		record, err = store.get(tx, token)
+		if err != nil {
+			return err
+		}
+		if err := record.bind(repository, runID); err != nil {
+			return err
+		}
+		if err := store.put(tx, record); err != nil {
+			return err
+		}
+		data, err := json.Marshal(boltDispatchRun{
+			ExpiresAt: time.Now().Add(*store.ItemDuration),
+			Token:     token,
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltDispatchRunBucket).Put([]byte(runKey(repository, runID)), data)
+	})
+	return record, err
+}
+
+func (store *boltDispatchStore) GetByRun(repository string, runID int64) (DispatchRecord, error) {
+	var record DispatchRecord
+	err := store.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltDispatchRunBucket).Get([]byte(runKey(repository, runID)))
+		if data == nil {
+			return fmt.Errorf("not found")
+		}
+		var run boltDispatchRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return errors.Wrap(err, "can not deserialize dispatch run")
+		}
+		var err error
+		record, err = store.get(tx, run.Token)
+		return err
+	})
+	return record, err
+}
+
+func (store *boltDispatchStore) List() ([]DispatchRecord, error) {
+	var records []DispatchRecord
+	now := time.Now()
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDispatchBucket).ForEach(func(k, v []byte) error {
+			var record boltDispatchRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return errors.Wrapf(err, "can not deserialize dispatch record %s", k)
+			}
+			if record.ExpiresAt.After(now) {
+				records = append(records, record.Record)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortDispatchRecords(records), nil
+}
+
+// Compact removes expired dispatch records and run bindings and returns the number of removed entries.
+func (store *boltDispatchStore) Compact() (int, error) {
+	now := time.Now()
+	records, err := compactBoltBucket(store.db, boltDispatchBucket, func(v []byte) bool {
+		var record boltDispatchRecord
+		return json.Unmarshal(v, &record) != nil || record.ExpiresAt.Before(now)
+	})
+	if err != nil {
+		return 0, err
+	}
+	runs, err := compactBoltBucket(store.db, boltDispatchRunBucket, func(v []byte) bool {
+		var run boltDispatchRun
+		return json.Unmarshal(v, &run) != nil || run.ExpiresAt.Before(now)
+	})
+	return records + runs, err
+}
+
+func (store *boltDispatchStore) compactPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := store.Compact(); err != nil {
+				log.WithError(err).Error("Error occurred while compacting dispatch store")
+			}
+		case <-store.done:
+			return
+		}
+	}
+}
+
+func (store *boltDispatchStore) Close() error {
+	var err error
+	store.closeOnce.Do(func() {
+		close(store.done)
+		err = closeBoltDB(store.db)
+	})
+	return err
+}
+
+type redisDispatchStore struct {
+	ItemDuration *time.Duration
+	client       *redis.Client
+	prefix       string
+}
+
+func NewRedisDispatchStore(client *redis.Client, prefix string) DispatchStore {
+	return &redisDispatchStore{
+		ItemDuration: &itemExpireDuration,
+		client:       client,
+		prefix:       prefix,
+	}
+}
+
+func (store *redisDispatchStore) key(token string) string {
+	return fmt.Sprintf("%s:dispatch:%s", store.prefix, token)
+}
+
+func (store *redisDispatchStore) runKey(repository string, runID int64) string {
+	return fmt.Sprintf("%s:dispatch-run:%s", store.prefix, runKey(repository, runID))
+}
+
+func (store *redisDispatchStore) Save(record DispatchRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "can not serialize dispatch record")
+	}
+	if err := store.client.Set(context.Background(), store.key(record.Token), data, *store.ItemDuration).Err(); err != nil {
+		return errors.Wrap(err, "can not store dispatch record")
+	}
+	return nil
+}
+
+func (store *redisDispatchStore) Get(token string) (DispatchRecord, error) {
+	var record DispatchRecord
+	data, err := store.client.Get(context.Background(), store.key(token)).Bytes()
+	if err == redis.Nil {
+		return record, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return record, errors.Wrap(err, "can not read dispatch record")
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+// update changes the record in a transaction, which fails if the record is modified concurrently.
+func (store *redisDispatchStore) update(token string, change func(record *DispatchRecord) error, extra func(pipe redis.Pipeliner)) (DispatchRecord, error) {
+	var record DispatchRecord
+	ctx := context.Background()
+	err := store.client.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, store.key(token)).Bytes()
+		if err == redis.Nil {
+			return fmt.Errorf("not found")
+		}
+		if err != nil {
+			return errors.Wrap(err, "can not read dispatch record")
+		}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return errors.Wrap(err, "can not deserialize dispatch record")
+		}
+		if err := change(&record); err != nil {
+			return err
+		}
+		data, err = json.Marshal(record)
+		if err != nil {
+			return errors.Wrap(err, "can not serialize dispatch record")
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, store.key(token), data, *store.ItemDuration)
+			if extra != nil {
+				extra(pipe)
+			}
+			return nil
+		})
+		return err
+	}, store.key(token))
+	return record, err
+}
+
+func (store *redisDispatchStore) Update(token string, change func(record *DispatchRecord)) (DispatchRecord, error) {
+	return store.update(token, func(record *DispatchRecord) error {
+		change(record)
+		record.UpdatedAt = time.Now()
+		return nil
+	}, nil)
+}
+
+func (store *redisDispatchStore) BindRun(token string, repository string, runID int64) (DispatchRecord, error) {
+	return store.update(token, func(record *DispatchRecord) error {
+		return record.bind(repository, runID)
+	}, func(pipe redis.Pipeliner) {
+		pipe.Set(context.Background(), store.runKey(repository, runID), token, *store.ItemDuration)
+	})
+}
+
+func (store *redisDispatchStore) GetByRun(repository string, runID int64) (DispatchRecord, error) {
+	token, err := store.client.Get(context.Background(), store.runKey(repository, runID)).Result()
+	if err == redis.Nil {
+		return DispatchRecord{}, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return DispatchRecord{}, errors.Wrap(err, "can not read dispatch run")
+	}
+	return store.Get(token)
+}
+
+func (store *redisDispatchStore) List() ([]DispatchRecord, error) {
+	ctx := context.Background()
+	var keys []string
+	iter := store.client.Scan(ctx, 0, store.key("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Wrap(err, "can not list dispatch records")
+	}
+	records := make([]DispatchRecord, 0, len(keys))
+	if len(keys) == 0 {
+		return records, nil
+	}
+	values, err := store.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read dispatch records")
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Expired between scanning and reading.
+			continue
+		}
+		var record DispatchRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, errors.Wrapf(err, "can not deserialize dispatch record %s", keys[i])
+		}
+		records = append(records, record)
+	}
+	return sortDispatchRecords(records), nil
+}
+
+func (store *redisDispatchStore) Close() error {
+	return nil
+}
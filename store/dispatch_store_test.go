@@ -0,0 +1,79 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mattermost/release-bot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	configs := map[string]config.StoreConfig{
+		MemoryStoreType: {Type: MemoryStoreType},
+		BoltStoreType:   {Type: BoltStoreType, Path: filepath.Join(t.TempDir(), "release-bot.db")},
+		RedisStoreType:  {Type: RedisStoreType, Redis: config.RedisConfig{Address: server.Addr()}},
+	}
+	for name, storeConfig := range configs {
+		t.Run(name, func(t *testing.T) {
+			dispatches, err := BuildDispatchStoreFromConfig(&config.Config{Store: storeConfig}, newTestRedisClient(t, storeConfig))
+			assert.Nil(t, err)
+			defer dispatches.Close()
+
+			record := DispatchRecord{
+				Token:          "bot_token",
+				Pipeline:       "mattermost/private/build.yaml",
+				Repository:     "mattermost/public",
+				CommitHash:     "abc",
+				InstallationID: 100,
+				StatusContext:  "release-bot/build",
+				State:          DispatchQueued,
+				CreatedAt:      time.Now().UTC().Round(time.Second),
+			}
+			assert.Nil(t, dispatches.Save(record))
+			stored, err := dispatches.Get("bot_token")
+			assert.Nil(t, err)
+			assert.Equal(t, record, stored)
+
+			_, err = dispatches.GetByRun("mattermost/private", 42)
+			assert.Error(t, err)
+			_, err = dispatches.BindRun("unknown", "mattermost/private", 42)
+			assert.Error(t, err)
+
+			bound, err := dispatches.BindRun("bot_token", "mattermost/private", 42)
+			assert.Nil(t, err)
+			assert.Equal(t, "mattermost/private", bound.RunRepository)
+			assert.Equal(t, int64(42), bound.RunID)
+			assert.Equal(t, DispatchRunning, bound.State)
+			assert.NotNil(t, bound.StartedAt)
+			stored, err = dispatches.GetByRun("mattermost/private", 42)
+			assert.Nil(t, err)
+			assert.Equal(t, "bot_token", stored.Token)
+			assert.Equal(t, DispatchRunning, stored.State)
+
+			_, err = dispatches.BindRun("bot_token", "mattermost/private", 42)
+			assert.Nil(t, err)
+			_, err = dispatches.BindRun("bot_token", "mattermost/private", 43)
+			assert.Error(t, err)
+
+			updated, err := dispatches.Update("bot_token", func(record *DispatchRecord) {
+				record.State = DispatchCompleted
+				record.Conclusion = "success"
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, DispatchCompleted, updated.State)
+			_, err = dispatches.Update("unknown", func(record *DispatchRecord) {})
+			assert.Error(t, err)
+
+			assert.Nil(t, dispatches.Save(DispatchRecord{Token: "newer", State: DispatchQueued, CreatedAt: record.CreatedAt.Add(time.Minute)}))
+			records, err := dispatches.List()
+			assert.Nil(t, err)
+			assert.Len(t, records, 2)
+			assert.Equal(t, "newer", records[0].Token)
+			assert.Equal(t, "success", records[1].Conclusion)
+		})
+	}
+}
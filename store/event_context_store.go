@@ -1,29 +1,88 @@
 package store
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/akyoto/cache"
+	"github.com/go-redis/redis/v8"
+	"github.com/mattermost/release-bot/config"
 	"github.com/mattermost/release-bot/model"
 )
 
+const (
+	MemoryStoreType = "memory"
+	BoltStoreType   = "bolt"
+	RedisStoreType  = "redis"
+)
+
 var cacheExpireInterval time.Duration
 var itemExpireDuration time.Duration
 
 func init() {
 	cacheExpireInterval = 10 * time.Minute
-	itemExpireDuration = 6 * time.Hour
+	itemExpireDuration = config.EventContextTTL
 }
 
+var (
+	ErrRunMismatch    = errors.New("bot token is bound to another workflow run")
+	ErrBindingExpired = errors.New("workflow run of bot token is completed")
+)
+
 type EventContextStore interface {
-	Store(context model.EventContext, token string)
+	Store(context model.EventContext, token string) error
 	Get(token string) (model.EventContext, error)
+	Delete(token string) error
+	// Bind ties the bot token to the first workflow run redeeming it, other runs can not redeem it afterwards.
+	Bind(token string, repository string, runID int64) error
+	// ExpireBinding makes the bot token bound to the workflow run unusable, once the run is completed.
+	ExpireBinding(repository string, runID int64) error
+	Close() error
+}
+
+// RunBinding is the workflow run a bot token is bound to.
+type RunBinding struct {
+	Repository string `json:"repository"`
+	RunID      int64  `json:"run_id"`
+	Expired    bool   `json:"expired"`
+}
+
+func (b *RunBinding) check(repository string, runID int64) error {
+	if b.Repository != repository || b.RunID != runID {
+		return ErrRunMismatch
+	}
+	if b.Expired {
+		return ErrBindingExpired
+	}
+	return nil
+}
+
+func runBindingKey(repository string, runID int64) string {
+	return fmt.Sprintf("%s/%d", repository, runID)
 }
 
 type eventContextStore struct {
 	ItemDuration *time.Duration
 	Cache        *cache.Cache
+	mu           sync.Mutex
+}
+
+func BuildFromConfig(config *config.Config, redisClient *redis.Client) (EventContextStore, error) {
+	switch config.Store.Type {
+	case "", MemoryStoreType:
+		return NewEventContextStore(), nil
+	case BoltStoreType:
+		return NewBoltEventContextStore(config.Store.Path)
+	case RedisStoreType:
+		if err := requireRedisClient(redisClient); err != nil {
+			return nil, err
+		}
+		return NewRedisEventContextStore(redisClient, RedisKeyPrefix(config.Store.Redis)), nil
+	default:
+		return nil, fmt.Errorf("unknown store type %s", config.Store.Type)
+	}
 }
 
 func NewEventContextStore() EventContextStore {
@@ -33,8 +92,9 @@ func NewEventContextStore() EventContextStore {
 	}
 }
 
-func (store *eventContextStore) Store(eventContext model.EventContext, token string) {
+func (store *eventContextStore) Store(eventContext model.EventContext, token string) error {
 	store.Cache.Set(token, eventContext, *store.ItemDuration)
+	return nil
 }
 
 func (store *eventContextStore) Get(token string) (model.EventContext, error) {
@@ -44,3 +104,46 @@ func (store *eventContextStore) Get(token string) (model.EventContext, error) {
 	}
 	return context.(model.EventContext), nil
 }
+
+func (store *eventContextStore) Delete(token string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if binding, found := store.Cache.Get("binding:" + token); found {
+		store.Cache.Delete("run:" + runBindingKey(binding.(*RunBinding).Repository, binding.(*RunBinding).RunID))
+	}
+	store.Cache.Delete(token)
+	store.Cache.Delete("binding:" + token)
+	return nil
+}
+
+func (store *eventContextStore) Bind(token string, repository string, runID int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, found := store.Cache.Get(token); !found {
+		return fmt.Errorf("not found")
+	}
+	if binding, found := store.Cache.Get("binding:" + token); found {
+		return binding.(*RunBinding).check(repository, runID)
+	}
+	store.Cache.Set("binding:"+token, &RunBinding{Repository: repository, RunID: runID}, *store.ItemDuration)
+	store.Cache.Set("run:"+runBindingKey(repository, runID), token, *store.ItemDuration)
+	return nil
+}
+
+func (store *eventContextStore) ExpireBinding(repository string, runID int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	token, found := store.Cache.Get("run:" + runBindingKey(repository, runID))
+	if !found {
+		return nil
+	}
+	if binding, found := store.Cache.Get("binding:" + token.(string)); found {
+		binding.(*RunBinding).Expired = true
+	}
+	return nil
+}
+
+func (store *eventContextStore) Close() error {
+	store.Cache.Close()
+	return nil
+}
@@ -3,9 +3,12 @@ package store
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mattermost/release-bot/config"
 	"github.com/mattermost/release-bot/model"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,11 +25,19 @@ func TestEventContextStore(t *testing.T) {
 		context, err := store.Get(token)
 		assert.Nil(t, err)
 		assert.Equal(t, event, context)
+
+		assert.Nil(t, store.Delete(token))
+		_, err = store.Get(token)
+		assert.Error(t, err)
 	})
 	t.Run("Context Store Expiry Test", func(t *testing.T) {
 		token := "test"
 		cacheExpireInterval = 5 * time.Millisecond
 		itemExpireDuration = time.Millisecond
+		defer func() {
+			cacheExpireInterval = 10 * time.Minute
+			itemExpireDuration = 6 * time.Hour
+		}()
 		store := NewEventContextStore()
 		event := createWorkflowRunEvent(t)
 		store.Store(event, token)
@@ -38,6 +49,49 @@ func TestEventContextStore(t *testing.T) {
 		assert.Nil(t, context)
 	})
 }
+func TestEventContextStoreRunBinding(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisClient, err := NewRedisClient(config.RedisConfig{Address: server.Addr()})
+	assert.Nil(t, err)
+	boltStore, err := NewBoltEventContextStore(filepath.Join(t.TempDir(), "release-bot.db"))
+	assert.Nil(t, err)
+	stores := map[string]EventContextStore{
+		"memory": NewEventContextStore(),
+		"bolt":   boltStore,
+		"redis":  NewRedisEventContextStore(redisClient, "release-bot"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+			assert.Error(t, store.Bind("unknown", "mattermost/delivery", 1))
+			assert.Nil(t, store.Store(createWorkflowRunEvent(t), "token"))
+
+			assert.Nil(t, store.Bind("token", "mattermost/delivery", 1))
+			assert.Nil(t, store.Bind("token", "mattermost/delivery", 1))
+			assert.Equal(t, ErrRunMismatch, store.Bind("token", "mattermost/delivery", 2))
+			assert.Equal(t, ErrRunMismatch, store.Bind("token", "mattermost/other", 1))
+
+			assert.Nil(t, store.ExpireBinding("mattermost/delivery", 2))
+			assert.Nil(t, store.ExpireBinding("mattermost/delivery", 1))
+			assert.Equal(t, ErrBindingExpired, store.Bind("token", "mattermost/delivery", 1))
+			assert.Equal(t, ErrRunMismatch, store.Bind("token", "mattermost/delivery", 2))
+			// The event context is still known once the run is completed, it is used to retry the pipeline.
+			_, err := store.Get("token")
+			assert.Nil(t, err)
+
+			// A revoked bot token can not be bound again.
+			assert.Nil(t, store.Delete("token"))
+			assert.Error(t, store.Bind("token", "mattermost/delivery", 1))
+
+			// The run of a deleted bot token is forgotten, completing it does not expire a later binding of the token.
+			assert.Nil(t, store.Store(createWorkflowRunEvent(t), "token"))
+			assert.Nil(t, store.Bind("token", "mattermost/delivery", 2))
+			assert.Nil(t, store.ExpireBinding("mattermost/delivery", 1))
+			assert.Nil(t, store.Bind("token", "mattermost/delivery", 2))
+		})
+	}
+}
+
 func createWorkflowRunEvent(t *testing.T) model.EventContext {
 	source, err := os.ReadFile("testdata/workflow_run_event.json")
 	if err != nil {
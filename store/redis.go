@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mattermost/release-bot/config"
+	"github.com/pkg/errors"
+)
+
+const defaultRedisPrefix = "release-bot"
+
+/*
+BuildRedisClientFromConfig creates the redis client shared by the stores if redis is the configured store type, or returns nil.
+Neither the stores nor the client manager close the shared client, its owner closes it once all of them are closed.
+*/
+func BuildRedisClientFromConfig(config *config.Config) (*redis.Client, error) {
+	if config.Store.Type != RedisStoreType {
+		return nil, nil
+	}
+	return NewRedisClient(config.Store.Redis)
+}
+
+// NewRedisClient creates a redis client from store configuration and verifies the connection.
+func NewRedisClient(config config.RedisConfig) (*redis.Client, error) {
+	if config.Address == "" {
+		return nil, errors.New("redis address is not configured")
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Address,
+		Password: config.Password,
+		DB:       config.DB,
+	})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, errors.Wrapf(err, "can not connect to redis at %s", config.Address)
+	}
+	return client, nil
+}
+
+func requireRedisClient(client *redis.Client) error {
+	if client == nil {
+		return errors.New("redis client is not created for the redis store")
+	}
+	return nil
+}
+
+// RedisKeyPrefix returns the configured key prefix which is shared by all release bot replicas.
+func RedisKeyPrefix(config config.RedisConfig) string {
+	if config.Prefix == "" {
+		return defaultRedisPrefix
+	}
+	return config.Prefix
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mattermost/release-bot/model"
+	"github.com/pkg/errors"
+)
+
+type redisEventContextStore struct {
+	ItemDuration *time.Duration
+	client       *redis.Client
+	prefix       string
+}
+
+func NewRedisEventContextStore(client *redis.Client, prefix string) EventContextStore {
+	return &redisEventContextStore{
+		ItemDuration: &itemExpireDuration,
+		client:       client,
+		prefix:       prefix,
+	}
+}
+
+func (store *redisEventContextStore) key(token string) string {
+	return fmt.Sprintf("%s:event-context:%s", store.prefix, token)
+}
+
+func (store *redisEventContextStore) Store(eventContext model.EventContext, token string) error {
+	serialized, err := model.MarshalEventContext(eventContext)
+	if err != nil {
+		return err
+	}
+	if err := store.client.Set(context.Background(), store.key(token), serialized, *store.ItemDuration).Err(); err != nil {
+		return errors.Wrap(err, "can not store event context")
+	}
+	return nil
+}
+
+func (store *redisEventContextStore) Get(token string) (model.EventContext, error) {
+	data, err := store.client.Get(context.Background(), store.key(token)).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read event context")
+	}
+	return model.UnmarshalEventContext(data)
+}
+
+func (store *redisEventContextStore) Delete(token string) error {
+	ctx := context.Background()
+	keys := []string{store.key(token), store.bindingKey(token)}
+	data, err := store.client.Get(ctx, store.bindingKey(token)).Bytes()
+	if err != nil && err != redis.Nil {
+		return errors.Wrap(err, "can not read run binding")
+	}
+	if err == nil {
+		var binding RunBinding
+		if err := json.Unmarshal(data, &binding); err != nil {
+			return errors.Wrap(err, "can not read run binding")
+		}
+		keys = append(keys, store.runKey(binding.Repository, binding.RunID))
+	}
+	if err := store.client.Del(ctx, keys...).Err(); err != nil {
+		return errors.Wrap(err, "can not delete event context")
+	}
+	return nil
+}
+
+func (store *redisEventContextStore) bindingKey(token string) string {
+	return fmt.Sprintf("%s:binding:%s", store.prefix, token)
+}
+
+func (store *redisEventContextStore) runKey(repository string, runID int64) string {
+	return fmt.Sprintf("%s:run-binding:%s", store.prefix, runBindingKey(repository, runID))
+}
+
+// Bind sets the binding only if it is not set yet, so replicas serving concurrent requests agree on the bound run.
+func (store *redisEventContextStore) Bind(token string, repository string, runID int64) error {
+	ctx := context.Background()
+	ttl, err := store.client.PTTL(ctx, store.key(token)).Result()
+	if err != nil {
+		return errors.Wrap(err, "can not read event context")
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("not found")
+	}
+	binding := RunBinding{Repository: repository, RunID: runID}
+	data, err := json.Marshal(binding)
+	if err != nil {
+		return errors.Wrap(err, "can not serialize run binding")
+	}
+	bound, err := store.client.SetNX(ctx, store.bindingKey(token), data, ttl).Result()
+	if err != nil {
+		return errors.Wrap(err, "can not store run binding")
+	}
+	if bound {
+		if err := store.client.Set(ctx, store.runKey(repository, runID), token, ttl).Err(); err != nil {
+			return errors.Wrap(err, "can not store run binding")
+		}
+		return nil
+	}
+	existing, err := store.getBinding(ctx, token)
+	if err != nil {
+		return err
+	}
+	return existing.check(repository, runID)
+}
+
+func (store *redisEventContextStore) ExpireBinding(repository string, runID int64) error {
+	ctx := context.Background()
+	token, err := store.client.Get(ctx, store.runKey(repository, runID)).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "can not read run binding")
+	}
+	binding, err := store.getBinding(ctx, token)
+	if err != nil {
+		return err
+	}
+	binding.Expired = true
+	data, err := json.Marshal(binding)
+	if err != nil {
+		return errors.Wrap(err, "can not serialize run binding")
+	}
+	if err := store.client.Set(ctx, store.bindingKey(token), data, redis.KeepTTL).Err(); err != nil {
+		return errors.Wrap(err, "can not store run binding")
+	}
+	return nil
+}
+
+func (store *redisEventContextStore) getBinding(ctx context.Context, token string) (*RunBinding, error) {
+	data, err := store.client.Get(ctx, store.bindingKey(token)).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read run binding")
+	}
+	var binding RunBinding
+	if err := json.Unmarshal(data, &binding); err != nil {
+		return nil, errors.Wrap(err, "can not read run binding")
+	}
+	return &binding, nil
+}
+
+func (store *redisEventContextStore) Close() error {
+	return nil
+}
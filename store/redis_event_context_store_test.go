@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/mattermost/release-bot/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRedisClient creates the shared redis client of the store config, closed once the test is finished.
+func newTestRedisClient(t *testing.T, storeConfig config.StoreConfig) *redis.Client {
+	redisClient, err := BuildRedisClientFromConfig(&config.Config{Store: storeConfig})
+	assert.Nil(t, err)
+	if redisClient != nil {
+		t.Cleanup(func() { redisClient.Close() })
+	}
+	return redisClient
+}
+
+func TestRedisEventContextStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisConfig := config.RedisConfig{Address: server.Addr()}
+
+	t.Run("Context Store Shared Between Replicas", func(t *testing.T) {
+		token := "test"
+		event := createWorkflowRunEvent(t)
+
+		storeConfig := config.StoreConfig{Type: RedisStoreType, Redis: redisConfig}
+		first, err := BuildFromConfig(&config.Config{Store: storeConfig}, newTestRedisClient(t, storeConfig))
+		assert.Nil(t, err)
+		defer first.Close()
+		second, err := BuildFromConfig(&config.Config{Store: storeConfig}, newTestRedisClient(t, storeConfig))
+		assert.Nil(t, err)
+		defer second.Close()
+
+		assert.Nil(t, first.Store(event, token))
+		assert.True(t, server.Exists("release-bot:event-context:test"))
+
+		context, err := second.Get(token)
+		assert.Nil(t, err)
+		assert.Equal(t, event, context)
+
+		context, err = second.Get("unknown")
+		assert.Error(t, err)
+		assert.Nil(t, context)
+
+		assert.Nil(t, second.Delete(token))
+		_, err = first.Get(token)
+		assert.Error(t, err)
+	})
+	t.Run("Context Store Expiry Test", func(t *testing.T) {
+		store, err := NewRedisClient(redisConfig)
+		assert.Nil(t, err)
+		defer store.Close()
+		contextStore := NewRedisEventContextStore(store, "expiry")
+		defer contextStore.Close()
+		assert.Nil(t, contextStore.Store(createWorkflowRunEvent(t), "test"))
+		assert.Equal(t, 6*time.Hour, server.TTL("expiry:event-context:test"))
+
+		server.FastForward(7 * time.Hour)
+
+		context, err := contextStore.Get("test")
+		assert.Error(t, err)
+		assert.Nil(t, context)
+	})
+	t.Run("Missing Address", func(t *testing.T) {
+		redisClient, err := BuildRedisClientFromConfig(&config.Config{Store: config.StoreConfig{Type: RedisStoreType}})
+		assert.Nil(t, redisClient)
+		assert.Error(t, err)
+	})
+	t.Run("Missing Client", func(t *testing.T) {
+		store, err := BuildFromConfig(&config.Config{Store: config.StoreConfig{Type: RedisStoreType, Redis: redisConfig}}, nil)
+		assert.Nil(t, store)
+		assert.Error(t, err)
+	})
+	t.Run("Client Shared By Stores", func(t *testing.T) {
+		storeConfig := config.StoreConfig{Type: RedisStoreType, Redis: redisConfig}
+		redisClient := newTestRedisClient(t, storeConfig)
+		contextStore, err := BuildFromConfig(&config.Config{Store: storeConfig}, redisClient)
+		assert.Nil(t, err)
+		dispatches, err := BuildDispatchStoreFromConfig(&config.Config{Store: storeConfig}, redisClient)
+		assert.Nil(t, err)
+
+		assert.Nil(t, contextStore.Close())
+		assert.Nil(t, dispatches.Close())
+		assert.Nil(t, redisClient.Ping(context.Background()).Err())
+	})
+}